@@ -0,0 +1,266 @@
+// Package checker defines the model shared by every vulnerability check:
+// a common Checker interface, a typed Status and a Result that carries the
+// verdict, supporting evidence, timings and any error.
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Status is the verdict of a vulnerability check.
+type Status string
+
+// Check verdicts. The string values match the ones historically
+// reported in the "vulnerable" field of each check.
+const (
+	StatusNotApplicable Status = "n/a"
+	StatusNotVulnerable Status = "no"
+	StatusVulnerable    Status = "yes"
+	StatusError         Status = "error"
+)
+
+// ParseStatus converts a string into a Status.
+func ParseStatus(s string) (Status, error) {
+	switch st := Status(s); st {
+	case StatusNotApplicable, StatusNotVulnerable, StatusVulnerable, StatusError:
+		return st, nil
+	default:
+		return "", fmt.Errorf("unknown status %q", s)
+	}
+}
+
+// Target identifies the endpoint a check runs against.
+type Target struct {
+	Host string `json:"host"`
+	Port string `json:"port"`
+	// ServerName is presented in the TLS server_name extension instead
+	// of Host, so an IP can be dialed while asking for a virtual host.
+	ServerName string `json:"server_name,omitempty"`
+}
+
+// ParseTarget splits a host:port string into a Target.
+func ParseTarget(s string) (Target, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return Target{}, err
+	}
+
+	if host == "" || port == "" {
+		return Target{}, fmt.Errorf("invalid target %q: host and port are required", s)
+	}
+
+	return Target{Host: host, Port: port}, nil
+}
+
+// String returns the target as host:port.
+func (t Target) String() string {
+	return net.JoinHostPort(t.Host, t.Port)
+}
+
+// SNI returns the name to send in the server_name extension: ServerName
+// when set, otherwise Host unless it is an IP address.
+func (t Target) SNI() string {
+	if t.ServerName != "" {
+		return strings.TrimSuffix(t.ServerName, ".")
+	}
+
+	return DefaultServerName(t.Host)
+}
+
+// WithServerNames returns one copy of t per name, for sweeping the
+// virtual hosts served from a single address.
+func (t Target) WithServerNames(names ...string) []Target {
+	targets := make([]Target, 0, len(names))
+
+	for _, name := range names {
+		vhost := t
+		vhost.ServerName = name
+		targets = append(targets, vhost)
+	}
+
+	return targets
+}
+
+// DefaultServerName returns the server_name to send when dialing host
+// without an explicit name. IP addresses are not valid server names
+// (RFC 6066), so they yield an empty string.
+func DefaultServerName(host string) string {
+	if net.ParseIP(host) != nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(host, ".")
+}
+
+// Timings records when a check started and finished.
+type Timings struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Duration returns how long the check took.
+func (t Timings) Duration() time.Duration {
+	if t.End.IsZero() {
+		return 0
+	}
+
+	return t.End.Sub(t.Start)
+}
+
+// Result is the outcome of running a Checker against a Target.
+type Result struct {
+	Check    string            `json:"check"`
+	Target   Target            `json:"target"`
+	Status   Status            `json:"status"`
+	Evidence map[string]string `json:"evidence,omitempty"`
+	Timings  Timings           `json:"timings"`
+	Err      error             `json:"-"`
+}
+
+// NewResult returns a Result for the named check with its start time set.
+func NewResult(check string, target Target) Result {
+	return Result{
+		Check:   check,
+		Target:  target,
+		Timings: Timings{Start: time.Now()},
+	}
+}
+
+// AddEvidence records a piece of supporting evidence on the result.
+func (r *Result) AddEvidence(key, value string) {
+	if r.Evidence == nil {
+		r.Evidence = make(map[string]string)
+	}
+
+	r.Evidence[key] = value
+}
+
+// Finish sets the verdict, error and end time of the result. An empty
+// status combined with a non-nil error is reported as StatusError.
+func (r *Result) Finish(status Status, err error) {
+	if status == "" && err != nil {
+		status = StatusError
+	}
+
+	r.Status = status
+	r.Err = err
+	r.Timings.End = time.Now()
+}
+
+// MarshalJSON encodes the result with its error rendered as a string.
+func (r Result) MarshalJSON() ([]byte, error) {
+	type result Result
+
+	var errMsg string
+	if r.Err != nil {
+		errMsg = r.Err.Error()
+	}
+
+	return json.Marshal(struct {
+		result
+
+		Error string `json:"error,omitempty"`
+	}{
+		result: result(r),
+		Error:  errMsg,
+	})
+}
+
+// Checker is implemented by every vulnerability check so that callers
+// can run any of them uniformly.
+type Checker interface {
+	// Name returns the short, stable identifier of the check.
+	Name() string
+	// Check runs the check against target and returns its result.
+	Check(ctx context.Context, target Target) Result
+}
+
+// Dialer opens the connections used by network checks. *net.Dialer and
+// the dialers in the proxy package satisfy it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Protocol is the plaintext protocol spoken before TLS is started on a
+// connection, for endpoints that upgrade with STARTTLS or a similar
+// exchange.
+type Protocol string
+
+// Protocols understood by the network checks. The empty Protocol means
+// it is inferred from the port.
+const (
+	ProtocolNone     Protocol = "none"
+	ProtocolSMTP     Protocol = "smtp"
+	ProtocolIMAP     Protocol = "imap"
+	ProtocolPOP3     Protocol = "pop3"
+	ProtocolFTP      Protocol = "ftp"
+	ProtocolXMPP     Protocol = "xmpp"
+	ProtocolLDAP     Protocol = "ldap"
+	ProtocolPostgres Protocol = "postgres"
+	ProtocolMySQL    Protocol = "mysql"
+	ProtocolRDP      Protocol = "rdp"
+)
+
+// Protocols lists every Protocol, for validation and help output.
+var Protocols = []Protocol{
+	ProtocolNone, ProtocolSMTP, ProtocolIMAP, ProtocolPOP3, ProtocolFTP, ProtocolXMPP, ProtocolLDAP,
+	ProtocolPostgres, ProtocolMySQL, ProtocolRDP,
+}
+
+// ParseProtocol converts a string into a Protocol. The empty string is
+// accepted and means the protocol is inferred from the port.
+func ParseProtocol(s string) (Protocol, error) {
+	p := Protocol(strings.ToLower(s))
+	if p == "" || slices.Contains(Protocols, p) {
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown protocol %q", s)
+}
+
+// Timeouts holds the per-phase time limits of a network check. A zero
+// field falls back to the check's own default. The caller's context
+// still bounds the check as a whole.
+type Timeouts struct {
+	// Dial limits establishing the TCP connection.
+	Dial time.Duration `json:"dial,omitempty"`
+	// StartTLS limits the plaintext STARTTLS negotiation.
+	StartTLS time.Duration `json:"starttls,omitempty"`
+	// Handshake limits reading the server's handshake messages.
+	Handshake time.Duration `json:"handshake,omitempty"`
+	// Write limits each write to the connection.
+	Write time.Duration `json:"write,omitempty"`
+	// Read limits waiting for the server's reply to a probe.
+	Read time.Duration `json:"read,omitempty"`
+}
+
+// WithDefaults returns t with every zero field taken from defaults.
+func (t Timeouts) WithDefaults(defaults Timeouts) Timeouts {
+	if t.Dial == 0 {
+		t.Dial = defaults.Dial
+	}
+
+	if t.StartTLS == 0 {
+		t.StartTLS = defaults.StartTLS
+	}
+
+	if t.Handshake == 0 {
+		t.Handshake = defaults.Handshake
+	}
+
+	if t.Write == 0 {
+		t.Write = defaults.Write
+	}
+
+	if t.Read == 0 {
+		t.Read = defaults.Read
+	}
+
+	return t
+}
@@ -0,0 +1,123 @@
+package checker
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	for _, s := range []Status{StatusNotApplicable, StatusNotVulnerable, StatusVulnerable, StatusError} {
+		got, err := ParseStatus(string(s))
+		if err != nil {
+			t.Fatalf("ParseStatus(%q) returned error: %v", s, err)
+		}
+
+		if got != s {
+			t.Errorf("ParseStatus(%q) = %q", s, got)
+		}
+	}
+
+	_, err := ParseStatus("maybe")
+	if err == nil {
+		t.Errorf("expected error for unknown status")
+	}
+}
+
+func TestParseProtocol(t *testing.T) {
+	for _, s := range []string{"", "none", "SMTP", "xmpp"} {
+		_, err := ParseProtocol(s)
+		if err != nil {
+			t.Errorf("ParseProtocol(%q) returned error: %v", s, err)
+		}
+	}
+
+	_, err := ParseProtocol("gopher")
+	if err == nil {
+		t.Errorf("expected error for unknown protocol")
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	target, err := ParseTarget("example.com:443")
+	if err != nil {
+		t.Fatalf("ParseTarget returned error: %v", err)
+	}
+
+	if target.Host != "example.com" || target.Port != "443" {
+		t.Errorf("wrong target, got: %+v", target)
+	}
+
+	if target.String() != "example.com:443" {
+		t.Errorf("wrong string, got: %s", target.String())
+	}
+
+	for _, s := range []string{"example.com", ":443", "example.com:"} {
+		_, err = ParseTarget(s)
+		if err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestTargetSNI(t *testing.T) {
+	tests := []struct {
+		target Target
+		want   string
+	}{
+		{Target{Host: "example.com", Port: "443"}, "example.com"},
+		{Target{Host: "example.com.", Port: "443"}, "example.com"},
+		{Target{Host: "192.0.2.1", Port: "443"}, ""},
+		{Target{Host: "2001:db8::1", Port: "443"}, ""},
+		{Target{Host: "192.0.2.1", Port: "443", ServerName: "vhost.example.com"}, "vhost.example.com"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.target.SNI(); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.target, got, tt.want)
+		}
+	}
+
+	base := Target{Host: "192.0.2.1", Port: "443"}
+
+	targets := base.WithServerNames("a.example.com", "b.example.com")
+	if len(targets) != 2 || targets[1].ServerName != "b.example.com" || targets[1].String() != "192.0.2.1:443" {
+		t.Errorf("wrong sweep targets: %+v", targets)
+	}
+}
+
+func TestResultFinish(t *testing.T) {
+	res := NewResult("test", Target{Host: "127.0.0.1", Port: "443"})
+	res.Finish("", errors.New("boom"))
+
+	if res.Status != StatusError {
+		t.Errorf("wrong status, got: %s, want: %s", res.Status, StatusError)
+	}
+
+	if res.Timings.End.IsZero() || res.Timings.Duration() < 0 {
+		t.Errorf("timings not recorded: %+v", res.Timings)
+	}
+}
+
+func TestResultMarshalJSON(t *testing.T) {
+	res := NewResult("test", Target{Host: "127.0.0.1", Port: "443"})
+	res.AddEvidence("extension", "true")
+	res.Finish(StatusVulnerable, errors.New("partial read"))
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatalf("Marshal returned error: %v", err)
+	}
+
+	for _, want := range []string{
+		`"check":"test"`,
+		`"status":"yes"`,
+		`"evidence":{"extension":"true"}`,
+		`"error":"partial read"`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("JSON %s does not contain %s", data, want)
+		}
+	}
+}
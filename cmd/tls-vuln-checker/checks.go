@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/vulnerabilities/ccs"
+	"github.com/jsandas/tls-vuln-checker/vulnerabilities/debianweakkey"
+	"github.com/jsandas/tls-vuln-checker/vulnerabilities/heartbleed"
+	"github.com/jsandas/tls-vuln-checker/vulnerabilities/poodle"
+)
+
+// config holds the command-line settings shared by every check.
+type config struct {
+	tlsVersion       int
+	timeouts         checker.Timeouts
+	dialer           checker.Dialer
+	protocol         checker.Protocol
+	captureLeak      bool
+	heartbleedPhases []heartbleed.Phase
+	heartbleedSweep  heartbleed.Sweep
+	dtls             bool
+}
+
+// registry maps each check name accepted by -checks to its constructor.
+// New checks only need an entry here to become selectable.
+var registry = map[string]func(cfg config) checker.Checker{
+	heartbleed.Name: func(cfg config) checker.Checker {
+		return &heartbleed.Checker{
+			TLSVersion:  cfg.tlsVersion,
+			Timeouts:    cfg.timeouts,
+			Dialer:      cfg.dialer,
+			Protocol:    cfg.protocol,
+			CaptureLeak: cfg.captureLeak,
+			Phases:      cfg.heartbleedPhases,
+			Sweep:       cfg.heartbleedSweep,
+			DTLS:        cfg.dtls,
+		}
+	},
+	ccs.Name: func(cfg config) checker.Checker {
+		return &ccs.Checker{Timeouts: cfg.timeouts, Dialer: cfg.dialer, Protocol: cfg.protocol}
+	},
+	poodle.Name: func(cfg config) checker.Checker {
+		return &poodle.Checker{Timeouts: cfg.timeouts, Dialer: cfg.dialer, Protocol: cfg.protocol}
+	},
+	debianweakkey.Name: func(cfg config) checker.Checker {
+		return &debianweakkey.Checker{Timeouts: cfg.timeouts, Dialer: cfg.dialer, Protocol: cfg.protocol}
+	},
+}
+
+var defaultChecks = []string{heartbleed.Name, ccs.Name, poodle.Name}
+
+// checkNames returns the registered check names in sorted order.
+func checkNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// selectChecks builds the checkers named in a comma-separated list.
+func selectChecks(list string, cfg config) ([]checker.Checker, error) {
+	var checks []checker.Checker
+
+	seen := make(map[string]bool)
+
+	for _, name := range splitList(list) {
+		name = strings.ToLower(name)
+		if seen[name] {
+			continue
+		}
+
+		newCheck, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown check %q (available: %s)", name, strings.Join(checkNames(), ", "))
+		}
+
+		seen[name] = true
+
+		checks = append(checks, newCheck(cfg))
+	}
+
+	if len(checks) == 0 {
+		return nil, fmt.Errorf("no checks selected")
+	}
+
+	return checks, nil
+}
+
+// protocolNames lists the values accepted by -starttls.
+func protocolNames() string {
+	names := make([]string, len(checker.Protocols))
+	for i, proto := range checker.Protocols {
+		names[i] = string(proto)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// parseTLSVersion parses the -tls-version value: a version from
+// tlsVersions or one of the heartbleed sweep modes.
+func parseTLSVersion(s string) (int, heartbleed.Sweep, error) {
+	switch s {
+	case "sweep":
+		return 0, heartbleed.SweepFirst, nil
+	case "all":
+		return 0, heartbleed.SweepAll, nil
+	}
+
+	version, ok := tlsVersions[s]
+	if !ok {
+		return 0, heartbleed.SweepOff, fmt.Errorf("unknown TLS version %q", s)
+	}
+
+	return version, heartbleed.SweepOff, nil
+}
+
+// parsePhases parses the -heartbleed-phases list.
+func parsePhases(list string) ([]heartbleed.Phase, error) {
+	var phases []heartbleed.Phase
+
+	for _, item := range splitList(list) {
+		phase := heartbleed.Phase(strings.ToLower(item))
+		if phase != heartbleed.PhaseHandshake && phase != heartbleed.PhasePostHandshake {
+			return nil, fmt.Errorf("unknown heartbleed phase %q", item)
+		}
+
+		phases = append(phases, phase)
+	}
+
+	return phases, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(list string) []string {
+	var items []string
+
+	for item := range strings.SplitSeq(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,311 @@
+// Command tls-vuln-checker runs the vulnerability checks of this module
+// against one or more host:port targets.
+//
+// Usage:
+//
+//	tls-vuln-checker [flags] host:port [host:port ...]
+//
+// The exit status is 0 when no target is vulnerable, 1 when at least one
+// check reported a vulnerability, 2 on usage errors and 3 when a check
+// failed without finding a vulnerability. This makes the command usable
+// as a CI gate.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/proxy"
+	"github.com/jsandas/tls-vuln-checker/scanner"
+)
+
+// Exit codes.
+const (
+	exitOK         = 0
+	exitVulnerable = 1
+	exitUsage      = 2
+	exitError      = 3
+)
+
+var tlsVersions = map[string]int{
+	"1.0": 0x0301,
+	"1.1": 0x0302,
+	"1.2": 0x0303,
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+
+	stop()
+	os.Exit(code)
+}
+
+// run parses args, runs the selected checks and writes the results to
+// stdout. It returns the process exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("tls-vuln-checker", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	checkList := flags.String("checks", strings.Join(defaultChecks, ","),
+		"comma-separated checks to run: "+strings.Join(checkNames(), ", "))
+	format := flags.String("format", "text", "output format: text or json")
+	tlsVersion := flags.String("tls-version", "1.2",
+		"protocol version offered by heartbleed: 1.0, 1.1 or 1.2; "+
+			"sweep tries SSLv3 to 1.2 until one is conclusive, all tries each")
+	timeout := flags.Duration("timeout", time.Minute, "overall time limit for each check")
+	dialTimeout := flags.Duration("dial-timeout", 0, "time limit for connecting (0 uses the check default)")
+	readTimeout := flags.Duration("read-timeout", 0, "time limit for probe replies (0 uses the check default)")
+	serverNames := flags.String("server-name", "",
+		"comma-separated names to send as SNI; each target is checked once per name")
+	startTLS := flags.String("starttls", "",
+		"protocol to upgrade with STARTTLS before TLS: "+protocolNames()+" (empty infers it from the port)")
+	captureLeak := flags.Bool("capture-leak", false,
+		"scan memory leaked by heartbleed for secrets and report redacted findings")
+	phases := flags.String("heartbleed-phases", "handshake",
+		"comma-separated phases heartbleed sends its heartbeat in: handshake, post_handshake")
+	dtls := flags.Bool("heartbleed-dtls", false,
+		"run heartbleed over UDP with DTLS (1.2 for -tls-version 1.2, else 1.0)")
+	proxyURL := flags.String("proxy", "", "proxy URL, e.g. socks5://host:1080 or http://host:3128")
+	workers := flags.Int("workers", scanner.DefaultWorkers, "number of checks run concurrently")
+	rate := flags.Float64("rate", 0, "maximum checks started per second (0 is unlimited)")
+	hostRate := flags.Float64("host-rate", 0, "maximum checks started per second against one host (0 is unlimited)")
+
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: tls-vuln-checker [flags] host:port [host:port ...]\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+
+		return exitUsage
+	}
+
+	if *format != "text" && *format != "json" {
+		return usageError(stderr, fmt.Errorf("unknown format %q", *format))
+	}
+
+	if flags.NArg() == 0 {
+		return usageError(stderr, errors.New("no targets given"))
+	}
+
+	targets := make([]checker.Target, 0, flags.NArg())
+
+	for _, arg := range flags.Args() {
+		target, err := checker.ParseTarget(arg)
+		if err != nil {
+			return usageError(stderr, err)
+		}
+
+		if *serverNames == "" {
+			targets = append(targets, target)
+
+			continue
+		}
+
+		targets = append(targets, target.WithServerNames(splitList(*serverNames)...)...)
+	}
+
+	cfg := config{
+		timeouts:    checker.Timeouts{Dial: *dialTimeout, Read: *readTimeout},
+		captureLeak: *captureLeak,
+		dtls:        *dtls,
+	}
+
+	cfg.tlsVersion, cfg.heartbleedSweep, err = parseTLSVersion(*tlsVersion)
+	if err != nil {
+		return usageError(stderr, err)
+	}
+
+	cfg.protocol, err = checker.ParseProtocol(*startTLS)
+	if err != nil {
+		return usageError(stderr, err)
+	}
+
+	cfg.heartbleedPhases, err = parsePhases(*phases)
+	if err != nil {
+		return usageError(stderr, err)
+	}
+
+	if *proxyURL != "" {
+		u, err := url.Parse(*proxyURL)
+		if err != nil {
+			return usageError(stderr, err)
+		}
+
+		cfg.dialer, err = proxy.FromURL(u, nil)
+		if err != nil {
+			return usageError(stderr, err)
+		}
+	}
+
+	checks, err := selectChecks(*checkList, cfg)
+	if err != nil {
+		return usageError(stderr, err)
+	}
+
+	s := &scanner.Scanner{
+		Checks:   checks,
+		Workers:  *workers,
+		Rate:     *rate,
+		HostRate: *hostRate,
+		Timeout:  *timeout,
+	}
+
+	var results []checker.Result
+	for res := range s.Scan(ctx, targets) {
+		results = append(results, res)
+	}
+
+	sortResults(results, targets, checks)
+
+	if *format == "json" {
+		err = writeJSON(stdout, results)
+	} else {
+		err = writeText(stdout, results)
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+
+		return exitError
+	}
+
+	return exitCode(results)
+}
+
+// sortResults restores the order of the command line: target by target,
+// checks in the order they were selected.
+func sortResults(results []checker.Result, targets []checker.Target, checks []checker.Checker) {
+	targetOrder := make(map[checker.Target]int, len(targets))
+	for i, t := range targets {
+		if _, ok := targetOrder[t]; !ok {
+			targetOrder[t] = i
+		}
+	}
+
+	checkOrder := make(map[string]int, len(checks))
+	for i, c := range checks {
+		checkOrder[c.Name()] = i
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		ti, tj := targetOrder[results[i].Target], targetOrder[results[j].Target]
+		if ti != tj {
+			return ti < tj
+		}
+
+		return checkOrder[results[i].Check] < checkOrder[results[j].Check]
+	})
+}
+
+func usageError(stderr io.Writer, err error) int {
+	fmt.Fprintln(stderr, "error:", err)
+	fmt.Fprintln(stderr, "Run 'tls-vuln-checker -h' for usage.")
+
+	return exitUsage
+}
+
+// exitCode derives the process exit code from the results: any
+// vulnerability wins over any error.
+func exitCode(results []checker.Result) int {
+	code := exitOK
+
+	for _, res := range results {
+		switch res.Status {
+		case checker.StatusVulnerable:
+			return exitVulnerable
+		case checker.StatusError:
+			code = exitError
+		}
+	}
+
+	return code
+}
+
+func writeJSON(w io.Writer, results []checker.Result) error {
+	if results == nil {
+		results = []checker.Result{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(results)
+}
+
+func writeText(w io.Writer, results []checker.Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "TARGET\tCHECK\tSTATUS\tDURATION\tDETAILS")
+
+	for _, res := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", targetText(res.Target), res.Check, statusText(res.Status),
+			res.Timings.Duration().Round(time.Millisecond), details(res))
+	}
+
+	return tw.Flush()
+}
+
+// targetText renders a target, with the SNI name when one was set.
+func targetText(t checker.Target) string {
+	if t.ServerName == "" {
+		return t.String()
+	}
+
+	return t.String() + " (" + t.ServerName + ")"
+}
+
+func statusText(s checker.Status) string {
+	switch s {
+	case checker.StatusVulnerable:
+		return "VULNERABLE"
+	case checker.StatusNotVulnerable:
+		return "not vulnerable"
+	case checker.StatusNotApplicable:
+		return "not applicable"
+	case checker.StatusError:
+		return "error"
+	default:
+		return string(s)
+	}
+}
+
+// details renders the evidence and error of a result on one line.
+func details(res checker.Result) string {
+	keys := make([]string, 0, len(res.Evidence))
+	for k := range res.Evidence {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		parts = append(parts, k+"="+res.Evidence[k])
+	}
+
+	if res.Err != nil {
+		parts = append(parts, "error="+res.Err.Error())
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+)
+
+// fakeChecker reports a fixed status without touching the network.
+type fakeChecker struct {
+	status checker.Status
+}
+
+func (f *fakeChecker) Name() string {
+	return "fake-" + string(f.status)
+}
+
+func (f *fakeChecker) Check(ctx context.Context, target checker.Target) checker.Result {
+	res := checker.NewResult(f.Name(), target)
+	res.AddEvidence("fake", "true")
+	res.Finish(f.status, nil)
+
+	return res
+}
+
+func withFakeChecks(t *testing.T) {
+	t.Helper()
+
+	for _, status := range []checker.Status{checker.StatusVulnerable, checker.StatusNotVulnerable} {
+		name := "fake-" + string(status)
+		registry[name] = func(config) checker.Checker { return &fakeChecker{status: status} }
+
+		t.Cleanup(func() { delete(registry, name) })
+	}
+}
+
+func TestRunUsageErrors(t *testing.T) {
+	tests := [][]string{
+		{},
+		{"-checks", "nope", "127.0.0.1:443"},
+		{"-format", "xml", "127.0.0.1:443"},
+		{"-tls-version", "1.3", "127.0.0.1:443"},
+		{"-starttls", "gopher", "127.0.0.1:443"},
+		{"-heartbleed-phases", "later", "127.0.0.1:443"},
+		{"-proxy", "ftp://proxy", "127.0.0.1:443"},
+		{"127.0.0.1"},
+		{"-bogus-flag"},
+	}
+
+	for _, args := range tests {
+		var stdout, stderr bytes.Buffer
+
+		code := run(context.Background(), args, &stdout, &stderr)
+		if code != exitUsage {
+			t.Errorf("run(%q) = %d, want %d; stderr: %s", args, code, exitUsage, stderr.String())
+		}
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	withFakeChecks(t)
+
+	tests := []struct {
+		checks string
+		want   int
+	}{
+		{checks: "fake-no", want: exitOK},
+		{checks: "fake-no,fake-yes", want: exitVulnerable},
+		{checks: "ccs", want: exitError},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+
+		// port 1 is assumed closed so network checks fail fast
+		code := run(context.Background(), []string{"-checks", tt.checks, "127.0.0.1:1"}, &stdout, &stderr)
+		if code != tt.want {
+			t.Errorf("checks %s: got exit code %d, want %d; output: %s", tt.checks, code, tt.want, stdout.String())
+		}
+	}
+}
+
+func TestRunJSONOutput(t *testing.T) {
+	withFakeChecks(t)
+
+	var stdout, stderr bytes.Buffer
+
+	code := run(context.Background(),
+		[]string{"-format", "json", "-checks", "fake-yes,fake-no", "a.example:443", "b.example:8443"},
+		&stdout, &stderr)
+	if code != exitVulnerable {
+		t.Fatalf("got exit code %d, want %d", code, exitVulnerable)
+	}
+
+	var results []map[string]any
+
+	err := json.Unmarshal(stdout.Bytes(), &results)
+	if err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, stdout.String())
+	}
+
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+
+	// results come back in command-line order despite running concurrently
+	want := []string{"a.example fake-yes", "a.example fake-no", "b.example fake-yes", "b.example fake-no"}
+	for i, res := range results {
+		got := fmt.Sprintf("%v %v", res["target"].(map[string]any)["host"], res["check"])
+		if got != want[i] {
+			t.Errorf("result %d: got %s, want %s", i, got, want[i])
+		}
+	}
+
+	if results[2]["status"] != "yes" {
+		t.Errorf("unexpected result: %v", results[2])
+	}
+}
+
+func TestRunTextOutput(t *testing.T) {
+	withFakeChecks(t)
+
+	var stdout, stderr bytes.Buffer
+
+	run(context.Background(), []string{"-checks", "fake-yes", "a.example:443"}, &stdout, &stderr)
+
+	out := stdout.String()
+	for _, want := range []string{"TARGET", "a.example:443", "fake-yes", "VULNERABLE", "fake=true"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("text output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunServerNameSweep(t *testing.T) {
+	withFakeChecks(t)
+
+	var stdout, stderr bytes.Buffer
+
+	run(context.Background(),
+		[]string{"-checks", "fake-no", "-server-name", "a.example.com, b.example.com", "192.0.2.1:443"},
+		&stdout, &stderr)
+
+	out := stdout.String()
+	for _, want := range []string{"192.0.2.1:443 (a.example.com)", "192.0.2.1:443 (b.example.com)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("text output missing %q:\n%s", want, out)
+		}
+	}
+}
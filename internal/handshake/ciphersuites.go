@@ -0,0 +1,140 @@
+package handshake
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KeyExchange identifies how a cipher suite establishes the premaster
+// secret.
+type KeyExchange uint8
+
+// Key exchanges. Only DHE, ECDHE and SRP send a ServerKeyExchange that
+// this package can decode or that matters to the checks.
+const (
+	KeyExchangeUnknown KeyExchange = iota
+	KeyExchangeRSA
+	KeyExchangeDHE
+	KeyExchangeECDHE
+	KeyExchangeECDH
+	KeyExchangeSRP
+)
+
+// CipherSuite describes a TLS cipher suite.
+type CipherSuite struct {
+	ID          uint16
+	Name        string
+	KeyExchange KeyExchange
+}
+
+// cipherSuiteNames lists the suites offered by the checks.
+var cipherSuiteNames = map[uint16]string{
+	0x0003: "TLS_RSA_EXPORT_WITH_RC4_40_MD5",
+	0x0004: "TLS_RSA_WITH_RC4_128_MD5",
+	0x0005: "TLS_RSA_WITH_RC4_128_SHA",
+	0x0006: "TLS_RSA_EXPORT_WITH_RC2_CBC_40_MD5",
+	0x0007: "TLS_RSA_WITH_IDEA_CBC_SHA",
+	0x0008: "TLS_RSA_EXPORT_WITH_DES40_CBC_SHA",
+	0x0009: "TLS_RSA_WITH_DES_CBC_SHA",
+	0x000a: "TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x0011: "TLS_DHE_DSS_EXPORT_WITH_DES40_CBC_SHA",
+	0x0012: "TLS_DHE_DSS_WITH_DES_CBC_SHA",
+	0x0013: "TLS_DHE_DSS_WITH_3DES_EDE_CBC_SHA",
+	0x0014: "TLS_DHE_RSA_EXPORT_WITH_DES40_CBC_SHA",
+	0x0015: "TLS_DHE_RSA_WITH_DES_CBC_SHA",
+	0x0016: "TLS_DHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x002f: "TLS_RSA_WITH_AES_128_CBC_SHA",
+	0x0032: "TLS_DHE_DSS_WITH_AES_128_CBC_SHA",
+	0x0033: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA",
+	0x0035: "TLS_RSA_WITH_AES_256_CBC_SHA",
+	0x0038: "TLS_DHE_DSS_WITH_AES_256_CBC_SHA",
+	0x0039: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA",
+	0x003c: "TLS_RSA_WITH_AES_128_CBC_SHA256",
+	0x003d: "TLS_RSA_WITH_AES_256_CBC_SHA256",
+	0x0041: "TLS_RSA_WITH_CAMELLIA_128_CBC_SHA",
+	0x0044: "TLS_DHE_DSS_WITH_CAMELLIA_128_CBC_SHA",
+	0x0045: "TLS_DHE_RSA_WITH_CAMELLIA_128_CBC_SHA",
+	0x0067: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA256",
+	0x006b: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA256",
+	0x0084: "TLS_RSA_WITH_CAMELLIA_256_CBC_SHA",
+	0x0087: "TLS_DHE_DSS_WITH_CAMELLIA_256_CBC_SHA",
+	0x0088: "TLS_DHE_RSA_WITH_CAMELLIA_256_CBC_SHA",
+	0x0096: "TLS_RSA_WITH_SEED_CBC_SHA",
+	0x0099: "TLS_DHE_DSS_WITH_SEED_CBC_SHA",
+	0x009a: "TLS_DHE_RSA_WITH_SEED_CBC_SHA",
+	0x009c: "TLS_RSA_WITH_AES_128_GCM_SHA256",
+	0x009d: "TLS_RSA_WITH_AES_256_GCM_SHA384",
+	0x009e: "TLS_DHE_RSA_WITH_AES_128_GCM_SHA256",
+	0x009f: "TLS_DHE_RSA_WITH_AES_256_GCM_SHA384",
+	0xc002: "TLS_ECDH_ECDSA_WITH_RC4_128_SHA",
+	0xc003: "TLS_ECDH_ECDSA_WITH_3DES_EDE_CBC_SHA",
+	0xc004: "TLS_ECDH_ECDSA_WITH_AES_128_CBC_SHA",
+	0xc005: "TLS_ECDH_ECDSA_WITH_AES_256_CBC_SHA",
+	0xc007: "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA",
+	0xc008: "TLS_ECDHE_ECDSA_WITH_3DES_EDE_CBC_SHA",
+	0xc009: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	0xc00a: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+	0xc00c: "TLS_ECDH_RSA_WITH_RC4_128_SHA",
+	0xc00d: "TLS_ECDH_RSA_WITH_3DES_EDE_CBC_SHA",
+	0xc00e: "TLS_ECDH_RSA_WITH_AES_128_CBC_SHA",
+	0xc00f: "TLS_ECDH_RSA_WITH_AES_256_CBC_SHA",
+	0xc011: "TLS_ECDHE_RSA_WITH_RC4_128_SHA",
+	0xc012: "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	0xc013: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	0xc014: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	0xc01b: "TLS_SRP_SHA_RSA_WITH_3DES_EDE_CBC_SHA",
+	0xc01c: "TLS_SRP_SHA_DSS_WITH_3DES_EDE_CBC_SHA",
+	0xc01e: "TLS_SRP_SHA_RSA_WITH_AES_128_CBC_SHA",
+	0xc01f: "TLS_SRP_SHA_DSS_WITH_AES_128_CBC_SHA",
+	0xc021: "TLS_SRP_SHA_RSA_WITH_AES_256_CBC_SHA",
+	0xc022: "TLS_SRP_SHA_DSS_WITH_AES_256_CBC_SHA",
+	0xc023: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256",
+	0xc024: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384",
+	0xc027: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256",
+	0xc028: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384",
+	0xc02b: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	0xc02c: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	0xc02f: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	0xc030: "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	0xcca8: "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	0xcca9: "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+}
+
+// CipherSuiteByID looks up a cipher suite. It reports false for suites
+// this package does not know.
+func CipherSuiteByID(id uint16) (CipherSuite, bool) {
+	name, ok := cipherSuiteNames[id]
+	if !ok {
+		return CipherSuite{ID: id}, false
+	}
+
+	return CipherSuite{ID: id, Name: name, KeyExchange: keyExchangeOf(name)}, true
+}
+
+// CipherSuiteName returns the standard name of a suite, or its hex value
+// for unknown suites.
+func CipherSuiteName(id uint16) string {
+	name, ok := cipherSuiteNames[id]
+	if !ok {
+		return fmt.Sprintf("0x%04x", id)
+	}
+
+	return name
+}
+
+func keyExchangeOf(name string) KeyExchange {
+	switch {
+	case strings.HasPrefix(name, "TLS_ECDHE_"):
+		return KeyExchangeECDHE
+	case strings.HasPrefix(name, "TLS_ECDH_"):
+		return KeyExchangeECDH
+	case strings.HasPrefix(name, "TLS_DHE_"):
+		return KeyExchangeDHE
+	case strings.HasPrefix(name, "TLS_SRP_"):
+		return KeyExchangeSRP
+	case strings.HasPrefix(name, "TLS_RSA_"):
+		return KeyExchangeRSA
+	default:
+		return KeyExchangeUnknown
+	}
+}
@@ -0,0 +1,236 @@
+package handshake
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
+)
+
+// Compression methods.
+const (
+	CompressionNone    uint8 = 0
+	CompressionDeflate uint8 = 1
+)
+
+// ec_point_formats values.
+const (
+	PointFormatUncompressed uint8 = 0
+)
+
+// ErrTooLong is returned when a ClientHello field does not fit its
+// length prefix.
+var ErrTooLong = errors.New("handshake: field too long")
+
+// ClientHello describes a ClientHello to send. Optional extensions are
+// only included when their field is set, in the order of the fields
+// below, followed by Extensions.
+type ClientHello struct {
+	// RecordVersion is the version of the record layer carrying the
+	// hello. Zero uses Version.
+	RecordVersion uint16
+	// Version is the highest version offered in the hello itself.
+	Version uint16
+	// Random is the client random. Nil generates a fresh one.
+	Random    []byte
+	SessionID []byte
+
+	CipherSuites []uint16
+	// CompressionMethods defaults to null compression only.
+	CompressionMethods []uint8
+
+	// ServerName is sent in the server_name extension.
+	ServerName          string
+	SupportedGroups     []uint16
+	ECPointFormats      []uint8
+	SignatureAlgorithms []uint16
+	// RenegotiationInfo sends an empty renegotiation_info extension.
+	RenegotiationInfo bool
+	// SessionTicket sends the session_ticket extension carrying Ticket,
+	// which is empty to ask for a new ticket.
+	SessionTicket bool
+	Ticket        []byte
+	ALPN          []string
+	// HeartbeatMode sends the heartbeat extension with this mode.
+	// Zero omits it.
+	HeartbeatMode uint8
+
+	// Extensions are appended verbatim after the ones above.
+	Extensions []Extension
+}
+
+// Marshal returns the ClientHello handshake message body.
+func (c *ClientHello) Marshal() ([]byte, error) {
+	random := c.Random
+	if random == nil {
+		random = make([]byte, 32)
+
+		_, err := rand.Read(random)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(random) != 32 {
+		return nil, fmt.Errorf("handshake: client random is %d bytes, want 32", len(random))
+	}
+
+	compression := c.CompressionMethods
+	if compression == nil {
+		compression = []uint8{CompressionNone}
+	}
+
+	var b builder
+
+	b.uint16(c.Version)
+	b.bytes(random)
+	b.vector(1, c.SessionID)
+	b.vector(2, uint16s(c.CipherSuites))
+	b.vector(1, compression)
+
+	exts, err := c.extensions()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(exts) > 0 {
+		var eb builder
+		for _, ext := range exts {
+			eb.uint16(ext.Type)
+			eb.vector(2, ext.Data)
+		}
+
+		b.vector(2, eb.buf)
+
+		if eb.err != nil {
+			return nil, eb.err
+		}
+	}
+
+	return b.buf, b.err
+}
+
+// Record returns the ClientHello as a complete handshake record.
+func (c *ClientHello) Record() ([]byte, error) {
+	body, err := c.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	version := c.RecordVersion
+	if version == 0 {
+		version = c.Version
+	}
+
+	return tlsrecord.Marshal(tlsrecord.TypeHandshake, version,
+		tlsrecord.HandshakeMessage(tlsrecord.HandshakeClientHello, body)), nil
+}
+
+func (c *ClientHello) extensions() ([]Extension, error) {
+	var (
+		exts []Extension
+		b    builder
+	)
+
+	if c.ServerName != "" {
+		// server_name_list with a single host_name entry
+		entry := append([]byte{0}, b.sub(2, []byte(c.ServerName))...)
+		exts = append(exts, Extension{Type: ExtServerName, Data: b.sub(2, entry)})
+	}
+
+	if c.SupportedGroups != nil {
+		exts = append(exts, Extension{Type: ExtSupportedGroups, Data: b.sub(2, uint16s(c.SupportedGroups))})
+	}
+
+	if c.ECPointFormats != nil {
+		exts = append(exts, Extension{Type: ExtECPointFormats, Data: b.sub(1, c.ECPointFormats)})
+	}
+
+	if c.SignatureAlgorithms != nil {
+		exts = append(exts, Extension{Type: ExtSignatureAlgorithms, Data: b.sub(2, uint16s(c.SignatureAlgorithms))})
+	}
+
+	if c.RenegotiationInfo {
+		exts = append(exts, Extension{Type: ExtRenegotiationInfo, Data: []byte{0x00}})
+	}
+
+	if c.SessionTicket {
+		exts = append(exts, Extension{Type: ExtSessionTicket, Data: c.Ticket})
+	}
+
+	if c.ALPN != nil {
+		var protos builder
+		for _, proto := range c.ALPN {
+			protos.vector(1, []byte(proto))
+		}
+
+		exts = append(exts, Extension{Type: ExtALPN, Data: b.sub(2, protos.buf)})
+
+		if protos.err != nil {
+			return nil, protos.err
+		}
+	}
+
+	if c.HeartbeatMode != 0 {
+		exts = append(exts, Extension{Type: ExtHeartbeat, Data: []byte{c.HeartbeatMode}})
+	}
+
+	exts = append(exts, c.Extensions...)
+
+	return exts, b.err
+}
+
+func uint16s(values []uint16) []byte {
+	out := make([]byte, 0, 2*len(values))
+	for _, v := range values {
+		out = append(out, byte(v>>8), byte(v))
+	}
+
+	return out
+}
+
+// builder appends big-endian fields to buf. The first length overflow is
+// kept in err and later writes still happen, so callers check err once.
+type builder struct {
+	buf []byte
+	err error
+}
+
+func (b *builder) uint8(v uint8) {
+	b.buf = append(b.buf, v)
+}
+
+func (b *builder) uint16(v uint16) {
+	b.buf = append(b.buf, byte(v>>8), byte(v))
+}
+
+func (b *builder) bytes(data []byte) {
+	b.buf = append(b.buf, data...)
+}
+
+// vector writes data with a length prefix of lenBytes bytes.
+func (b *builder) vector(lenBytes int, data []byte) {
+	if len(data) >= 1<<(8*lenBytes) {
+		b.err = ErrTooLong
+	}
+
+	for i := lenBytes - 1; i >= 0; i-- {
+		b.buf = append(b.buf, byte(len(data)>>(8*i)))
+	}
+
+	b.buf = append(b.buf, data...)
+}
+
+// sub returns data with a length prefix, recording overflows in b.
+func (b *builder) sub(lenBytes int, data []byte) []byte {
+	var s builder
+
+	s.vector(lenBytes, data)
+
+	if s.err != nil {
+		b.err = s.err
+	}
+
+	return s.buf
+}
@@ -0,0 +1,288 @@
+package handshake
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
+)
+
+// Named groups offered for ECDHE.
+const (
+	GroupSecp256r1 uint16 = 23
+	GroupSecp384r1 uint16 = 24
+	GroupX25519    uint16 = 29
+)
+
+var (
+	// ErrUnsupportedSuite is returned by Finish for cipher suites it
+	// cannot complete a handshake with.
+	ErrUnsupportedSuite = errors.New("handshake: cipher suite not supported for a full handshake")
+	// ErrBadFinished is returned when the server's Finished message does
+	// not match the handshake.
+	ErrBadFinished = errors.New("handshake: server Finished does not verify")
+)
+
+var curves = map[uint16]ecdh.Curve{
+	GroupX25519:    ecdh.X25519(),
+	GroupSecp256r1: ecdh.P256(),
+	GroupSecp384r1: ecdh.P384(),
+}
+
+// fullHandshakeSignatureAlgorithms are offered in TLS 1.2 hellos:
+// PKCS #1 v1.5 and ECDSA with SHA-256, SHA-384 and SHA-1.
+var fullHandshakeSignatureAlgorithms = []uint16{0x0401, 0x0403, 0x0501, 0x0503, 0x0201, 0x0203}
+
+// NewFullHandshakeHello returns a ClientHello at version offering only
+// what Finish can complete: the suites of FullHandshakeSuites and the
+// X25519, P-256 and P-384 groups.
+func NewFullHandshakeHello(version uint16, serverName string) *ClientHello {
+	hello := &ClientHello{
+		Version:           version,
+		ServerName:        serverName,
+		CipherSuites:      FullHandshakeSuites(version),
+		SupportedGroups:   []uint16{GroupX25519, GroupSecp256r1, GroupSecp384r1},
+		ECPointFormats:    []uint8{PointFormatUncompressed},
+		RenegotiationInfo: true,
+	}
+
+	if version >= tlsrecord.VersionTLS12 {
+		hello.SignatureAlgorithms = fullHandshakeSignatureAlgorithms
+	}
+
+	return hello
+}
+
+// Session is a completed handshake. Records read from Reader and written
+// to Writer are protected with the negotiated keys.
+type Session struct {
+	Version     uint16
+	CipherSuite uint16
+	Reader      *tlsrecord.Reader
+	Writer      *tlsrecord.Writer
+}
+
+// Finish completes a full handshake as the client. hello is the body of
+// the ClientHello that was sent and flight the server's reply read from
+// r. Finish sends ClientKeyExchange, ChangeCipherSpec and Finished to w
+// and checks the server's ChangeCipherSpec and Finished.
+//
+// The session only serves to probe the server, so neither its
+// certificate nor the ServerKeyExchange signature is verified. RSA and
+// ECDHE key exchange with the suites of FullHandshakeSuites are
+// supported; a client certificate request is answered with an empty
+// chain.
+func Finish(w io.Writer, r *tlsrecord.Reader, hello []byte, flight *ServerFlight) (*Session, error) {
+	sh := flight.ServerHello
+	if sh == nil {
+		return nil, errors.New("handshake: no ServerHello to finish")
+	}
+
+	if len(hello) < 34 {
+		return nil, fmt.Errorf("ClientHello: %w", ErrTruncated)
+	}
+
+	clientVersion, clientRandom := uint16(hello[0])<<8|uint16(hello[1]), hello[2:34]
+
+	preMaster, cke, err := clientKeyExchange(flight, clientVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	master, err := MasterSecret(sh.Version, sh.CipherSuite, preMaster, clientRandom, sh.Random[:])
+	if err != nil {
+		return nil, err
+	}
+
+	ks, err := NewKeySchedule(sh.Version, sh.CipherSuite, master)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCipher, serverCipher, err := ks.Ciphers(clientRandom, sh.Random[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var transcript bytes.Buffer
+
+	transcript.Write(tlsrecord.HandshakeMessage(tlsrecord.HandshakeClientHello, hello))
+
+	for _, msg := range flight.Messages {
+		transcript.Write(msg.Marshal())
+	}
+
+	// the client's second flight goes out in one write; records written
+	// before the cipher is set cannot fail
+	var out bytes.Buffer
+
+	wr := tlsrecord.NewWriter(&out, sh.Version)
+
+	if flight.CertificateRequest {
+		_ = wr.WriteHandshake(tlsrecord.HandshakeCertificate, []byte{0, 0, 0})
+		transcript.Write(tlsrecord.HandshakeMessage(tlsrecord.HandshakeCertificate, []byte{0, 0, 0}))
+	}
+
+	_ = wr.WriteHandshake(tlsrecord.HandshakeClientKeyExchange, cke)
+	transcript.Write(tlsrecord.HandshakeMessage(tlsrecord.HandshakeClientKeyExchange, cke))
+	_ = wr.WriteRecord(tlsrecord.TypeChangeCipherSpec, []byte{1})
+	wr.SetCipher(clientCipher)
+
+	finished := ks.ClientFinished(transcript.Bytes())
+
+	err = wr.WriteHandshake(tlsrecord.HandshakeFinished, finished)
+	if err != nil {
+		return nil, err
+	}
+
+	transcript.Write(tlsrecord.HandshakeMessage(tlsrecord.HandshakeFinished, finished))
+
+	_, err = w.Write(out.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	err = readServerFinished(r, ks, serverCipher, &transcript)
+	if err != nil {
+		return nil, err
+	}
+
+	wr = tlsrecord.NewWriter(w, sh.Version)
+	wr.SetCipher(clientCipher)
+
+	return &Session{Version: sh.Version, CipherSuite: sh.CipherSuite, Reader: r, Writer: wr}, nil
+}
+
+// clientKeyExchange returns the premaster secret and the body of the
+// ClientKeyExchange message for the negotiated key exchange.
+func clientKeyExchange(flight *ServerFlight, clientVersion uint16) ([]byte, []byte, error) {
+	suite, _ := CipherSuiteByID(flight.ServerHello.CipherSuite)
+
+	switch suite.KeyExchange {
+	case KeyExchangeRSA:
+		return rsaKeyExchange(flight, clientVersion)
+	case KeyExchangeECDHE:
+		return ecdheKeyExchange(flight)
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedSuite, CipherSuiteName(suite.ID))
+	}
+}
+
+// rsaKeyExchange encrypts a fresh premaster secret to the server's
+// certificate key.
+func rsaKeyExchange(flight *ServerFlight, clientVersion uint16) ([]byte, []byte, error) {
+	if flight.Certificate == nil || len(flight.Certificate.Certificates) == 0 {
+		return nil, nil, errors.New("handshake: RSA key exchange without a server certificate")
+	}
+
+	certs, err := flight.Certificate.X509()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pub, ok := certs[0].PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("handshake: RSA key exchange with a %T certificate key", certs[0].PublicKey)
+	}
+
+	preMaster := make([]byte, masterSecretLen)
+	preMaster[0], preMaster[1] = byte(clientVersion>>8), byte(clientVersion)
+
+	_, err = rand.Read(preMaster[2:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, pub, preMaster) // #nosec G401 -- required by TLS RSA key exchange
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var b builder
+
+	b.vector(2, encrypted)
+
+	return preMaster, b.buf, b.err
+}
+
+// ecdheKeyExchange answers the server's ephemeral key with one of ours.
+func ecdheKeyExchange(flight *ServerFlight) ([]byte, []byte, error) {
+	ske := flight.ServerKeyExchange
+	if ske == nil || ske.PublicKey == nil {
+		return nil, nil, errors.New("handshake: ECDHE key exchange without a ServerKeyExchange")
+	}
+
+	curve, ok := curves[ske.NamedGroup]
+	if !ok {
+		return nil, nil, fmt.Errorf("handshake: unsupported named group %d", ske.NamedGroup)
+	}
+
+	peer, err := curve.NewPublicKey(ske.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	priv, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	preMaster, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var b builder
+
+	b.vector(1, priv.PublicKey().Bytes())
+
+	return preMaster, b.buf, b.err
+}
+
+// readServerFinished reads the server's ChangeCipherSpec and Finished,
+// skipping a NewSessionTicket sent before them.
+func readServerFinished(r *tlsrecord.Reader, ks *KeySchedule, serverCipher tlsrecord.Cipher,
+	transcript *bytes.Buffer,
+) error {
+	for {
+		msgType, body, err := r.ReadHandshake()
+
+		var unexpected *tlsrecord.UnexpectedRecordError
+		if errors.As(err, &unexpected) && unexpected.Record.Type == tlsrecord.TypeChangeCipherSpec {
+			r.SetCipher(serverCipher)
+
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if msgType != tlsrecord.HandshakeNewSessionTicket {
+			return fmt.Errorf("handshake: unexpected message %d before ChangeCipherSpec", msgType)
+		}
+
+		transcript.Write(tlsrecord.HandshakeMessage(msgType, body))
+	}
+
+	msgType, body, err := r.ReadHandshake()
+	if err != nil {
+		return err
+	}
+
+	if msgType != tlsrecord.HandshakeFinished {
+		return fmt.Errorf("handshake: unexpected message %d instead of Finished", msgType)
+	}
+
+	if !hmac.Equal(body, ks.ServerFinished(transcript.Bytes())) {
+		return ErrBadFinished
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+// Package handshake decodes the handshake messages a server sends in its
+// first flight (ServerHello, Certificate, ServerKeyExchange and
+// ServerHelloDone) into typed structs for the checks, builds
+// ClientHellos, and can complete a minimal TLS 1.0 to 1.2 handshake for
+// checks that probe the server after key exchange. Checks that test
+// clients play the server with ParseClientHello and NewServerHello.
+package handshake
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
+)
+
+// Extension types.
+const (
+	ExtServerName          uint16 = 0x0000
+	ExtSupportedGroups     uint16 = 0x000a
+	ExtECPointFormats      uint16 = 0x000b
+	ExtSignatureAlgorithms uint16 = 0x000d
+	ExtHeartbeat           uint16 = 0x000f
+	ExtALPN                uint16 = 0x0010
+	ExtSessionTicket       uint16 = 0x0023
+	ExtRenegotiationInfo   uint16 = 0xff01
+)
+
+// Heartbeat modes carried in the heartbeat extension (RFC 6520).
+const (
+	HeartbeatPeerAllowedToSend    uint8 = 1
+	HeartbeatPeerNotAllowedToSend uint8 = 2
+)
+
+// ErrTruncated is returned when a message ends before one of its fields.
+var ErrTruncated = errors.New("handshake: message truncated")
+
+// Extension is a TLS hello extension.
+type Extension struct {
+	Type uint16
+	Data []byte
+}
+
+// Message is a raw handshake message.
+type Message struct {
+	Type uint8
+	Body []byte
+}
+
+// Marshal returns the message with its handshake header, as it appears
+// in the handshake transcript.
+func (m Message) Marshal() []byte {
+	return tlsrecord.HandshakeMessage(m.Type, m.Body)
+}
+
+// ServerFlight holds the messages a server sends in reply to a
+// ClientHello, up to and including ServerHelloDone. Messages the server
+// did not send are nil.
+type ServerFlight struct {
+	ServerHello        *ServerHello
+	Certificate        *Certificate
+	ServerKeyExchange  *ServerKeyExchange
+	CertificateRequest bool
+	// Messages lists every message in the order received.
+	Messages []Message
+}
+
+// ReadServerFlight reads handshake messages from r until ServerHelloDone
+// and decodes them. The ServerKeyExchange is decoded according to the
+// key exchange of the negotiated cipher suite.
+func ReadServerFlight(r *tlsrecord.Reader) (*ServerFlight, error) {
+	flight := &ServerFlight{}
+
+	for {
+		msgType, body, err := r.ReadHandshake()
+		if err != nil {
+			return flight, err
+		}
+
+		flight.Messages = append(flight.Messages, Message{Type: msgType, Body: body})
+
+		switch msgType {
+		case tlsrecord.HandshakeServerHello:
+			flight.ServerHello, err = ParseServerHello(body)
+		case tlsrecord.HandshakeCertificate:
+			flight.Certificate, err = ParseCertificate(body)
+		case tlsrecord.HandshakeServerKeyExchange:
+			flight.ServerKeyExchange, err = flight.parseServerKeyExchange(body)
+		case tlsrecord.HandshakeCertificateRequest:
+			flight.CertificateRequest = true
+		case tlsrecord.HandshakeServerHelloDone:
+			return flight, ParseServerHelloDone(body)
+		}
+
+		if err != nil {
+			return flight, err
+		}
+	}
+}
+
+func (f *ServerFlight) parseServerKeyExchange(body []byte) (*ServerKeyExchange, error) {
+	if f.ServerHello == nil {
+		return nil, errors.New("handshake: ServerKeyExchange before ServerHello")
+	}
+
+	suite, ok := CipherSuiteByID(f.ServerHello.CipherSuite)
+	if !ok {
+		// unknown suite: keep the message undecoded
+		return &ServerKeyExchange{Raw: body}, nil
+	}
+
+	return ParseServerKeyExchange(body, suite.KeyExchange, f.ServerHello.Version)
+}
+
+// ParseServerHelloDone checks that a ServerHelloDone body is empty.
+func ParseServerHelloDone(body []byte) error {
+	if len(body) != 0 {
+		return fmt.Errorf("handshake: ServerHelloDone with %d byte body", len(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,576 @@
+package handshake
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
+)
+
+// serverHelloBody builds a ServerHello for suite with the given encoded
+// extensions. A nil exts omits the extensions block.
+func serverHelloBody(version, suite uint16, exts []byte) []byte {
+	body := []byte{byte(version >> 8), byte(version)}
+	body = append(body, bytes.Repeat([]byte{0x11}, 32)...)
+	body = append(body, 0x04, 0xde, 0xad, 0xbe, 0xef) // session id
+	body = append(body, byte(suite>>8), byte(suite))
+	body = append(body, 0x00)
+
+	if exts != nil {
+		body = append(body, byte(len(exts)>>8), byte(len(exts)))
+		body = append(body, exts...)
+	}
+
+	return body
+}
+
+func vector(lenBytes int, data []byte) []byte {
+	var out []byte
+	for i := lenBytes - 1; i >= 0; i-- {
+		out = append(out, byte(len(data)>>(8*i)))
+	}
+
+	return append(out, data...)
+}
+
+func selfSigned(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "handshake.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+
+	return der
+}
+
+func TestParseServerHello(t *testing.T) {
+	exts := []byte{
+		0xff, 0x01, 0x00, 0x01, 0x00, // renegotiation_info
+		0x00, 0x0f, 0x00, 0x01, 0x01, // heartbeat, peer_allowed_to_send
+	}
+
+	hello, err := ParseServerHello(serverHelloBody(tlsrecord.VersionTLS12, 0xc02f, exts))
+	if err != nil {
+		t.Fatalf("ParseServerHello failed: %v", err)
+	}
+
+	if hello.Version != tlsrecord.VersionTLS12 || hello.CipherSuite != 0xc02f || hello.CompressionMethod != 0 {
+		t.Errorf("wrong fields: %+v", hello)
+	}
+
+	if !bytes.Equal(hello.SessionID, []byte{0xde, 0xad, 0xbe, 0xef}) || hello.Random[31] != 0x11 {
+		t.Errorf("wrong session id or random: %x %x", hello.SessionID, hello.Random)
+	}
+
+	if len(hello.Extensions) != 2 {
+		t.Fatalf("expected 2 extensions, got %d", len(hello.Extensions))
+	}
+
+	mode, ok := hello.HeartbeatMode()
+	if !ok || mode != HeartbeatPeerAllowedToSend {
+		t.Errorf("heartbeat mode not found: %d/%v", mode, ok)
+	}
+
+	// a heartbeat byte sequence inside another extension must not count
+	decoy := []byte{0xff, 0x01, 0x00, 0x05, 0x00, 0x0f, 0x00, 0x01, 0x01}
+
+	hello, err = ParseServerHello(serverHelloBody(tlsrecord.VersionTLS12, 0xc02f, decoy))
+	if err != nil {
+		t.Fatalf("ParseServerHello failed: %v", err)
+	}
+
+	_, ok = hello.HeartbeatMode()
+	if ok {
+		t.Errorf("heartbeat detected inside renegotiation_info")
+	}
+
+	hello, err = ParseServerHello(serverHelloBody(tlsrecord.VersionTLS10, 0x002f, nil))
+	if err != nil || hello.Extensions != nil {
+		t.Errorf("ServerHello without extensions: %v, %+v", err, hello)
+	}
+}
+
+func TestParseServerHelloTruncated(t *testing.T) {
+	body := serverHelloBody(tlsrecord.VersionTLS12, 0xc02f, []byte{0x00, 0x0f, 0x00, 0x01, 0x01})
+
+	for _, n := range []int{0, 10, 34, 40, len(body) - 1} {
+		_, err := ParseServerHello(body[:n])
+		if !errors.Is(err, ErrTruncated) {
+			t.Errorf("%d bytes: expected ErrTruncated, got: %v", n, err)
+		}
+	}
+}
+
+func TestParseCertificate(t *testing.T) {
+	der := selfSigned(t)
+
+	body := vector(3, append(vector(3, der), vector(3, der)...))
+
+	cert, err := ParseCertificate(body)
+	if err != nil {
+		t.Fatalf("ParseCertificate failed: %v", err)
+	}
+
+	certs, err := cert.X509()
+	if err != nil {
+		t.Fatalf("X509 failed: %v", err)
+	}
+
+	if len(certs) != 2 || certs[0].Subject.CommonName != "handshake.test" {
+		t.Errorf("wrong certificates: %d", len(certs))
+	}
+
+	_, err = ParseCertificate(body[:len(body)-1])
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("expected ErrTruncated, got: %v", err)
+	}
+}
+
+func TestParseServerKeyExchange(t *testing.T) {
+	point := bytes.Repeat([]byte{0x04}, 65)
+	params := append([]byte{0x03, 0x00, 0x17}, vector(1, point)...)
+	sig := vector(2, []byte("signature"))
+
+	ske, err := ParseServerKeyExchange(append(append(params, 0x04, 0x03), sig...), KeyExchangeECDHE,
+		tlsrecord.VersionTLS12)
+	if err != nil {
+		t.Fatalf("ParseServerKeyExchange failed: %v", err)
+	}
+
+	if ske.NamedGroup != 0x0017 || !bytes.Equal(ske.PublicKey, point) || !bytes.Equal(ske.Params, params) {
+		t.Errorf("wrong ECDHE params: %+v", ske)
+	}
+
+	if ske.SignatureAlgorithm != 0x0403 || string(ske.Signature) != "signature" {
+		t.Errorf("wrong signature: %x %q", ske.SignatureAlgorithm, ske.Signature)
+	}
+
+	// before TLS 1.2 there is no signature algorithm field
+	dh := append(append(vector(2, []byte{0xff, 0xfb}), vector(2, []byte{2})...), vector(2, []byte{0x12, 0x34})...)
+
+	ske, err = ParseServerKeyExchange(append(dh, sig...), KeyExchangeDHE, tlsrecord.VersionTLS10)
+	if err != nil {
+		t.Fatalf("ParseServerKeyExchange failed: %v", err)
+	}
+
+	if !bytes.Equal(ske.G, []byte{2}) || !bytes.Equal(ske.Ys, []byte{0x12, 0x34}) || ske.SignatureAlgorithm != 0 {
+		t.Errorf("wrong DHE params: %+v", ske)
+	}
+
+	_, err = ParseServerKeyExchange(params, KeyExchangeECDHE, tlsrecord.VersionTLS12)
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("expected ErrTruncated for missing signature, got: %v", err)
+	}
+}
+
+func TestReadServerFlight(t *testing.T) {
+	der := selfSigned(t)
+	ske := append([]byte{0x03, 0x00, 0x17}, vector(1, []byte{0x04, 0x01, 0x02})...)
+	ske = append(ske, 0x04, 0x03)
+	ske = append(ske, vector(2, []byte{0x30})...)
+
+	var payload []byte
+	payload = append(payload, tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHello,
+		serverHelloBody(tlsrecord.VersionTLS12, 0xc02b, []byte{}))...)
+	payload = append(payload, tlsrecord.HandshakeMessage(tlsrecord.HandshakeCertificate, vector(3, vector(3, der)))...)
+	payload = append(payload, tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerKeyExchange, ske)...)
+	payload = append(payload, tlsrecord.HandshakeMessage(tlsrecord.HandshakeCertificateRequest, []byte{0x00})...)
+	payload = append(payload, tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHelloDone, nil)...)
+
+	r := tlsrecord.NewReader(bytes.NewReader(tlsrecord.Marshal(tlsrecord.TypeHandshake, tlsrecord.VersionTLS12, payload)))
+
+	flight, err := ReadServerFlight(r)
+	if err != nil {
+		t.Fatalf("ReadServerFlight failed: %v", err)
+	}
+
+	if flight.ServerHello == nil || flight.Certificate == nil || flight.ServerKeyExchange == nil {
+		t.Fatalf("missing messages: %+v", flight)
+	}
+
+	if flight.ServerKeyExchange.NamedGroup != 0x0017 || !flight.CertificateRequest {
+		t.Errorf("wrong flight: %+v", flight)
+	}
+
+	if len(flight.Messages) != 5 ||
+		!bytes.Equal(flight.Messages[0].Marshal(), payload[:len(flight.Messages[0].Marshal())]) {
+		t.Errorf("messages not recorded in order")
+	}
+
+	// an alert in place of the flight is returned as is
+	alert := tlsrecord.Marshal(tlsrecord.TypeAlert, tlsrecord.VersionTLS12, []byte{2, 40})
+
+	_, err = ReadServerFlight(tlsrecord.NewReader(bytes.NewReader(alert)))
+
+	var alertErr *tlsrecord.AlertError
+	if !errors.As(err, &alertErr) {
+		t.Errorf("expected AlertError, got: %v", err)
+	}
+}
+
+func TestCipherSuiteByID(t *testing.T) {
+	tests := []struct {
+		id uint16
+		kx KeyExchange
+	}{
+		{0xc02f, KeyExchangeECDHE},
+		{0xc00f, KeyExchangeECDH},
+		{0x0039, KeyExchangeDHE},
+		{0x002f, KeyExchangeRSA},
+		{0xc021, KeyExchangeSRP},
+	}
+
+	for _, tt := range tests {
+		suite, ok := CipherSuiteByID(tt.id)
+		if !ok || suite.KeyExchange != tt.kx {
+			t.Errorf("%s: got key exchange %d, want %d", CipherSuiteName(tt.id), suite.KeyExchange, tt.kx)
+		}
+	}
+
+	if CipherSuiteName(0x1301) != "0x1301" {
+		t.Errorf("unexpected name for unknown suite: %s", CipherSuiteName(0x1301))
+	}
+}
+
+// TestClientHelloParsedByGo sends a ClientHello to a crypto/tls server
+// and checks that every option arrives as configured.
+func TestClientHelloParsedByGo(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	seen := make(chan *tls.ClientHelloInfo, 1)
+
+	go func() {
+		defer server.Close()
+
+		cfg := &tls.Config{
+			GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+				seen <- info
+
+				return nil, errors.New("stop")
+			},
+		}
+
+		tls.Server(server, cfg).Handshake()
+	}()
+
+	hello := &ClientHello{
+		RecordVersion:       tlsrecord.VersionTLS10,
+		Version:             tlsrecord.VersionTLS12,
+		CipherSuites:        []uint16{0xc02f, 0x002f},
+		ServerName:          "vhost.example.com",
+		SupportedGroups:     []uint16{0x001d, 0x0017},
+		ECPointFormats:      []uint8{PointFormatUncompressed},
+		SignatureAlgorithms: []uint16{0x0804, 0x0401},
+		RenegotiationInfo:   true,
+		SessionTicket:       true,
+		ALPN:                []string{"h2", "http/1.1"},
+		HeartbeatMode:       HeartbeatPeerAllowedToSend,
+	}
+
+	record, err := hello.Record()
+	if err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	go client.Write(record)
+
+	info := <-seen
+
+	if info.ServerName != "vhost.example.com" {
+		t.Errorf("wrong server name: %q", info.ServerName)
+	}
+
+	if !slices.Equal(info.CipherSuites, hello.CipherSuites) || !slices.Equal(info.SupportedProtos, hello.ALPN) {
+		t.Errorf("wrong suites or ALPN: %x %v", info.CipherSuites, info.SupportedProtos)
+	}
+
+	if len(info.SupportedCurves) != 2 || info.SupportedCurves[0] != tls.X25519 || len(info.SignatureSchemes) != 2 {
+		t.Errorf("wrong groups or signature algorithms: %v %v", info.SupportedCurves, info.SignatureSchemes)
+	}
+
+	if !bytes.Equal(info.SupportedPoints, []uint8{0}) || info.SupportedVersions[0] != tls.VersionTLS12 {
+		t.Errorf("wrong point formats or versions: %v %v", info.SupportedPoints, info.SupportedVersions)
+	}
+
+	if !slices.Contains(info.Extensions, ExtHeartbeat) || !slices.Contains(info.Extensions, ExtSessionTicket) {
+		t.Errorf("missing extensions: %x", info.Extensions)
+	}
+}
+
+func TestClientHelloMarshal(t *testing.T) {
+	random := bytes.Repeat([]byte{0x22}, 32)
+
+	hello := &ClientHello{
+		Version:      tlsrecord.VersionSSL30,
+		Random:       random,
+		CipherSuites: []uint16{0x000a},
+		Extensions:   []Extension{{Type: 0x1234, Data: []byte{0xab}}},
+	}
+
+	body, err := hello.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	want := []byte{0x03, 0x00}
+	want = append(want, random...)
+	want = append(want, 0x00, 0x00, 0x02, 0x00, 0x0a, 0x01, 0x00)
+	want = append(want, 0x00, 0x05, 0x12, 0x34, 0x00, 0x01, 0xab)
+
+	if !bytes.Equal(body, want) {
+		t.Errorf("wrong encoding:\ngot:  %x\nwant: %x", body, want)
+	}
+
+	// without extensions the block is left out entirely
+	hello.Extensions = nil
+
+	body, err = hello.Marshal()
+	if err != nil || len(body) != len(want)-7 {
+		t.Errorf("expected no extensions block, got %x (%v)", body, err)
+	}
+
+	hello.ServerName = strings.Repeat("a", 1<<16)
+
+	_, err = hello.Marshal()
+	if !errors.Is(err, ErrTooLong) {
+		t.Errorf("expected ErrTooLong, got: %v", err)
+	}
+}
+
+// keyPair returns a self-signed certificate for key.
+func keyPair(t *testing.T, key crypto.Signer) tls.Certificate {
+	t.Helper()
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "handshake.test"},
+		DNSNames:     []string{"handshake.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// TestFinishWithGo completes a handshake with a crypto/tls server for
+// every supported version and suite, then exchanges application data
+// in both directions over the session.
+func TestFinishWithGo(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	certs := []tls.Certificate{keyPair(t, rsaKey), keyPair(t, ecKey)}
+
+	for _, version := range []uint16{tlsrecord.VersionTLS10, tlsrecord.VersionTLS11, tlsrecord.VersionTLS12} {
+		for _, suite := range FullHandshakeSuites(version) {
+			t.Run(fmt.Sprintf("%04x/%s", version, CipherSuiteName(suite)), func(t *testing.T) {
+				testFinishWithGo(t, certs, version, suite)
+			})
+		}
+	}
+}
+
+func testFinishWithGo(t *testing.T, certs []tls.Certificate, version, suite uint16) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	go func() {
+		defer server.Close()
+
+		conn := tls.Server(server, &tls.Config{
+			Certificates: certs,
+			CipherSuites: []uint16{suite},
+			MinVersion:   version,
+			MaxVersion:   version,
+			ClientAuth:   tls.RequestClientCert,
+		})
+
+		buf := make([]byte, 64)
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		conn.Write(bytes.ToUpper(buf[:n]))
+	}()
+
+	hello := NewFullHandshakeHello(version, "handshake.test")
+	hello.SessionTicket = true
+
+	body, err := hello.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	go client.Write(tlsrecord.Marshal(tlsrecord.TypeHandshake, version,
+		tlsrecord.HandshakeMessage(tlsrecord.HandshakeClientHello, body)))
+
+	records := tlsrecord.NewReader(client)
+
+	flight, err := ReadServerFlight(records)
+	if err != nil {
+		t.Fatalf("ReadServerFlight failed: %v", err)
+	}
+
+	session, err := Finish(client, records, body, flight)
+	if err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+
+	if session.Version != version || session.CipherSuite != suite {
+		t.Errorf("wrong session: %04x/%04x", session.Version, session.CipherSuite)
+	}
+
+	err = session.Writer.WriteRecord(tlsrecord.TypeApplicationData, []byte("ping"))
+	if err != nil {
+		t.Fatalf("WriteRecord failed: %v", err)
+	}
+
+	// TLS 1.0 CBC replies come split 1/n-1 against BEAST
+	var reply []byte
+
+	for len(reply) < 4 {
+		rec, err := session.Reader.ReadRecord()
+		if err != nil {
+			t.Fatalf("ReadRecord failed: %v", err)
+		}
+
+		if rec.Type != tlsrecord.TypeApplicationData {
+			t.Fatalf("wrong record type %d", rec.Type)
+		}
+
+		reply = append(reply, rec.Payload...)
+	}
+
+	if string(reply) != "PING" {
+		t.Errorf("wrong reply: %q", reply)
+	}
+}
+
+func TestServerHelloForClientHello(t *testing.T) {
+	sent := &ClientHello{
+		Version:       tlsrecord.VersionTLS11,
+		SessionID:     []byte{1, 2, 3},
+		CipherSuites:  []uint16{0x5600, 0x0a0a, 0x1301, 0xc014, 0x002f},
+		ServerName:    "client.test",
+		HeartbeatMode: HeartbeatPeerAllowedToSend,
+	}
+
+	body, err := sent.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	hello, err := ParseClientHello(body)
+	if err != nil {
+		t.Fatalf("ParseClientHello failed: %v", err)
+	}
+
+	if hello.Version != tlsrecord.VersionTLS11 || len(hello.CipherSuites) != 5 || hello.ServerName() != "client.test" {
+		t.Errorf("wrong fields: %+v", hello)
+	}
+
+	if mode, ok := hello.HeartbeatMode(); !ok || mode != HeartbeatPeerAllowedToSend {
+		t.Errorf("heartbeat mode not found: %d/%v", mode, ok)
+	}
+
+	sh, err := NewServerHello(hello, tlsrecord.VersionTLS12)
+	if err != nil {
+		t.Fatalf("NewServerHello failed: %v", err)
+	}
+
+	sh.Extensions = []Extension{{Type: ExtHeartbeat, Data: []byte{HeartbeatPeerAllowedToSend}}}
+
+	out, err := sh.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	parsed, err := ParseServerHello(out)
+	if err != nil {
+		t.Fatalf("ParseServerHello failed: %v", err)
+	}
+
+	if parsed.Version != tlsrecord.VersionTLS11 || parsed.CipherSuite != 0xc014 || parsed.Random != sh.Random {
+		t.Errorf("wrong ServerHello: %+v", parsed)
+	}
+
+	if _, ok := parsed.HeartbeatMode(); !ok {
+		t.Error("heartbeat extension lost")
+	}
+
+	_, err = NewServerHello(&ClientHelloInfo{Version: tlsrecord.VersionTLS12, CipherSuites: []uint16{0x00ff}},
+		tlsrecord.VersionTLS12)
+	if !errors.Is(err, ErrNoCommonSuite) {
+		t.Errorf("expected ErrNoCommonSuite, got %v", err)
+	}
+}
+
+func TestParseClientHelloFromGo(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		defer client.Close()
+
+		tls.Client(client, &tls.Config{ServerName: "go.test", MaxVersion: tls.VersionTLS12}).Handshake()
+	}()
+
+	msgType, body, err := tlsrecord.NewReader(server).ReadHandshake()
+	if err != nil || msgType != tlsrecord.HandshakeClientHello {
+		t.Fatalf("ReadHandshake failed: %d/%v", msgType, err)
+	}
+
+	hello, err := ParseClientHello(body)
+	if err != nil {
+		t.Fatalf("ParseClientHello failed: %v", err)
+	}
+
+	if hello.Version != tlsrecord.VersionTLS12 || hello.ServerName() != "go.test" || len(hello.CipherSuites) == 0 {
+		t.Errorf("wrong fields: %+v", hello)
+	}
+}
@@ -0,0 +1,241 @@
+package handshake
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/md5"  // #nosec G501 -- required by the TLS 1.0 and 1.1 PRF
+	"crypto/sha1" // #nosec G505 -- required by the TLS 1.0 and 1.1 PRF and CBC MACs
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
+)
+
+// Sizes from RFC 5246.
+const (
+	masterSecretLen = 48
+	verifyDataLen   = 12
+	gcmFixedIVLen   = 4
+)
+
+// PRF labels.
+const (
+	labelMasterSecret   = "master secret"
+	labelKeyExpansion   = "key expansion"
+	labelClientFinished = "client finished"
+	labelServerFinished = "server finished"
+)
+
+// bulkCipher describes the record protection of a suite that Finish can
+// negotiate: AES in CBC mode with HMAC-SHA1, or AES-GCM.
+type bulkCipher struct {
+	keyLen int
+	aead   bool
+	// sha384 selects SHA-384 for the TLS 1.2 PRF.
+	sha384 bool
+}
+
+var bulkCiphers = map[uint16]bulkCipher{
+	0xc02f: {keyLen: 16, aead: true},
+	0xc030: {keyLen: 32, aead: true, sha384: true},
+	0xc02b: {keyLen: 16, aead: true},
+	0xc02c: {keyLen: 32, aead: true, sha384: true},
+	0x009c: {keyLen: 16, aead: true},
+	0x009d: {keyLen: 32, aead: true, sha384: true},
+	0xc013: {keyLen: 16},
+	0xc014: {keyLen: 32},
+	0xc009: {keyLen: 16},
+	0xc00a: {keyLen: 32},
+	0x002f: {keyLen: 16},
+	0x0035: {keyLen: 32},
+}
+
+// fullHandshakeSuites lists the suites Finish supports, in order of
+// preference.
+var fullHandshakeSuites = []uint16{
+	0xc02f, 0xc030, 0xc02b, 0xc02c, 0xc013, 0xc014, 0xc009, 0xc00a,
+	0x009c, 0x009d, 0x002f, 0x0035,
+}
+
+// FullHandshakeSuites returns the cipher suites Finish can complete a
+// handshake with at version. GCM suites need TLS 1.2.
+func FullHandshakeSuites(version uint16) []uint16 {
+	var suites []uint16
+
+	for _, id := range fullHandshakeSuites {
+		if bulkCiphers[id].aead && version < tlsrecord.VersionTLS12 {
+			continue
+		}
+
+		suites = append(suites, id)
+	}
+
+	return suites
+}
+
+// KeySchedule derives the record keys and Finished messages of a TLS
+// 1.0 to 1.2 session from its master secret. Only the suites returned by
+// FullHandshakeSuites are supported.
+type KeySchedule struct {
+	version uint16
+	bulk    bulkCipher
+	master  []byte
+}
+
+// NewKeySchedule returns the key schedule of a session using suite at
+// version with the given master secret.
+func NewKeySchedule(version, suite uint16, master []byte) (*KeySchedule, error) {
+	bulk, ok := bulkCiphers[suite]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedSuite, CipherSuiteName(suite))
+	}
+
+	if version < tlsrecord.VersionTLS10 || version > tlsrecord.VersionTLS12 {
+		return nil, fmt.Errorf("handshake: no key schedule for version 0x%04x", version)
+	}
+
+	return &KeySchedule{version: version, bulk: bulk, master: master}, nil
+}
+
+// MasterSecret derives the master secret from the premaster secret and
+// the hello randoms.
+func MasterSecret(version, suite uint16, preMaster, clientRandom, serverRandom []byte) ([]byte, error) {
+	ks, err := NewKeySchedule(version, suite, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return ks.prf(preMaster, labelMasterSecret, concat(clientRandom, serverRandom), masterSecretLen), nil
+}
+
+// prf is the TLS PRF: P_MD5 xor P_SHA1 before TLS 1.2, then P_SHA256 or
+// P_SHA384 as the suite asks.
+func (ks *KeySchedule) prf(secret []byte, label string, seed []byte, n int) []byte {
+	labelSeed := concat([]byte(label), seed)
+
+	if ks.version == tlsrecord.VersionTLS12 {
+		return pHash(ks.hash(), secret, labelSeed, n)
+	}
+
+	half := secret[:(len(secret)+1)/2]
+	out := pHash(md5.New, half, labelSeed, n)
+
+	for i, b := range pHash(sha1.New, secret[len(secret)/2:], labelSeed, n) {
+		out[i] ^= b
+	}
+
+	return out
+}
+
+// hash returns the TLS 1.2 PRF and transcript hash.
+func (ks *KeySchedule) hash() func() hash.Hash {
+	if ks.bulk.sha384 {
+		return sha512.New384
+	}
+
+	return sha256.New
+}
+
+// pHash is P_hash from RFC 5246 section 5.
+func pHash(newHash func() hash.Hash, secret, seed []byte, n int) []byte {
+	mac := hmac.New(newHash, secret)
+	mac.Write(seed)
+	a := mac.Sum(nil)
+
+	out := make([]byte, 0, n+mac.Size())
+
+	for len(out) < n {
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(seed)
+		out = mac.Sum(out)
+
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+	}
+
+	return out[:n]
+}
+
+// Ciphers derives the key block and returns the record protection of
+// the client and server directions.
+func (ks *KeySchedule) Ciphers(clientRandom, serverRandom []byte) (client, server tlsrecord.Cipher, err error) {
+	macLen, ivLen := sha1.Size, aes.BlockSize
+	if ks.bulk.aead {
+		macLen, ivLen = 0, gcmFixedIVLen
+	}
+
+	block := ks.prf(ks.master, labelKeyExpansion, concat(serverRandom, clientRandom),
+		2*(macLen+ks.bulk.keyLen+ivLen))
+
+	next := func(n int) []byte {
+		b := block[:n]
+		block = block[n:]
+
+		return b
+	}
+
+	clientMAC, serverMAC := next(macLen), next(macLen)
+	clientKey, serverKey := next(ks.bulk.keyLen), next(ks.bulk.keyLen)
+	clientIV, serverIV := next(ivLen), next(ivLen)
+
+	client, err = ks.cipher(clientKey, clientMAC, clientIV)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	server, err = ks.cipher(serverKey, serverMAC, serverIV)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client, server, nil
+}
+
+func (ks *KeySchedule) cipher(key, macKey, iv []byte) (tlsrecord.Cipher, error) {
+	if ks.bulk.aead {
+		return tlsrecord.NewGCM(key, iv)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return tlsrecord.NewCBC(block, sha1.New, macKey, iv, ks.version >= tlsrecord.VersionTLS11), nil
+}
+
+// ClientFinished returns the verify data of the client's Finished
+// message for the handshake messages in transcript.
+func (ks *KeySchedule) ClientFinished(transcript []byte) []byte {
+	return ks.verifyData(labelClientFinished, transcript)
+}
+
+// ServerFinished returns the verify data of the server's Finished
+// message for the handshake messages in transcript.
+func (ks *KeySchedule) ServerFinished(transcript []byte) []byte {
+	return ks.verifyData(labelServerFinished, transcript)
+}
+
+func (ks *KeySchedule) verifyData(label string, transcript []byte) []byte {
+	var sum []byte
+
+	if ks.version == tlsrecord.VersionTLS12 {
+		h := ks.hash()()
+		h.Write(transcript)
+		sum = h.Sum(nil)
+	} else {
+		md5Sum := md5.Sum(transcript)   // #nosec G401 -- required by TLS 1.0 and 1.1
+		sha1Sum := sha1.Sum(transcript) // #nosec G401 -- required by TLS 1.0 and 1.1
+		sum = concat(md5Sum[:], sha1Sum[:])
+	}
+
+	return ks.prf(ks.master, label, sum, verifyDataLen)
+}
+
+func concat(a, b []byte) []byte {
+	return append(append(make([]byte, 0, len(a)+len(b)), a...), b...)
+}
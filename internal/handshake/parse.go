@@ -0,0 +1,203 @@
+package handshake
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
+)
+
+// ServerHello is a decoded ServerHello message.
+type ServerHello struct {
+	Version           uint16
+	Random            [32]byte
+	SessionID         []byte
+	CipherSuite       uint16
+	CompressionMethod uint8
+	Extensions        []Extension
+}
+
+// ParseServerHello decodes the body of a ServerHello message.
+func ParseServerHello(body []byte) (*ServerHello, error) {
+	p := parser(body)
+	hello := &ServerHello{}
+
+	var random []byte
+
+	ok := p.uint16(&hello.Version) &&
+		p.bytes(&random, 32) &&
+		p.vector8(&hello.SessionID) &&
+		p.uint16(&hello.CipherSuite) &&
+		p.uint8(&hello.CompressionMethod)
+	if !ok {
+		return nil, fmt.Errorf("ServerHello: %w", ErrTruncated)
+	}
+
+	copy(hello.Random[:], random)
+
+	// the extensions block is optional
+	if p.empty() {
+		return hello, nil
+	}
+
+	var exts []byte
+	if !p.vector16(&exts) || !p.empty() {
+		return nil, fmt.Errorf("ServerHello extensions: %w", ErrTruncated)
+	}
+
+	var err error
+
+	hello.Extensions, err = parseExtensions(exts)
+	if err != nil {
+		return nil, err
+	}
+
+	return hello, nil
+}
+
+// Extension returns the data of the extension of type typ.
+func (s *ServerHello) Extension(typ uint16) ([]byte, bool) {
+	for _, ext := range s.Extensions {
+		if ext.Type == typ {
+			return ext.Data, true
+		}
+	}
+
+	return nil, false
+}
+
+// HeartbeatMode returns the mode of the heartbeat extension, if the
+// server negotiated it.
+func (s *ServerHello) HeartbeatMode() (uint8, bool) {
+	data, ok := s.Extension(ExtHeartbeat)
+	if !ok || len(data) != 1 {
+		return 0, false
+	}
+
+	return data[0], true
+}
+
+func parseExtensions(data []byte) ([]Extension, error) {
+	p := parser(data)
+
+	var exts []Extension
+
+	for !p.empty() {
+		var ext Extension
+		if !p.uint16(&ext.Type) || !p.vector16(&ext.Data) {
+			return nil, fmt.Errorf("extension: %w", ErrTruncated)
+		}
+
+		exts = append(exts, ext)
+	}
+
+	return exts, nil
+}
+
+// Certificate is a decoded Certificate message.
+type Certificate struct {
+	// Certificates holds the DER certificates, leaf first.
+	Certificates [][]byte
+}
+
+// ParseCertificate decodes the body of a Certificate message.
+func ParseCertificate(body []byte) (*Certificate, error) {
+	p := parser(body)
+
+	var list []byte
+	if !p.vector24(&list) || !p.empty() {
+		return nil, fmt.Errorf("Certificate: %w", ErrTruncated)
+	}
+
+	cert := &Certificate{}
+	lp := parser(list)
+
+	for !lp.empty() {
+		var der []byte
+		if !lp.vector24(&der) {
+			return nil, fmt.Errorf("Certificate entry: %w", ErrTruncated)
+		}
+
+		cert.Certificates = append(cert.Certificates, der)
+	}
+
+	return cert, nil
+}
+
+// X509 parses the certificates.
+func (c *Certificate) X509() ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0, len(c.Certificates))
+
+	for _, der := range c.Certificates {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
+
+// ECDHE curve types.
+const curveTypeNamedCurve uint8 = 3
+
+// ServerKeyExchange is a decoded ServerKeyExchange message. Only the
+// fields matching the key exchange are set.
+type ServerKeyExchange struct {
+	// Raw is the undecoded message body.
+	Raw []byte
+	// Params holds the encoded key exchange parameters, the part of the
+	// message covered by the signature.
+	Params []byte
+
+	// NamedGroup and PublicKey are set for ECDHE.
+	NamedGroup uint16
+	PublicKey  []byte
+
+	// P, G and Ys are set for DHE.
+	P, G, Ys []byte
+
+	// SignatureAlgorithm is only sent from TLS 1.2 onwards.
+	SignatureAlgorithm uint16
+	Signature          []byte
+}
+
+// ParseServerKeyExchange decodes the body of a ServerKeyExchange message
+// for the given key exchange and protocol version.
+func ParseServerKeyExchange(body []byte, kx KeyExchange, version uint16) (*ServerKeyExchange, error) {
+	ske := &ServerKeyExchange{Raw: body}
+	p := parser(body)
+
+	switch kx {
+	case KeyExchangeECDHE:
+		var curveType uint8
+		if !p.uint8(&curveType) || !p.uint16(&ske.NamedGroup) || !p.vector8(&ske.PublicKey) {
+			return nil, fmt.Errorf("ServerKeyExchange: %w", ErrTruncated)
+		}
+
+		if curveType != curveTypeNamedCurve {
+			return nil, fmt.Errorf("ServerKeyExchange: unsupported curve type %d", curveType)
+		}
+	case KeyExchangeDHE:
+		if !p.vector16(&ske.P) || !p.vector16(&ske.G) || !p.vector16(&ske.Ys) {
+			return nil, fmt.Errorf("ServerKeyExchange: %w", ErrTruncated)
+		}
+	default:
+		// nothing to decode beyond the raw body
+		return ske, nil
+	}
+
+	ske.Params = body[:len(body)-len(p)]
+
+	if version >= tlsrecord.VersionTLS12 && !p.uint16(&ske.SignatureAlgorithm) {
+		return nil, fmt.Errorf("ServerKeyExchange signature: %w", ErrTruncated)
+	}
+
+	if !p.vector16(&ske.Signature) || !p.empty() {
+		return nil, fmt.Errorf("ServerKeyExchange signature: %w", ErrTruncated)
+	}
+
+	return ske, nil
+}
@@ -0,0 +1,92 @@
+package handshake
+
+// parser consumes big-endian fields from a byte slice. Each method
+// returns false, leaving the parser unchanged, if the data is too short.
+type parser []byte
+
+func (p *parser) empty() bool {
+	return len(*p) == 0
+}
+
+func (p *parser) bytes(out *[]byte, n int) bool {
+	if n < 0 || len(*p) < n {
+		return false
+	}
+
+	*out = (*p)[:n:n]
+	*p = (*p)[n:]
+
+	return true
+}
+
+func (p *parser) uint8(out *uint8) bool {
+	if len(*p) < 1 {
+		return false
+	}
+
+	*out = (*p)[0]
+	*p = (*p)[1:]
+
+	return true
+}
+
+func (p *parser) uint16(out *uint16) bool {
+	if len(*p) < 2 {
+		return false
+	}
+
+	*out = uint16((*p)[0])<<8 | uint16((*p)[1])
+	*p = (*p)[2:]
+
+	return true
+}
+
+func (p *parser) uint24(out *int) bool {
+	if len(*p) < 3 {
+		return false
+	}
+
+	*out = int((*p)[0])<<16 | int((*p)[1])<<8 | int((*p)[2])
+	*p = (*p)[3:]
+
+	return true
+}
+
+func (p *parser) vector8(out *[]byte) bool {
+	rest := *p
+
+	var n uint8
+	if !p.uint8(&n) || !p.bytes(out, int(n)) {
+		*p = rest
+
+		return false
+	}
+
+	return true
+}
+
+func (p *parser) vector16(out *[]byte) bool {
+	rest := *p
+
+	var n uint16
+	if !p.uint16(&n) || !p.bytes(out, int(n)) {
+		*p = rest
+
+		return false
+	}
+
+	return true
+}
+
+func (p *parser) vector24(out *[]byte) bool {
+	rest := *p
+
+	var n int
+	if !p.uint24(&n) || !p.bytes(out, n) {
+		*p = rest
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,192 @@
+package handshake
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
+)
+
+// Signalling cipher suite values, which never name a suite to select.
+const (
+	scsvRenegotiation uint16 = 0x00ff
+	scsvFallback      uint16 = 0x5600
+)
+
+// ErrNoCommonSuite is returned by NewServerHello when the client offers
+// no cipher suite that can be selected.
+var ErrNoCommonSuite = errors.New("handshake: no cipher suite to select")
+
+// ClientHelloInfo is a decoded ClientHello, as read by checks that play
+// the server.
+type ClientHelloInfo struct {
+	Version            uint16
+	Random             [32]byte
+	SessionID          []byte
+	CipherSuites       []uint16
+	CompressionMethods []uint8
+	Extensions         []Extension
+}
+
+// ParseClientHello decodes the body of a ClientHello message.
+func ParseClientHello(body []byte) (*ClientHelloInfo, error) {
+	p := parser(body)
+	hello := &ClientHelloInfo{}
+
+	var random, suites, compression []byte
+
+	ok := p.uint16(&hello.Version) &&
+		p.bytes(&random, 32) &&
+		p.vector8(&hello.SessionID) &&
+		p.vector16(&suites) &&
+		p.vector8(&compression)
+	if !ok || len(suites)%2 != 0 {
+		return nil, fmt.Errorf("ClientHello: %w", ErrTruncated)
+	}
+
+	copy(hello.Random[:], random)
+	hello.CompressionMethods = compression
+
+	for i := 0; i < len(suites); i += 2 {
+		hello.CipherSuites = append(hello.CipherSuites, uint16(suites[i])<<8|uint16(suites[i+1]))
+	}
+
+	// the extensions block is optional
+	if p.empty() {
+		return hello, nil
+	}
+
+	var exts []byte
+	if !p.vector16(&exts) || !p.empty() {
+		return nil, fmt.Errorf("ClientHello extensions: %w", ErrTruncated)
+	}
+
+	var err error
+
+	hello.Extensions, err = parseExtensions(exts)
+	if err != nil {
+		return nil, err
+	}
+
+	return hello, nil
+}
+
+// Extension returns the data of the extension of type typ.
+func (c *ClientHelloInfo) Extension(typ uint16) ([]byte, bool) {
+	for _, ext := range c.Extensions {
+		if ext.Type == typ {
+			return ext.Data, true
+		}
+	}
+
+	return nil, false
+}
+
+// HeartbeatMode returns the mode of the heartbeat extension, if the
+// client offered it.
+func (c *ClientHelloInfo) HeartbeatMode() (uint8, bool) {
+	data, ok := c.Extension(ExtHeartbeat)
+	if !ok || len(data) != 1 {
+		return 0, false
+	}
+
+	return data[0], true
+}
+
+// ServerName returns the host name of the server_name extension, or ""
+// without one.
+func (c *ClientHelloInfo) ServerName() string {
+	data, ok := c.Extension(ExtServerName)
+	if !ok {
+		return ""
+	}
+
+	p := parser(data)
+
+	var list []byte
+	if !p.vector16(&list) {
+		return ""
+	}
+
+	p = parser(list)
+
+	for !p.empty() {
+		var (
+			nameType uint8
+			name     []byte
+		)
+
+		if !p.uint8(&nameType) || !p.vector16(&name) {
+			return ""
+		}
+
+		if nameType == 0 {
+			return string(name)
+		}
+	}
+
+	return ""
+}
+
+// NewServerHello answers hello at its version, capped at maxVersion,
+// with the first cipher suite it offers and null compression. The random
+// is fresh; extensions are left to the caller.
+func NewServerHello(hello *ClientHelloInfo, maxVersion uint16) (*ServerHello, error) {
+	version := min(hello.Version, maxVersion)
+	if version < tlsrecord.VersionSSL30 {
+		return nil, fmt.Errorf("handshake: client version 0x%04x not supported", hello.Version)
+	}
+
+	sh := &ServerHello{Version: version, CompressionMethod: CompressionNone}
+
+	for _, id := range hello.CipherSuites {
+		// skip signalling values, GREASE and TLS 1.3 suites
+		if id == scsvRenegotiation || id == scsvFallback || id&0x0f0f == 0x0a0a || id>>8 == 0x13 {
+			continue
+		}
+
+		sh.CipherSuite = id
+
+		break
+	}
+
+	if sh.CipherSuite == 0 {
+		return nil, ErrNoCommonSuite
+	}
+
+	_, err := rand.Read(sh.Random[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return sh, nil
+}
+
+// Marshal returns the ServerHello handshake message body. The extensions
+// block is omitted when there are no extensions.
+func (s *ServerHello) Marshal() ([]byte, error) {
+	var b builder
+
+	b.uint16(s.Version)
+	b.bytes(s.Random[:])
+	b.vector(1, s.SessionID)
+	b.uint16(s.CipherSuite)
+	b.uint8(s.CompressionMethod)
+
+	if len(s.Extensions) > 0 {
+		var eb builder
+		for _, ext := range s.Extensions {
+			eb.uint16(ext.Type)
+			eb.vector(2, ext.Data)
+		}
+
+		b.vector(2, eb.buf)
+
+		if eb.err != nil {
+			return nil, eb.err
+		}
+	}
+
+	return b.buf, b.err
+}
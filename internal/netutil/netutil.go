@@ -0,0 +1,130 @@
+// Package netutil holds the connection helpers shared by the network
+// checks so that every phase honours both its own time limit and the
+// caller's context.
+package netutil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+)
+
+// Deadline returns the time at which a phase limited to timeout must
+// end. It is never later than the deadline of ctx. A zero timeout means
+// the phase is bounded by ctx only.
+func Deadline(ctx context.Context, timeout time.Duration) time.Time {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+
+	return deadline
+}
+
+// Dial connects to address through dialer, limiting the attempt to
+// timeout. A nil dialer dials directly.
+func Dial(ctx context.Context, dialer checker.Dialer, network, address string,
+	timeout time.Duration,
+) (net.Conn, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+
+	return dialer.DialContext(ctx, network, address)
+}
+
+// AbortOnDone unblocks any pending read or write on conn once ctx is
+// done. The returned function stops watching ctx.
+func AbortOnDone(ctx context.Context, conn net.Conn) func() bool {
+	return context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+}
+
+// SetReadDeadline limits the next reads on conn to timeout. It fails
+// without touching conn when ctx is already done.
+func SetReadDeadline(ctx context.Context, conn net.Conn, timeout time.Duration) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	return conn.SetReadDeadline(Deadline(ctx, timeout))
+}
+
+// Write writes data to conn, limiting the write to timeout.
+func Write(ctx context.Context, conn net.Conn, data []byte, timeout time.Duration) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	err = conn.SetWriteDeadline(Deadline(ctx, timeout))
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write(data)
+
+	return err
+}
+
+// ReadErr returns ctx.Err() in place of a read error once ctx is done,
+// and err otherwise. A done ctx cuts reads short through AbortOnDone,
+// and the deadline error that leaves would otherwise pass for a silent
+// peer. A read that succeeded just before ctx was done is kept.
+func ReadErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// Serve accepts connections on ln and passes each to handle in its own
+// goroutine, closing it once handle returns. ln is closed when ctx is
+// done and Serve then returns ctx.Err(), or nil if ln was closed by the
+// caller, once every handle has returned.
+func Serve(ctx context.Context, ln net.Listener, handle func(conn net.Conn)) error {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		wg.Go(func() {
+			defer conn.Close()
+
+			handle(conn)
+		})
+	}
+}
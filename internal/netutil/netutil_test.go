@@ -0,0 +1,143 @@
+package netutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDeadline(t *testing.T) {
+	if !Deadline(context.Background(), 0).IsZero() {
+		t.Errorf("expected no deadline without timeout or context deadline")
+	}
+
+	d := Deadline(context.Background(), time.Hour)
+	if time.Until(d) < 59*time.Minute {
+		t.Errorf("deadline too early: %v", d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	d = Deadline(ctx, time.Hour)
+	if time.Until(d) > time.Second {
+		t.Errorf("deadline should be bounded by the context, got: %v", d)
+	}
+}
+
+func TestAbortOnDone(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stop := AbortOnDone(ctx, client)
+	defer stop()
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := client.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) && !isTimeout(err) {
+			t.Errorf("expected timeout error, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read was not aborted after cancel")
+	}
+}
+
+func TestWriteCanceled(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := Write(ctx, client, []byte{0x01}, time.Second)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+}
+
+func TestReadErr(t *testing.T) {
+	err := ReadErr(context.Background(), os.ErrDeadlineExceeded)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected the read error, got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = ReadErr(ctx, os.ErrDeadlineExceeded)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+
+	err = ReadErr(ctx, nil)
+	if err != nil {
+		t.Errorf("expected a successful read to be kept, got: %v", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	lc := net.ListenConfig{}
+
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handled := make(chan net.Conn, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- Serve(ctx, ln, func(conn net.Conn) { handled <- conn })
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer client.Close()
+
+	<-handled
+
+	// the connection is closed once handle returns
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	_, err = client.Read(make([]byte, 1))
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("expected the connection to be closed, got: %v", err)
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after cancel")
+	}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+
+	return errors.As(err, &ne) && ne.Timeout()
+}
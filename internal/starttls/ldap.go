@@ -0,0 +1,138 @@
+package starttls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ldapStartTLSRequest is an LDAPMessage with message ID 1 holding an
+// ExtendedRequest for the StartTLS OID 1.3.6.1.4.1.1466.20037 (RFC 4511).
+var ldapStartTLSRequest = append([]byte{
+	0x30, 0x1d, // LDAPMessage
+	0x02, 0x01, 0x01, // messageID
+	0x77, 0x18, // [APPLICATION 23] ExtendedRequest
+	0x80, 0x16, // [0] requestName
+}, "1.3.6.1.4.1.1466.20037"...)
+
+// BER tags in the ExtendedResponse.
+const (
+	berInteger           = 0x02
+	berEnumerated        = 0x0a
+	berSequence          = 0x30
+	ldapExtendedResponse = 0x78
+)
+
+var errLDAPResponse = errors.New("ldap: malformed StartTLS response")
+
+// ldap sends the StartTLS extended operation and checks its result code.
+func ldap(rw io.ReadWriter) error {
+	_, err := rw.Write(ldapStartTLSRequest)
+	if err != nil {
+		return err
+	}
+
+	tag, msg, err := readBER(rw)
+	if err != nil {
+		return fmt.Errorf("ldap: reading StartTLS response: %w", err)
+	}
+
+	if tag != berSequence {
+		return errLDAPResponse
+	}
+
+	tag, _, msg, ok := nextBER(msg)
+	if !ok || tag != berInteger {
+		return errLDAPResponse
+	}
+
+	tag, resp, _, ok := nextBER(msg)
+	if !ok || tag != ldapExtendedResponse {
+		return errLDAPResponse
+	}
+
+	tag, code, _, ok := nextBER(resp)
+	if !ok || tag != berEnumerated || len(code) != 1 {
+		return errLDAPResponse
+	}
+
+	if code[0] != 0 {
+		return fmt.Errorf("ldap: %w: result code %d", ErrNotSupported, code[0])
+	}
+
+	return nil
+}
+
+// readBER reads one BER element from r and returns its tag and contents.
+func readBER(r io.Reader) (uint8, []byte, error) {
+	var hdr [2]byte
+
+	_, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	n := int(hdr[1])
+
+	if hdr[1]&0x80 != 0 {
+		// long form: the low bits give the number of length bytes
+		lenBytes := int(hdr[1] & 0x7f)
+		if lenBytes == 0 || lenBytes > 3 {
+			return 0, nil, errLDAPResponse
+		}
+
+		buf := make([]byte, lenBytes)
+
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		n = 0
+		for _, b := range buf {
+			n = n<<8 | int(b)
+		}
+	}
+
+	if n > maxPreamble {
+		return 0, nil, errLDAPResponse
+	}
+
+	body := make([]byte, n)
+
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return hdr[0], body, nil
+}
+
+// nextBER splits the first BER element off data.
+func nextBER(data []byte) (tag uint8, contents, rest []byte, ok bool) {
+	if len(data) < 2 {
+		return 0, nil, nil, false
+	}
+
+	n, hdrLen := int(data[1]), 2
+
+	if data[1]&0x80 != 0 {
+		lenBytes := int(data[1] & 0x7f)
+		if lenBytes == 0 || lenBytes > 3 || len(data) < 2+lenBytes {
+			return 0, nil, nil, false
+		}
+
+		n = 0
+		for _, b := range data[2 : 2+lenBytes] {
+			n = n<<8 | int(b)
+		}
+
+		hdrLen += lenBytes
+	}
+
+	if len(data) < hdrLen+n {
+		return 0, nil, nil, false
+	}
+
+	return data[0], data[hdrLen : hdrLen+n], data[hdrLen+n:], true
+}
@@ -0,0 +1,32 @@
+package starttls
+
+import (
+	"fmt"
+	"io"
+)
+
+// postgresSSLRequest is the SSLRequest startup packet: its length and the
+// request code 80877103.
+var postgresSSLRequest = []byte{0x00, 0x00, 0x00, 0x08, 0x04, 0xd2, 0x16, 0x2f}
+
+// postgres asks the server to switch to TLS. It answers with a single
+// byte: 'S' to proceed, 'N' to refuse.
+func postgres(rw io.ReadWriter) error {
+	_, err := rw.Write(postgresSSLRequest)
+	if err != nil {
+		return err
+	}
+
+	var reply [1]byte
+
+	_, err = io.ReadFull(rw, reply[:])
+	if err != nil {
+		return fmt.Errorf("postgres: reading SSLRequest reply: %w", err)
+	}
+
+	if reply[0] != 'S' {
+		return fmt.Errorf("postgres: %w: reply %q", ErrNotSupported, reply[0])
+	}
+
+	return nil
+}
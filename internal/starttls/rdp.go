@@ -0,0 +1,76 @@
+package starttls
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// rdpConnectionRequest is a TPKT framed X.224 Connection Request carrying
+// an RDP Negotiation Request for TLS or CredSSP ([MS-RDPBCGR] 2.2.1.1).
+var rdpConnectionRequest = []byte{
+	0x03, 0x00, 0x00, 0x13, // TPKT header, length 19
+	0x0e, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, // X.224 Connection Request
+	0x01, 0x00, 0x08, 0x00, // RDP_NEG_REQ, flags, length
+	0x03, 0x00, 0x00, 0x00, // PROTOCOL_SSL | PROTOCOL_HYBRID
+}
+
+// RDP negotiation message types.
+const (
+	rdpNegResponse = 0x02
+	rdpNegFailure  = 0x03
+	// x224Header is the length of the X.224 Connection Confirm header
+	// that precedes the negotiation response.
+	x224Header = 7
+)
+
+var errRDPResponse = errors.New("rdp: malformed Connection Confirm")
+
+// rdp negotiates a security protocol based on TLS.
+func rdp(rw io.ReadWriter) error {
+	_, err := rw.Write(rdpConnectionRequest)
+	if err != nil {
+		return err
+	}
+
+	var tpkt [4]byte
+
+	_, err = io.ReadFull(rw, tpkt[:])
+	if err != nil {
+		return fmt.Errorf("rdp: reading Connection Confirm: %w", err)
+	}
+
+	n := int(binary.BigEndian.Uint16(tpkt[2:]))
+	if tpkt[0] != 0x03 || n < len(tpkt)+x224Header || n > maxPreamble {
+		return errRDPResponse
+	}
+
+	body := make([]byte, n-len(tpkt))
+
+	_, err = io.ReadFull(rw, body)
+	if err != nil {
+		return fmt.Errorf("rdp: reading Connection Confirm: %w", err)
+	}
+
+	neg := body[x224Header:]
+	if len(neg) < 8 {
+		// servers without negotiation only speak standard RDP security
+		return fmt.Errorf("rdp: %w: no negotiation response", ErrNotSupported)
+	}
+
+	value := binary.LittleEndian.Uint32(neg[4:8])
+
+	switch neg[0] {
+	case rdpNegResponse:
+		if value == 0 {
+			return fmt.Errorf("rdp: %w: standard RDP security selected", ErrNotSupported)
+		}
+
+		return nil
+	case rdpNegFailure:
+		return fmt.Errorf("rdp: %w: failure code %d", ErrNotSupported, value)
+	default:
+		return errRDPResponse
+	}
+}
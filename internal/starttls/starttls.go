@@ -0,0 +1,166 @@
+// Package starttls upgrades a plaintext connection so that the network
+// checks can send their ClientHello on it. SMTP, IMAP, POP3, FTP and
+// MySQL are negotiated by starttls-go; the other protocols are
+// implemented here.
+package starttls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	lib "github.com/jsandas/starttls-go/starttls"
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/internal/netutil"
+)
+
+// ErrNotSupported is returned when the server does not offer to start
+// TLS. It is the same error starttls-go returns.
+var ErrNotSupported = lib.ErrStartTLSNotSupported
+
+// ErrUnknownProtocol is returned for a Protocol this package cannot
+// negotiate.
+var ErrUnknownProtocol = errors.New("starttls: unknown protocol")
+
+// maxPreamble bounds how much plaintext is read from the server before
+// TLS starts.
+const maxPreamble = 64 << 10
+
+// libStartTLS is a package-level variable so it can be replaced in tests.
+var libStartTLS = lib.StartTLS
+
+// libPorts maps the protocols handled by starttls-go to the port that
+// selects them there.
+var libPorts = map[checker.Protocol]string{
+	checker.ProtocolFTP:   "21",
+	checker.ProtocolSMTP:  "25",
+	checker.ProtocolPOP3:  "110",
+	checker.ProtocolIMAP:  "143",
+	checker.ProtocolMySQL: "3306",
+}
+
+// portProtocols maps the ports conventionally used for STARTTLS to their
+// protocol.
+var portProtocols = map[string]checker.Protocol{
+	"21":   checker.ProtocolFTP,
+	"25":   checker.ProtocolSMTP,
+	"110":  checker.ProtocolPOP3,
+	"143":  checker.ProtocolIMAP,
+	"389":  checker.ProtocolLDAP,
+	"587":  checker.ProtocolSMTP,
+	"3306": checker.ProtocolMySQL,
+	"3389": checker.ProtocolRDP,
+	"5222": checker.ProtocolXMPP,
+	"5432": checker.ProtocolPostgres,
+}
+
+// ForPort returns the protocol conventionally spoken on port before TLS,
+// or ProtocolNone for ports that start with TLS.
+func ForPort(port string) checker.Protocol {
+	proto, ok := portProtocols[port]
+	if !ok {
+		return checker.ProtocolNone
+	}
+
+	return proto
+}
+
+// Resolve returns proto, or the protocol inferred from port when proto
+// is empty.
+func Resolve(proto checker.Protocol, port string) checker.Protocol {
+	if proto == "" {
+		return ForPort(port)
+	}
+
+	return proto
+}
+
+// Negotiate runs the plaintext exchange of proto on conn and returns once
+// the server expects a ClientHello. serverName is the domain announced
+// by protocols that name the server, such as XMPP.
+func Negotiate(ctx context.Context, conn net.Conn, proto checker.Protocol, serverName string) error {
+	if proto == checker.ProtocolNone {
+		return nil
+	}
+
+	stop := netutil.AbortOnDone(ctx, conn)
+	defer stop()
+
+	err := conn.SetDeadline(netutil.Deadline(ctx, 0))
+	if err != nil {
+		return err
+	}
+
+	switch proto {
+	case checker.ProtocolXMPP:
+		err = xmpp(conn, serverName)
+	case checker.ProtocolLDAP:
+		err = ldap(conn)
+	case checker.ProtocolPostgres:
+		err = postgres(conn)
+	case checker.ProtocolRDP:
+		err = rdp(conn)
+	default:
+		port, ok := libPorts[proto]
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownProtocol, proto)
+		}
+
+		err = libStartTLS(ctx, conn, port)
+	}
+
+	if err != nil {
+		// report the context rather than the deadline it caused
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return err
+	}
+
+	return conn.SetDeadline(time.Time{})
+}
+
+// Start runs Negotiate bounded by timeout, as the network checks do
+// before their ClientHello. serverName is announced where the protocol
+// asks for it, or host when serverName is empty.
+func Start(ctx context.Context, conn net.Conn, proto checker.Protocol, host, serverName string,
+	timeout time.Duration,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if serverName == "" {
+		serverName = host
+	}
+
+	return Negotiate(ctx, conn, proto, serverName)
+}
+
+// readUntil reads from r one byte at a time until the data read ends
+// with token, so nothing past the plaintext exchange is consumed.
+func readUntil(r io.Reader, token string) (string, error) {
+	var (
+		buf strings.Builder
+		b   [1]byte
+	)
+
+	for buf.Len() < maxPreamble {
+		_, err := io.ReadFull(r, b[:])
+		if err != nil {
+			return buf.String(), err
+		}
+
+		buf.WriteByte(b[0])
+
+		if strings.HasSuffix(buf.String(), token) {
+			return buf.String(), nil
+		}
+	}
+
+	return buf.String(), fmt.Errorf("starttls: no %q in the first %d bytes", token, maxPreamble)
+}
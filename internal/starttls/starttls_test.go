@@ -0,0 +1,171 @@
+package starttls
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/internal/starttls/starttlstest"
+)
+
+// pipe runs serve on the server end of a pipe and returns the client
+// end.
+func pipe(t *testing.T, serve func(net.Conn) error) net.Conn {
+	t.Helper()
+
+	client, server := net.Pipe()
+
+	t.Cleanup(func() { client.Close() })
+
+	go func() {
+		defer server.Close()
+
+		err := serve(server)
+		if err != nil {
+			return
+		}
+
+		// stands in for the first bytes of the TLS handshake
+		server.Write([]byte("TLS"))
+	}()
+
+	return client
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, proto := range checker.Protocols {
+		t.Run(string(proto), func(t *testing.T) {
+			conn := pipe(t, func(c net.Conn) error { return starttlstest.Serve(c, proto) })
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			err := Negotiate(ctx, conn, proto, "example.com")
+			if err != nil {
+				t.Fatalf("Negotiate failed: %v", err)
+			}
+
+			// nothing past the plaintext exchange may be consumed
+			buf := make([]byte, 3)
+
+			_, err = io.ReadFull(conn, buf)
+			if err != nil || string(buf) != "TLS" {
+				t.Errorf("expected TLS bytes after negotiation, got %q (%v)", buf, err)
+			}
+		})
+	}
+}
+
+func TestNegotiateRefused(t *testing.T) {
+	for _, proto := range checker.Protocols {
+		if proto == checker.ProtocolNone {
+			continue
+		}
+
+		t.Run(string(proto), func(t *testing.T) {
+			conn := pipe(t, func(c net.Conn) error { return starttlstest.Refuse(c, proto) })
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			err := Negotiate(ctx, conn, proto, "example.com")
+			if !errors.Is(err, ErrNotSupported) {
+				t.Errorf("expected ErrNotSupported, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestNegotiateTimeout(t *testing.T) {
+	// a server that never greets
+	conn := pipe(t, func(c net.Conn) error {
+		_, err := io.Copy(io.Discard, c)
+
+		return err
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	for _, proto := range []checker.Protocol{checker.ProtocolXMPP, checker.ProtocolSMTP} {
+		err := Negotiate(ctx, conn, proto, "example.com")
+		if err == nil {
+			t.Errorf("%s: expected timeout error", proto)
+		}
+	}
+
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("context deadline not honoured, took %v", time.Since(start))
+	}
+
+	err := Negotiate(context.Background(), conn, "gopher", "")
+	if !errors.Is(err, ErrUnknownProtocol) {
+		t.Errorf("expected ErrUnknownProtocol, got: %v", err)
+	}
+}
+
+func TestStart(t *testing.T) {
+	// without a server name the host is announced
+	names := make(chan string, 1)
+	conn := pipe(t, func(c net.Conn) error {
+		header, err := readUntil(c, "version='1.0'>")
+		names <- header
+
+		return err
+	})
+
+	err := Start(context.Background(), conn, checker.ProtocolXMPP, "192.0.2.1", "", 100*time.Millisecond)
+	if err == nil {
+		t.Errorf("expected timeout error")
+	}
+
+	if header := <-names; !strings.Contains(header, "to='192.0.2.1'") {
+		t.Errorf("host not announced, got: %q", header)
+	}
+
+	// a server that never greets is abandoned after the timeout
+	conn = pipe(t, func(c net.Conn) error {
+		_, err := io.Copy(io.Discard, c)
+
+		return err
+	})
+
+	start := time.Now()
+
+	err = Start(context.Background(), conn, checker.ProtocolSMTP, "mail.example.com", "", 100*time.Millisecond)
+	if err == nil || time.Since(start) > 2*time.Second {
+		t.Errorf("timeout not honoured, got: %v after %v", err, time.Since(start))
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		proto checker.Protocol
+		port  string
+		want  checker.Protocol
+	}{
+		{"", "25", checker.ProtocolSMTP},
+		{"", "587", checker.ProtocolSMTP},
+		{"", "5222", checker.ProtocolXMPP},
+		{"", "389", checker.ProtocolLDAP},
+		{"", "3306", checker.ProtocolMySQL},
+		{"", "3389", checker.ProtocolRDP},
+		{"", "5432", checker.ProtocolPostgres},
+		{"", "8443", checker.ProtocolNone},
+		{checker.ProtocolSMTP, "2525", checker.ProtocolSMTP},
+		{checker.ProtocolNone, "25", checker.ProtocolNone},
+	}
+
+	for _, tt := range tests {
+		if got := Resolve(tt.proto, tt.port); got != tt.want {
+			t.Errorf("Resolve(%q, %s) = %q, want %q", tt.proto, tt.port, got, tt.want)
+		}
+	}
+}
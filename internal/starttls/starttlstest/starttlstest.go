@@ -0,0 +1,288 @@
+// Package starttlstest provides the server side of each STARTTLS
+// dialect so tests can stand up plaintext services in front of their
+// fake TLS servers.
+package starttlstest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+)
+
+// Serve runs the server side of proto on conn and returns once the
+// client may send its ClientHello.
+func Serve(conn net.Conn, proto checker.Protocol) error {
+	return serve(conn, proto, true)
+}
+
+// Refuse runs the server side of proto on conn but declines to start
+// TLS.
+func Refuse(conn net.Conn, proto checker.Protocol) error {
+	return serve(conn, proto, false)
+}
+
+func serve(conn net.Conn, proto checker.Protocol, accept bool) error {
+	switch proto {
+	case checker.ProtocolNone:
+		return nil
+	case checker.ProtocolSMTP:
+		return lines(conn, "220 test ESMTP\r\n",
+			[]exchange{{"EHLO", "250-test\r\n250 STARTTLS\r\n"}}, "STARTTLS", "220 ready\r\n", "454 TLS unavailable\r\n", accept)
+	case checker.ProtocolIMAP:
+		return lines(conn, "* OK [CAPABILITY IMAP4rev1 STARTTLS] ready\r\n",
+			nil, "a001 STARTTLS", "a001 OK begin TLS\r\n", "a001 BAD unknown command\r\n", accept)
+	case checker.ProtocolPOP3:
+		return lines(conn, "+OK ready\r\n", nil, "STLS", "+OK begin TLS\r\n", "-ERR unknown command\r\n", accept)
+	case checker.ProtocolFTP:
+		return lines(conn, "220 ready\r\n", nil, "AUTH TLS", "234 AUTH TLS ok\r\n", "502 not implemented\r\n", accept)
+	case checker.ProtocolXMPP:
+		return xmpp(conn, accept)
+	case checker.ProtocolLDAP:
+		return ldap(conn, accept)
+	case checker.ProtocolPostgres:
+		return postgres(conn, accept)
+	case checker.ProtocolMySQL:
+		return mysql(conn, accept)
+	case checker.ProtocolRDP:
+		return rdp(conn, accept)
+	default:
+		return fmt.Errorf("starttlstest: unknown protocol %q", proto)
+	}
+}
+
+// exchange is a command the client is expected to send and the reply.
+type exchange struct {
+	command string
+	reply   string
+}
+
+// lines serves a line based protocol: a greeting, optional exchanges and
+// the command that starts TLS.
+func lines(conn net.Conn, greeting string, exchanges []exchange, startTLS, ok, refused string, accept bool) error {
+	r := bufio.NewReader(conn)
+
+	_, err := io.WriteString(conn, greeting)
+	if err != nil {
+		return err
+	}
+
+	for _, ex := range append(exchanges, exchange{startTLS, ok}) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		if !strings.HasPrefix(strings.ToUpper(line), strings.ToUpper(ex.command)) {
+			return fmt.Errorf("starttlstest: got %q, want %s", line, ex.command)
+		}
+
+		reply := ex.reply
+		if ex.command == startTLS && !accept {
+			reply = refused
+		}
+
+		_, err = io.WriteString(conn, reply)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !accept {
+		return errors.New("starttlstest: refused")
+	}
+
+	return nil
+}
+
+func xmpp(conn net.Conn, accept bool) error {
+	_, err := readUntil(conn, "version='1.0'>")
+	if err != nil {
+		return err
+	}
+
+	features := "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls>"
+	if !accept {
+		features = "<mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>PLAIN</mechanism></mechanisms>"
+	}
+
+	_, err = io.WriteString(conn, "<?xml version='1.0'?><stream:stream xmlns='jabber:client' "+
+		"xmlns:stream='http://etherx.jabber.org/streams' id='1' from='test' version='1.0'>"+
+		"<stream:features>"+features+"</stream:features>")
+	if err != nil {
+		return err
+	}
+
+	if !accept {
+		return errors.New("starttlstest: refused")
+	}
+
+	_, err = readUntil(conn, "/>")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(conn, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+
+	return err
+}
+
+// ldapResponse is an ExtendedResponse for message ID 1 with an empty
+// matchedDN and diagnosticMessage; the result code is filled in.
+var ldapResponse = []byte{0x30, 0x0c, 0x02, 0x01, 0x01, 0x78, 0x07, 0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00}
+
+func ldap(conn net.Conn, accept bool) error {
+	var hdr [2]byte
+
+	_, err := io.ReadFull(conn, hdr[:])
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(io.Discard, conn, int64(hdr[1]))
+	if err != nil {
+		return err
+	}
+
+	resp := append([]byte(nil), ldapResponse...)
+	if !accept {
+		resp[9] = 2 // protocolError
+	}
+
+	_, err = conn.Write(resp)
+	if err != nil {
+		return err
+	}
+
+	if !accept {
+		return errors.New("starttlstest: refused")
+	}
+
+	return nil
+}
+
+func postgres(conn net.Conn, accept bool) error {
+	_, err := io.CopyN(io.Discard, conn, 8)
+	if err != nil {
+		return err
+	}
+
+	if !accept {
+		_, err = conn.Write([]byte{'N'})
+		if err != nil {
+			return err
+		}
+
+		return errors.New("starttlstest: refused")
+	}
+
+	_, err = conn.Write([]byte{'S'})
+
+	return err
+}
+
+// mysqlGreeting builds a protocol 10 handshake packet with the given
+// lower capability flags and character set.
+func mysqlGreeting(capabilities uint16, charset byte) []byte {
+	body := []byte{0x0a}
+	body = append(body, "8.0.0\x00"...)
+	body = append(body, 0x01, 0x00, 0x00, 0x00) // thread id
+	body = append(body, "abcdefgh"...)          // auth plugin data, part 1
+	body = append(body, 0x00)                   // filler
+	body = append(body, byte(capabilities), byte(capabilities>>8), charset)
+	body = append(body, 0x02, 0x00, 0x00, 0x00) // status, upper capabilities
+	body = append(body, 0x15)
+	body = append(body, make([]byte, 10)...)
+	body = append(body, "ijklmnopqrst\x00mysql_native_password\x00"...)
+
+	return append([]byte{byte(len(body)), byte(len(body) >> 8), byte(len(body) >> 16), 0x00}, body...)
+}
+
+// MySQL capability flags.
+const (
+	mysqlProtocol41 = 0x0200
+	mysqlSSL        = 0x0800
+)
+
+func mysql(conn net.Conn, accept bool) error {
+	if !accept {
+		_, err := conn.Write(mysqlGreeting(mysqlProtocol41, 0x21))
+		if err != nil {
+			return err
+		}
+
+		return errors.New("starttlstest: refused")
+	}
+
+	_, err := conn.Write(mysqlGreeting(mysqlProtocol41|mysqlSSL, 0xff))
+	if err != nil {
+		return err
+	}
+
+	// SSLRequest packet: header and 32 byte payload
+	_, err = io.CopyN(io.Discard, conn, 4+32)
+
+	return err
+}
+
+// rdpConfirm is an X.224 Connection Confirm selecting PROTOCOL_SSL; the
+// refusal variant carries an RDP_NEG_FAILURE instead.
+var rdpConfirm = []byte{
+	0x03, 0x00, 0x00, 0x13,
+	0x0e, 0xd0, 0x00, 0x00, 0x12, 0x34, 0x00,
+	0x02, 0x00, 0x08, 0x00,
+	0x01, 0x00, 0x00, 0x00,
+}
+
+func rdp(conn net.Conn, accept bool) error {
+	var tpkt [4]byte
+
+	_, err := io.ReadFull(conn, tpkt[:])
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(io.Discard, conn, int64(tpkt[2])<<8|int64(tpkt[3])-4)
+	if err != nil {
+		return err
+	}
+
+	resp := append([]byte(nil), rdpConfirm...)
+	if !accept {
+		resp[11] = 0x03 // RDP_NEG_FAILURE
+		resp[15] = 0x02 // SSL_NOT_ALLOWED_BY_SERVER
+	}
+
+	_, err = conn.Write(resp)
+	if err != nil {
+		return err
+	}
+
+	if !accept {
+		return errors.New("starttlstest: refused")
+	}
+
+	return nil
+}
+
+func readUntil(r io.Reader, token string) (string, error) {
+	var (
+		buf strings.Builder
+		b   [1]byte
+	)
+
+	for !strings.HasSuffix(buf.String(), token) {
+		_, err := io.ReadFull(r, b[:])
+		if err != nil {
+			return buf.String(), err
+		}
+
+		buf.WriteByte(b[0])
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,48 @@
+package starttls
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// XMPP stream elements (RFC 6120).
+const (
+	xmppStreamHeader = "<?xml version='1.0'?><stream:stream xmlns='jabber:client' " +
+		"xmlns:stream='http://etherx.jabber.org/streams' to='%s' version='1.0'>"
+	xmppStartTLS = "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"
+	xmppTLSNS    = "urn:ietf:params:xml:ns:xmpp-tls"
+)
+
+// xmpp opens a client stream to domain and asks to start TLS.
+func xmpp(rw io.ReadWriter, domain string) error {
+	_, err := fmt.Fprintf(rw, xmppStreamHeader, domain)
+	if err != nil {
+		return err
+	}
+
+	features, err := readUntil(rw, "</stream:features>")
+	if err != nil {
+		return fmt.Errorf("xmpp: reading stream features: %w", err)
+	}
+
+	if !strings.Contains(features, xmppTLSNS) {
+		return fmt.Errorf("xmpp: %w", ErrNotSupported)
+	}
+
+	_, err = io.WriteString(rw, xmppStartTLS)
+	if err != nil {
+		return err
+	}
+
+	reply, err := readUntil(rw, ">")
+	if err != nil {
+		return fmt.Errorf("xmpp: reading STARTTLS reply: %w", err)
+	}
+
+	if !strings.Contains(reply, "<proceed") {
+		return fmt.Errorf("xmpp: %w: %s", ErrNotSupported, strings.TrimSpace(reply))
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package tlsrecord
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+)
+
+// Alert levels.
+const (
+	AlertLevelWarning uint8 = 1
+	AlertLevelFatal   uint8 = 2
+)
+
+// Alert descriptions.
+const (
+	AlertCloseNotify           uint8 = 0
+	AlertUnexpectedMessage     uint8 = 10
+	AlertBadRecordMAC          uint8 = 20
+	AlertDecryptionFailed      uint8 = 21
+	AlertRecordOverflow        uint8 = 22
+	AlertDecompressionFailure  uint8 = 30
+	AlertHandshakeFailure      uint8 = 40
+	AlertBadCertificate        uint8 = 42
+	AlertIllegalParameter      uint8 = 47
+	AlertDecodeError           uint8 = 50
+	AlertDecryptError          uint8 = 51
+	AlertProtocolVersion       uint8 = 70
+	AlertInsufficientSecurity  uint8 = 71
+	AlertInternalError         uint8 = 80
+	AlertInappropriateFallback uint8 = 86
+	AlertNoRenegotiation       uint8 = 100
+	AlertUnsupportedExtension  uint8 = 110
+	AlertUnrecognizedName      uint8 = 112
+	AlertNoApplicationProtocol uint8 = 120
+)
+
+var alertNames = map[uint8]string{
+	AlertCloseNotify:           "close_notify",
+	AlertUnexpectedMessage:     "unexpected_message",
+	AlertBadRecordMAC:          "bad_record_mac",
+	AlertDecryptionFailed:      "decryption_failed",
+	AlertRecordOverflow:        "record_overflow",
+	AlertDecompressionFailure:  "decompression_failure",
+	AlertHandshakeFailure:      "handshake_failure",
+	AlertBadCertificate:        "bad_certificate",
+	AlertIllegalParameter:      "illegal_parameter",
+	AlertDecodeError:           "decode_error",
+	AlertDecryptError:          "decrypt_error",
+	AlertProtocolVersion:       "protocol_version",
+	AlertInsufficientSecurity:  "insufficient_security",
+	AlertInternalError:         "internal_error",
+	AlertInappropriateFallback: "inappropriate_fallback",
+	AlertNoRenegotiation:       "no_renegotiation",
+	AlertUnsupportedExtension:  "unsupported_extension",
+	AlertUnrecognizedName:      "unrecognized_name",
+	AlertNoApplicationProtocol: "no_application_protocol",
+}
+
+// AlertError reports an alert received from the peer.
+type AlertError struct {
+	Level       uint8
+	Description uint8
+}
+
+// ParseAlert decodes the payload of an alert record.
+func ParseAlert(payload []byte) (*AlertError, error) {
+	if len(payload) < 2 {
+		return nil, fmt.Errorf("tlsrecord: short alert of %d bytes", len(payload))
+	}
+
+	return &AlertError{Level: payload[0], Description: payload[1]}, nil
+}
+
+// AlertName returns the RFC name of an alert description.
+func AlertName(description uint8) string {
+	name, ok := alertNames[description]
+	if !ok {
+		return fmt.Sprintf("alert(%d)", description)
+	}
+
+	return name
+}
+
+// Refused reports whether err means the peer turned a ClientHello down,
+// with an alert or by closing or resetting the connection. Checks treat
+// that as nothing to test rather than as a failure.
+func Refused(err error) bool {
+	var alert *AlertError
+
+	return errors.As(err, &alert) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// Fatal reports whether the alert has fatal level.
+func (e *AlertError) Fatal() bool {
+	return e.Level == AlertLevelFatal
+}
+
+// Name returns the RFC name of the alert description.
+func (e *AlertError) Name() string {
+	return AlertName(e.Description)
+}
+
+func (e *AlertError) Error() string {
+	level := "warning"
+	if e.Fatal() {
+		level = "fatal"
+	}
+
+	return fmt.Sprintf("tlsrecord: received %s alert: %s", level, e.Name())
+}
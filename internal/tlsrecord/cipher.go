@@ -0,0 +1,214 @@
+package tlsrecord
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash"
+)
+
+// ErrBadRecordMAC is returned for protected records that fail to
+// decrypt or authenticate.
+var ErrBadRecordMAC = errors.New("tlsrecord: bad record MAC")
+
+// Cipher protects the records of one direction of a connection once
+// ChangeCipherSpec has been sent or received. It keeps the sequence
+// number, so a Cipher must not be shared between directions.
+type Cipher interface {
+	// Seal encrypts and authenticates the payload of a record.
+	Seal(typ uint8, version uint16, plaintext []byte) ([]byte, error)
+	// Open decrypts and authenticates the payload of a record.
+	Open(typ uint8, version uint16, payload []byte) ([]byte, error)
+}
+
+// additionalData returns the sequence number and record header covered
+// by the MAC or AEAD tag.
+func additionalData(seq uint64, typ uint8, version uint16, n int) []byte {
+	ad := make([]byte, 13)
+	binary.BigEndian.PutUint64(ad, seq)
+	ad[8] = typ
+	binary.BigEndian.PutUint16(ad[9:], version)
+	binary.BigEndian.PutUint16(ad[11:], Uint16Length(n))
+
+	return ad
+}
+
+// cbcCipher is a block cipher in CBC mode with HMAC, MAC-then-encrypt.
+type cbcCipher struct {
+	block cipher.Block
+	mac   hash.Hash
+	// iv chains records in TLS 1.0, where each record continues from the
+	// last ciphertext block. It is unused with explicit IVs.
+	iv         []byte
+	explicitIV bool
+	seq        uint64
+}
+
+// NewCBC returns a Cipher for a CBC suite. TLS 1.0 chains iv across
+// records; from TLS 1.1 on, explicitIV sends a random IV with each
+// record and iv is ignored.
+func NewCBC(block cipher.Block, newHash func() hash.Hash, macKey, iv []byte, explicitIV bool) Cipher {
+	return &cbcCipher{
+		block:      block,
+		mac:        hmac.New(newHash, macKey),
+		iv:         append([]byte(nil), iv...),
+		explicitIV: explicitIV,
+	}
+}
+
+func (c *cbcCipher) computeMAC(typ uint8, version uint16, plaintext []byte) []byte {
+	c.mac.Reset()
+	c.mac.Write(additionalData(c.seq, typ, version, len(plaintext)))
+	c.mac.Write(plaintext)
+
+	return c.mac.Sum(nil)
+}
+
+func (c *cbcCipher) Seal(typ uint8, version uint16, plaintext []byte) ([]byte, error) {
+	bs := c.block.BlockSize()
+
+	data := append([]byte(nil), plaintext...)
+	data = append(data, c.computeMAC(typ, version, plaintext)...)
+
+	padLen := bs - len(data)%bs
+	for range padLen {
+		data = append(data, byte(padLen-1)) // #nosec G115 -- padLen <= block size
+	}
+
+	iv := c.iv
+	out := []byte{}
+
+	if c.explicitIV {
+		iv = make([]byte, bs)
+
+		_, err := rand.Read(iv)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, iv...)
+	}
+
+	cipher.NewCBCEncrypter(c.block, iv).CryptBlocks(data, data)
+
+	if !c.explicitIV {
+		c.iv = append(c.iv[:0], data[len(data)-bs:]...)
+	}
+
+	c.seq++
+
+	return append(out, data...), nil
+}
+
+func (c *cbcCipher) Open(typ uint8, version uint16, payload []byte) ([]byte, error) {
+	bs := c.block.BlockSize()
+
+	iv := c.iv
+	if c.explicitIV {
+		if len(payload) < bs {
+			return nil, ErrBadRecordMAC
+		}
+
+		iv, payload = payload[:bs], payload[bs:]
+	}
+
+	macLen := c.mac.Size()
+	if len(payload)%bs != 0 || len(payload) < macLen+1 {
+		return nil, ErrBadRecordMAC
+	}
+
+	data := make([]byte, len(payload))
+	cipher.NewCBCDecrypter(c.block, iv).CryptBlocks(data, payload)
+
+	if !c.explicitIV {
+		c.iv = append(c.iv[:0], payload[len(payload)-bs:]...)
+	}
+
+	padLen := int(data[len(data)-1])
+	if padLen+1+macLen > len(data) {
+		return nil, ErrBadRecordMAC
+	}
+
+	for _, b := range data[len(data)-padLen-1:] {
+		if int(b) != padLen {
+			return nil, ErrBadRecordMAC
+		}
+	}
+
+	plaintext := data[:len(data)-padLen-1-macLen]
+	mac := data[len(plaintext) : len(plaintext)+macLen]
+
+	if !hmac.Equal(mac, c.computeMAC(typ, version, plaintext)) {
+		return nil, ErrBadRecordMAC
+	}
+
+	c.seq++
+
+	return plaintext, nil
+}
+
+// gcmCipher is AES-GCM as used by TLS 1.2: a four byte implicit nonce
+// from the key block followed by an eight byte explicit nonce sent with
+// each record.
+type gcmCipher struct {
+	aead  cipher.AEAD
+	fixed []byte
+	seq   uint64
+}
+
+// gcmExplicitNonceLen is the size of the nonce carried in each record.
+const gcmExplicitNonceLen = 8
+
+// NewGCM returns a Cipher for an AES-GCM suite.
+func NewGCM(key, fixedIV []byte) (Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fixedIV)+gcmExplicitNonceLen != aead.NonceSize() {
+		return nil, errors.New("tlsrecord: bad GCM implicit nonce length")
+	}
+
+	return &gcmCipher{aead: aead, fixed: append([]byte(nil), fixedIV...)}, nil
+}
+
+func (c *gcmCipher) nonce(explicit []byte) []byte {
+	return append(append([]byte(nil), c.fixed...), explicit...)
+}
+
+func (c *gcmCipher) Seal(typ uint8, version uint16, plaintext []byte) ([]byte, error) {
+	// the sequence number is a unique explicit nonce
+	explicit := binary.BigEndian.AppendUint64(nil, c.seq)
+	ad := additionalData(c.seq, typ, version, len(plaintext))
+
+	c.seq++
+
+	return c.aead.Seal(explicit, c.nonce(explicit), plaintext, ad), nil
+}
+
+func (c *gcmCipher) Open(typ uint8, version uint16, payload []byte) ([]byte, error) {
+	if len(payload) < gcmExplicitNonceLen+c.aead.Overhead() {
+		return nil, ErrBadRecordMAC
+	}
+
+	explicit, sealed := payload[:gcmExplicitNonceLen], payload[gcmExplicitNonceLen:]
+	ad := additionalData(c.seq, typ, version, len(sealed)-c.aead.Overhead())
+
+	plaintext, err := c.aead.Open(nil, c.nonce(explicit), sealed, ad)
+	if err != nil {
+		return nil, ErrBadRecordMAC
+	}
+
+	c.seq++
+
+	return plaintext, nil
+}
@@ -0,0 +1,138 @@
+package tlsrecord
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Reader reads TLS records from an underlying stream.
+type Reader struct {
+	r io.Reader
+
+	// MaxLength is the largest record payload accepted. Zero means
+	// MaxCiphertext.
+	MaxLength int
+
+	// hs holds handshake bytes read from records but not yet returned.
+	hs []byte
+	// version is the version field of the last record read.
+	version uint16
+	// cipher opens records once the peer has sent ChangeCipherSpec.
+	cipher Cipher
+}
+
+// NewReader returns a Reader reading records from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Version returns the version field of the last record read.
+func (r *Reader) Version() uint16 {
+	return r.version
+}
+
+// SetCipher protects the records read from now on with c.
+func (r *Reader) SetCipher(c Cipher) {
+	r.cipher = c
+}
+
+// Buffered reports whether part of a handshake message is waiting to be
+// returned by ReadHandshake.
+func (r *Reader) Buffered() bool {
+	return len(r.hs) > 0
+}
+
+// ReadRecord reads the next record. It rejects records that do not look
+// like TLS and records longer than MaxLength.
+func (r *Reader) ReadRecord() (*Record, error) {
+	var hdr [HeaderLen]byte
+
+	_, err := io.ReadFull(r.r, hdr[:])
+	if err != nil {
+		return nil, err
+	}
+
+	typ := hdr[0]
+	if typ < TypeChangeCipherSpec || typ > TypeHeartbeat || hdr[1] != 0x03 {
+		return nil, ErrNotTLS
+	}
+
+	maxLen := r.MaxLength
+	if maxLen == 0 {
+		maxLen = MaxCiphertext
+	}
+
+	n := int(binary.BigEndian.Uint16(hdr[3:]))
+	if n > maxLen {
+		return nil, ErrRecordOverflow
+	}
+
+	rec := &Record{
+		Type:    typ,
+		Version: binary.BigEndian.Uint16(hdr[1:]),
+		Payload: make([]byte, n),
+	}
+
+	_, err = io.ReadFull(r.r, rec.Payload)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	r.version = rec.Version
+
+	if r.cipher != nil {
+		rec.Payload, err = r.cipher.Open(rec.Type, rec.Version, rec.Payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rec, nil
+}
+
+// ReadHandshake returns the type and body of the next handshake message,
+// reassembling messages split across records and splitting records that
+// carry several. An alert received instead is returned as *AlertError;
+// any other record type as *UnexpectedRecordError.
+func (r *Reader) ReadHandshake() (uint8, []byte, error) {
+	for {
+		if len(r.hs) >= HandshakeHeaderLen {
+			n := int(r.hs[1])<<16 | int(r.hs[2])<<8 | int(r.hs[3])
+			if n > MaxHandshake {
+				return 0, nil, ErrHandshakeOverflow
+			}
+
+			if len(r.hs) >= HandshakeHeaderLen+n {
+				msgType := r.hs[0]
+				body := r.hs[HandshakeHeaderLen : HandshakeHeaderLen+n : HandshakeHeaderLen+n]
+				r.hs = r.hs[HandshakeHeaderLen+n:]
+
+				return msgType, body, nil
+			}
+		}
+
+		rec, err := r.ReadRecord()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch rec.Type {
+		case TypeHandshake:
+			r.hs = append(r.hs, rec.Payload...)
+		case TypeAlert:
+			alert, err := ParseAlert(rec.Payload)
+			if err != nil {
+				return 0, nil, err
+			}
+
+			return 0, nil, alert
+		default:
+			return 0, nil, &UnexpectedRecordError{Record: rec}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package tlsrecord implements the TLS record layer shared by the
+// checks: framing and fragmenting outgoing records, and reading incoming
+// records with length enforcement, alert decoding and reassembly of
+// handshake messages that are split across or coalesced within records.
+package tlsrecord
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Record content types.
+const (
+	TypeChangeCipherSpec uint8 = 20
+	TypeAlert            uint8 = 21
+	TypeHandshake        uint8 = 22
+	TypeApplicationData  uint8 = 23
+	TypeHeartbeat        uint8 = 24
+)
+
+// Protocol versions as carried in record and handshake headers.
+const (
+	VersionSSL30 uint16 = 0x0300
+	VersionTLS10 uint16 = 0x0301
+	VersionTLS11 uint16 = 0x0302
+	VersionTLS12 uint16 = 0x0303
+)
+
+// Handshake message types.
+const (
+	HandshakeHelloRequest       uint8 = 0
+	HandshakeClientHello        uint8 = 1
+	HandshakeServerHello        uint8 = 2
+	HandshakeNewSessionTicket   uint8 = 4
+	HandshakeCertificate        uint8 = 11
+	HandshakeServerKeyExchange  uint8 = 12
+	HandshakeCertificateRequest uint8 = 13
+	HandshakeServerHelloDone    uint8 = 14
+	HandshakeClientKeyExchange  uint8 = 16
+	HandshakeFinished           uint8 = 20
+)
+
+// Size limits from RFC 5246 section 6.2.
+const (
+	// HeaderLen is the size of a record header.
+	HeaderLen = 5
+	// MaxPlaintext is the largest fragment a record may carry unprotected.
+	MaxPlaintext = 1 << 14
+	// MaxCiphertext is the largest payload a protected record may carry.
+	MaxCiphertext = MaxPlaintext + 2048
+	// HandshakeHeaderLen is the size of a handshake message header.
+	HandshakeHeaderLen = 4
+	// MaxHandshake bounds reassembled handshake messages. It comfortably
+	// fits long certificate chains.
+	MaxHandshake = 1 << 18
+)
+
+var (
+	// ErrRecordOverflow is returned for records longer than the reader's limit.
+	ErrRecordOverflow = errors.New("tlsrecord: record exceeds maximum length")
+	// ErrNotTLS is returned when the peer does not speak TLS.
+	ErrNotTLS = errors.New("tlsrecord: not a TLS record")
+	// ErrHandshakeOverflow is returned for handshake messages longer than MaxHandshake.
+	ErrHandshakeOverflow = errors.New("tlsrecord: handshake message exceeds maximum length")
+)
+
+// Record is a single TLS record.
+type Record struct {
+	Type    uint8
+	Version uint16
+	Payload []byte
+}
+
+// UnexpectedRecordError is returned when a record of the wrong content
+// type arrives while a handshake message is expected.
+type UnexpectedRecordError struct {
+	Record *Record
+}
+
+func (e *UnexpectedRecordError) Error() string {
+	return fmt.Sprintf("tlsrecord: unexpected record type %d", e.Record.Type)
+}
+
+// Marshal fragments payload into records of at most MaxPlaintext bytes
+// and returns their wire encoding. An empty payload yields one empty
+// record.
+func Marshal(typ uint8, version uint16, payload []byte) []byte {
+	out := make([]byte, 0, len(payload)+HeaderLen*(len(payload)/MaxPlaintext+1))
+
+	for {
+		n := min(len(payload), MaxPlaintext)
+
+		out = append(out, typ, byte(version>>8), byte(version), byte(n>>8), byte(n)) // #nosec G115 -- n <= MaxPlaintext
+		out = append(out, payload[:n]...)
+		payload = payload[n:]
+
+		if len(payload) == 0 {
+			return out
+		}
+	}
+}
+
+// HandshakeMessage frames body as a handshake message of type msgType.
+func HandshakeMessage(msgType uint8, body []byte) []byte {
+	if len(body) > 0x00ffffff {
+		panic("tlsrecord: handshake message exceeds 24-bit length")
+	}
+
+	n := len(body)
+	out := make([]byte, 0, HandshakeHeaderLen+n)
+	out = append(out, msgType, byte(n>>16), byte(n>>8), byte(n)) // #nosec G115 -- checked above
+
+	return append(out, body...)
+}
+
+// VersionName returns the conventional name of a protocol version, such
+// as "TLSv1.2", or its hex value for unknown versions.
+func VersionName(version uint16) string {
+	switch version {
+	case VersionSSL30:
+		return "SSLv3"
+	case VersionTLS10:
+		return "TLSv1.0"
+	case VersionTLS11:
+		return "TLSv1.1"
+	case VersionTLS12:
+		return "TLSv1.2"
+	default:
+		return fmt.Sprintf("0x%04x", version)
+	}
+}
+
+// Uint16Length converts a length to the 16-bit field used by TLS
+// vectors, panicking if it does not fit.
+func Uint16Length(n int) uint16 {
+	if n < 0 || n > math.MaxUint16 {
+		panic("tlsrecord: length exceeds uint16")
+	}
+
+	return uint16(n) // #nosec G115 -- bounded above
+}
@@ -0,0 +1,256 @@
+package tlsrecord
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestMarshalFragments(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xaa}, MaxPlaintext+10)
+
+	r := NewReader(bytes.NewReader(Marshal(TypeApplicationData, VersionTLS12, payload)))
+
+	first, err := r.ReadRecord()
+	if err != nil {
+		t.Fatalf("ReadRecord failed: %v", err)
+	}
+
+	second, err := r.ReadRecord()
+	if err != nil {
+		t.Fatalf("ReadRecord failed: %v", err)
+	}
+
+	if len(first.Payload) != MaxPlaintext || len(second.Payload) != 10 {
+		t.Errorf("wrong fragment sizes: %d, %d", len(first.Payload), len(second.Payload))
+	}
+
+	if second.Type != TypeApplicationData || second.Version != VersionTLS12 {
+		t.Errorf("wrong record header: %d/%x", second.Type, second.Version)
+	}
+
+	if len(Marshal(TypeChangeCipherSpec, VersionTLS10, nil)) != HeaderLen {
+		t.Errorf("empty payload should produce a single empty record")
+	}
+}
+
+func TestReadHandshakeCoalesced(t *testing.T) {
+	var payload []byte
+	payload = append(payload, HandshakeMessage(HandshakeServerHello, []byte{1, 2, 3})...)
+	payload = append(payload, HandshakeMessage(HandshakeCertificate, []byte{4})...)
+	payload = append(payload, HandshakeMessage(HandshakeServerHelloDone, nil)...)
+
+	r := NewReader(bytes.NewReader(Marshal(TypeHandshake, VersionTLS12, payload)))
+
+	for _, want := range []uint8{HandshakeServerHello, HandshakeCertificate, HandshakeServerHelloDone} {
+		msgType, _, err := r.ReadHandshake()
+		if err != nil {
+			t.Fatalf("ReadHandshake failed: %v", err)
+		}
+
+		if msgType != want {
+			t.Errorf("wrong message type, got: %d, want: %d", msgType, want)
+		}
+	}
+
+	if r.Buffered() {
+		t.Errorf("no handshake bytes should remain buffered")
+	}
+}
+
+func TestReadHandshakeFragmented(t *testing.T) {
+	body := bytes.Repeat([]byte{0x42}, 3000)
+	msg := HandshakeMessage(HandshakeCertificate, body)
+
+	var stream []byte
+	for len(msg) > 0 {
+		n := min(len(msg), 1000)
+		stream = append(stream, Marshal(TypeHandshake, VersionTLS10, msg[:n])...)
+		msg = msg[n:]
+	}
+
+	r := NewReader(bytes.NewReader(stream))
+
+	msgType, got, err := r.ReadHandshake()
+	if err != nil {
+		t.Fatalf("ReadHandshake failed: %v", err)
+	}
+
+	if msgType != HandshakeCertificate || !bytes.Equal(got, body) {
+		t.Errorf("message not reassembled: type %d, %d bytes", msgType, len(got))
+	}
+}
+
+func TestReadHandshakeAlert(t *testing.T) {
+	stream := Marshal(TypeAlert, VersionTLS12, []byte{AlertLevelFatal, AlertHandshakeFailure})
+
+	_, _, err := NewReader(bytes.NewReader(stream)).ReadHandshake()
+
+	var alert *AlertError
+	if !errors.As(err, &alert) {
+		t.Fatalf("expected AlertError, got: %v", err)
+	}
+
+	if !alert.Fatal() || alert.Name() != "handshake_failure" {
+		t.Errorf("wrong alert decoded: %v", alert)
+	}
+}
+
+func TestRefused(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&AlertError{Level: AlertLevelFatal, Description: AlertHandshakeFailure}, true},
+		{fmt.Errorf("reading ServerHello: %w", io.EOF), true},
+		{io.ErrUnexpectedEOF, true},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{os.ErrDeadlineExceeded, false},
+		{errors.New("malformed ServerHello"), false},
+	}
+
+	for _, tt := range tests {
+		if got := Refused(tt.err); got != tt.want {
+			t.Errorf("Refused(%v), got: %v, want: %v.", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestReadHandshakeUnexpectedRecord(t *testing.T) {
+	stream := Marshal(TypeChangeCipherSpec, VersionTLS12, []byte{1})
+
+	_, _, err := NewReader(bytes.NewReader(stream)).ReadHandshake()
+
+	var unexpected *UnexpectedRecordError
+	if !errors.As(err, &unexpected) || unexpected.Record.Type != TypeChangeCipherSpec {
+		t.Errorf("expected UnexpectedRecordError, got: %v", err)
+	}
+}
+
+func TestReadRecordLimits(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte("HTTP/1.1 400 Bad Request\r\n"))).ReadRecord()
+	if !errors.Is(err, ErrNotTLS) {
+		t.Errorf("expected ErrNotTLS, got: %v", err)
+	}
+
+	hdr := []byte{TypeApplicationData, 0x03, 0x03, 0xff, 0xff}
+
+	_, err = NewReader(bytes.NewReader(hdr)).ReadRecord()
+	if !errors.Is(err, ErrRecordOverflow) {
+		t.Errorf("expected ErrRecordOverflow, got: %v", err)
+	}
+
+	r := NewReader(bytes.NewReader(Marshal(TypeHandshake, VersionTLS12, make([]byte, 100))))
+	r.MaxLength = 50
+
+	_, err = r.ReadRecord()
+	if !errors.Is(err, ErrRecordOverflow) {
+		t.Errorf("expected ErrRecordOverflow with custom limit, got: %v", err)
+	}
+
+	truncated := Marshal(TypeHandshake, VersionTLS12, make([]byte, 100))[:50]
+
+	_, err = NewReader(bytes.NewReader(truncated)).ReadRecord()
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got: %v", err)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	w := NewWriter(&buf, VersionTLS10)
+
+	err := w.WriteHandshake(HandshakeClientHello, []byte{0x03, 0x03})
+	if err != nil {
+		t.Fatalf("WriteHandshake failed: %v", err)
+	}
+
+	want := []byte{TypeHandshake, 0x03, 0x01, 0x00, 0x06, HandshakeClientHello, 0x00, 0x00, 0x02, 0x03, 0x03}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("wrong encoding:\n got: %x\nwant: %x", buf.Bytes(), want)
+	}
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 16)
+	iv := bytes.Repeat([]byte{0x24}, 16)
+	macKey := bytes.Repeat([]byte{0x17}, 20)
+
+	pair := func(t *testing.T, name string) (Cipher, Cipher) {
+		t.Helper()
+
+		if name == "GCM" {
+			seal, _ := NewGCM(key, iv[:4])
+			open, _ := NewGCM(key, iv[:4])
+
+			return seal, open
+		}
+
+		block, _ := aes.NewCipher(key)
+		explicit := name == "CBCExplicitIV"
+
+		return NewCBC(block, sha1.New, macKey, iv, explicit), NewCBC(block, sha1.New, macKey, iv, explicit)
+	}
+
+	for _, name := range []string{"CBCChainedIV", "CBCExplicitIV", "GCM"} {
+		t.Run(name, func(t *testing.T) {
+			seal, open := pair(t, name)
+
+			var buf bytes.Buffer
+
+			w := NewWriter(&buf, VersionTLS12)
+			w.SetCipher(seal)
+
+			r := NewReader(&buf)
+			r.SetCipher(open)
+
+			// several records exercise sequence numbers and IV chaining
+			for _, msg := range []string{"", "hello", string(bytes.Repeat([]byte{'x'}, MaxPlaintext+1))} {
+				err := w.WriteRecord(TypeApplicationData, []byte(msg))
+				if err != nil {
+					t.Fatalf("WriteRecord failed: %v", err)
+				}
+
+				var got []byte
+
+				for len(got) < len(msg) || len(msg) == 0 {
+					rec, err := r.ReadRecord()
+					if err != nil {
+						t.Fatalf("ReadRecord failed: %v", err)
+					}
+
+					got = append(got, rec.Payload...)
+
+					if len(msg) == 0 {
+						break
+					}
+				}
+
+				if string(got) != msg {
+					t.Fatalf("wrong plaintext: %d bytes, want %d", len(got), len(msg))
+				}
+			}
+
+			err := w.WriteRecord(TypeApplicationData, []byte("tampered"))
+			if err != nil {
+				t.Fatalf("WriteRecord failed: %v", err)
+			}
+
+			raw := buf.Bytes()
+			raw[len(raw)-1] ^= 0x01
+
+			_, err = r.ReadRecord()
+			if !errors.Is(err, ErrBadRecordMAC) {
+				t.Fatalf("expected ErrBadRecordMAC, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package tlsrecord
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Writer writes TLS records to an underlying stream.
+type Writer struct {
+	w       io.Writer
+	version uint16
+	// cipher seals records once ChangeCipherSpec has been sent.
+	cipher Cipher
+}
+
+// NewWriter returns a Writer that stamps records with version.
+func NewWriter(w io.Writer, version uint16) *Writer {
+	return &Writer{w: w, version: version}
+}
+
+// SetVersion changes the version used for subsequent records.
+func (w *Writer) SetVersion(version uint16) {
+	w.version = version
+}
+
+// SetCipher protects the records written from now on with c.
+func (w *Writer) SetCipher(c Cipher) {
+	w.cipher = c
+}
+
+// WriteRecord writes payload as one or more records of type typ,
+// fragmenting it at MaxPlaintext bytes.
+func (w *Writer) WriteRecord(typ uint8, payload []byte) error {
+	if w.cipher == nil {
+		_, err := w.w.Write(Marshal(typ, w.version, payload))
+
+		return err
+	}
+
+	var out []byte
+
+	for {
+		n := min(len(payload), MaxPlaintext)
+
+		sealed, err := w.cipher.Seal(typ, w.version, payload[:n])
+		if err != nil {
+			return err
+		}
+
+		out = append(out, typ, byte(w.version>>8), byte(w.version))
+		out = binary.BigEndian.AppendUint16(out, Uint16Length(len(sealed)))
+		out = append(out, sealed...)
+		payload = payload[n:]
+
+		if len(payload) == 0 {
+			break
+		}
+	}
+
+	_, err := w.w.Write(out)
+
+	return err
+}
+
+// WriteHandshake writes a handshake message of type msgType.
+func (w *Writer) WriteHandshake(msgType uint8, body []byte) error {
+	return w.WriteRecord(TypeHandshake, HandshakeMessage(msgType, body))
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+)
+
+var _ checker.Dialer = (*HTTPConnect)(nil)
+
+// HTTPConnect dials targets through an HTTP proxy using the CONNECT
+// method.
+type HTTPConnect struct {
+	// Address is the host:port of the proxy.
+	Address string
+	// Username and Password are sent as Basic Proxy-Authorization when
+	// Username is set.
+	Username string
+	Password string
+	// Header holds extra headers sent with the CONNECT request.
+	Header http.Header
+	// Forward connects to the proxy. Nil dials directly.
+	Forward checker.Dialer
+}
+
+// DialContext connects to address through the proxy.
+func (h *HTTPConnect) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	_, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dialProxy(ctx, h.Forward, network, h.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	var br *bufio.Reader
+
+	err = negotiate(ctx, conn, func() error {
+		var err error
+
+		br, err = h.connect(conn, address)
+
+		return err
+	})
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	if br.Buffered() > 0 {
+		// the proxy already relayed bytes from the target
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+
+	return conn, nil
+}
+
+func (h *HTTPConnect) connect(conn net.Conn, address string) (*bufio.Reader, error) {
+	req := "CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n"
+
+	if h.Username != "" {
+		creds := base64.StdEncoding.EncodeToString([]byte(h.Username + ":" + h.Password))
+		req += "Proxy-Authorization: Basic " + creds + "\r\n"
+	}
+
+	for key, values := range h.Header {
+		for _, v := range values {
+			req += key + ": " + v + "\r\n"
+		}
+	}
+
+	req += "\r\n"
+
+	_, err := conn.Write([]byte(req))
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy: CONNECT %s failed: %s", address, resp.Status)
+	}
+
+	return br, nil
+}
+
+// bufferedConn serves reads from r before falling back to the Conn.
+type bufferedConn struct {
+	net.Conn
+
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
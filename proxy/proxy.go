@@ -0,0 +1,91 @@
+// Package proxy provides dialers that reach targets through SOCKS5 and
+// HTTP CONNECT proxies. They satisfy checker.Dialer and can be set on
+// any network check.
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/internal/netutil"
+)
+
+// FromURL returns a dialer for the proxy described by u. Supported
+// schemes are socks5, socks5h and http. Credentials are taken from the
+// URL's user info. A nil forward dialer connects to the proxy directly.
+func FromURL(u *url.URL, forward checker.Dialer) (checker.Dialer, error) {
+	var username, password string
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return &SOCKS5{
+			Address:  withDefaultPort(u, "1080"),
+			Username: username,
+			Password: password,
+			Forward:  forward,
+		}, nil
+	case "http":
+		return &HTTPConnect{
+			Address:  withDefaultPort(u, "80"),
+			Username: username,
+			Password: password,
+			Forward:  forward,
+		}, nil
+	default:
+		return nil, fmt.Errorf("proxy: unsupported scheme %q", u.Scheme)
+	}
+}
+
+func withDefaultPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// dialProxy connects to the proxy itself through forward.
+func dialProxy(ctx context.Context, forward checker.Dialer, network, address string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("proxy: unsupported network %q", network)
+	}
+
+	if forward == nil {
+		forward = &net.Dialer{}
+	}
+
+	return forward.DialContext(ctx, "tcp", address)
+}
+
+// negotiate runs fn on conn with its deadline bound to ctx, so that an
+// unresponsive proxy cannot stall the caller past its context.
+func negotiate(ctx context.Context, conn net.Conn, fn func() error) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		err := conn.SetDeadline(deadline)
+		if err != nil {
+			return err
+		}
+	}
+
+	stop := netutil.AbortOnDone(ctx, conn)
+
+	err := fn()
+	if !stop() {
+		// ctx finished while negotiating
+		return ctx.Err()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return conn.SetDeadline(time.Time{})
+}
@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+)
+
+// SOCKS5 protocol constants (RFC 1928 and RFC 1929).
+const (
+	socks5Version        = 0x05
+	socks5AuthVersion    = 0x01
+	socks5AuthNone       = 0x00
+	socks5AuthPassword   = 0x02
+	socks5AuthNoAccepted = 0xff
+	socks5CmdConnect     = 0x01
+	socks5AddrIPv4       = 0x01
+	socks5AddrDomain     = 0x03
+	socks5AddrIPv6       = 0x04
+	socks5ReplySucceeded = 0x00
+)
+
+var socks5Replies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// ErrSOCKS5Auth is returned when the SOCKS5 proxy rejects the offered
+// authentication.
+var ErrSOCKS5Auth = errors.New("proxy: socks5 authentication failed")
+
+var _ checker.Dialer = (*SOCKS5)(nil)
+
+// SOCKS5 dials targets through a SOCKS5 proxy. Host names are resolved
+// by the proxy.
+type SOCKS5 struct {
+	// Address is the host:port of the proxy.
+	Address string
+	// Username and Password enable username/password authentication
+	// when Username is set.
+	Username string
+	Password string
+	// Forward connects to the proxy. Nil dials directly.
+	Forward checker.Dialer
+}
+
+// DialContext connects to address through the proxy.
+func (s *SOCKS5) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: invalid port %q", portStr)
+	}
+
+	conn, err := dialProxy(ctx, s.Forward, network, s.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	err = negotiate(ctx, conn, func() error {
+		err := s.authenticate(conn)
+		if err != nil {
+			return err
+		}
+
+		return s.connect(conn, host, uint16(port)) // #nosec G115 -- parsed with a 16-bit limit
+	})
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (s *SOCKS5) authenticate(conn net.Conn) error {
+	method := byte(socks5AuthNone)
+	if s.Username != "" {
+		method = socks5AuthPassword
+	}
+
+	_, err := conn.Write([]byte{socks5Version, 0x01, method})
+	if err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return err
+	}
+
+	if reply[0] != socks5Version {
+		return fmt.Errorf("proxy: unexpected socks version %d", reply[0])
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+		return nil
+	case socks5AuthPassword:
+		if method != socks5AuthPassword {
+			return ErrSOCKS5Auth
+		}
+
+		return s.sendPassword(conn)
+	case socks5AuthNoAccepted:
+		return ErrSOCKS5Auth
+	default:
+		return fmt.Errorf("proxy: unsupported socks5 auth method %d", reply[1])
+	}
+}
+
+func (s *SOCKS5) sendPassword(conn net.Conn) error {
+	if len(s.Username) > 255 || len(s.Password) > 255 {
+		return errors.New("proxy: socks5 username or password too long")
+	}
+
+	req := []byte{socks5AuthVersion, byte(len(s.Username))} // #nosec G115 -- checked above
+	req = append(req, s.Username...)
+	req = append(req, byte(len(s.Password))) // #nosec G115 -- checked above
+	req = append(req, s.Password...)
+
+	_, err := conn.Write(req)
+	if err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return err
+	}
+
+	if reply[0] != socks5AuthVersion {
+		return fmt.Errorf("proxy: unexpected socks5 auth version %d", reply[0])
+	}
+
+	if reply[1] != 0x00 {
+		return ErrSOCKS5Auth
+	}
+
+	return nil
+}
+
+func (s *SOCKS5) connect(conn net.Conn, host string, port uint16) error {
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+
+	ip := net.ParseIP(host)
+
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return fmt.Errorf("proxy: host name too long: %s", host)
+		}
+
+		req = append(req, socks5AddrDomain, byte(len(host))) // #nosec G115 -- checked above
+		req = append(req, host...)
+	case ip.To4() != nil:
+		req = append(req, socks5AddrIPv4)
+		req = append(req, ip.To4()...)
+	default:
+		req = append(req, socks5AddrIPv6)
+		req = append(req, ip.To16()...)
+	}
+
+	req = binary.BigEndian.AppendUint16(req, port)
+
+	_, err := conn.Write(req)
+	if err != nil {
+		return err
+	}
+
+	// VER REP RSV ATYP
+	reply := make([]byte, 4)
+
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return err
+	}
+
+	if reply[1] != socks5ReplySucceeded {
+		msg, ok := socks5Replies[reply[1]]
+		if !ok {
+			msg = fmt.Sprintf("unknown reply %d", reply[1])
+		}
+
+		return fmt.Errorf("proxy: socks5 connect to %s failed: %s", host, msg)
+	}
+
+	return discardBoundAddress(conn, reply[3])
+}
+
+// discardBoundAddress skips BND.ADDR and BND.PORT of a SOCKS5 reply.
+func discardBoundAddress(conn net.Conn, addrType byte) error {
+	var n int
+
+	switch addrType {
+	case socks5AddrIPv4:
+		n = net.IPv4len
+	case socks5AddrIPv6:
+		n = net.IPv6len
+	case socks5AddrDomain:
+		l := make([]byte, 1)
+
+		_, err := io.ReadFull(conn, l)
+		if err != nil {
+			return err
+		}
+
+		n = int(l[0])
+	default:
+		return fmt.Errorf("proxy: unknown socks5 address type %d", addrType)
+	}
+
+	_, err := io.CopyN(io.Discard, conn, int64(n+2))
+
+	return err
+}
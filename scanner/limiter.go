@@ -0,0 +1,58 @@
+package scanner
+
+import (
+	"context"
+	"time"
+)
+
+// limiter spaces out events so that no more than rate of them start per
+// second. It is owned by the dispatcher and not safe for concurrent use.
+// A nil limiter never holds an event back.
+type limiter struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &limiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// ready returns when the next event may start.
+func (l *limiter) ready() time.Time {
+	if l == nil {
+		return time.Time{}
+	}
+
+	return l.next
+}
+
+// started records that an event started at t.
+func (l *limiter) started(t time.Time) {
+	if l == nil {
+		return
+	}
+
+	l.next = t.Add(l.interval)
+}
+
+// waitUntil blocks until t or until ctx is done.
+func waitUntil(ctx context.Context, t time.Time) error {
+	delay := time.Until(t)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,213 @@
+// Package scanner runs vulnerability checks against many targets at
+// once. It bounds the number of checks in flight, limits how fast
+// checks start both overall and per host, and streams each result as
+// soon as it is available.
+package scanner
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+)
+
+// DefaultWorkers is the number of concurrent checks used when
+// Scanner.Workers is zero.
+const DefaultWorkers = 10
+
+// Scanner runs every check against every target.
+type Scanner struct {
+	// Checks are run against each target.
+	Checks []checker.Checker
+	// Workers bounds how many checks run at the same time. Zero uses
+	// DefaultWorkers.
+	Workers int
+	// Rate is the maximum number of checks started per second across
+	// all targets. Zero disables the limit.
+	Rate float64
+	// HostRate is the maximum number of checks started per second
+	// against a single host. Zero disables the limit.
+	HostRate float64
+	// Timeout bounds each individual check. Zero leaves checks bounded
+	// by ctx only.
+	Timeout time.Duration
+}
+
+// job is one check to run against one target.
+type job struct {
+	target checker.Target
+	check  checker.Checker
+	// seq is the position of the job in the order checks and targets
+	// were given.
+	seq int
+}
+
+// hostQueue holds the jobs of one host not yet handed to a worker.
+type hostQueue struct {
+	jobs  []job
+	limit *limiter
+}
+
+// Scan starts running the checks against targets and returns a channel
+// that yields one result per check and target as they finish. The
+// channel is closed once all work is done. When ctx is cancelled no new
+// checks are started; checks already running are cancelled and their
+// results still delivered, and the checks never started report
+// StatusError with the context's error. Callers must drain the channel.
+func (s *Scanner) Scan(ctx context.Context, targets []checker.Target) <-chan checker.Result {
+	workers := s.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	results := make(chan checker.Result, workers)
+	jobs := make(chan job)
+
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := range jobs {
+				// the dispatcher may win the race with ctx.Done
+				if ctx.Err() != nil {
+					results <- cancelled(j, ctx.Err())
+					continue
+				}
+
+				results <- s.run(ctx, j)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(results)
+		defer wg.Wait()
+		defer close(jobs)
+
+		s.dispatch(ctx, targets, jobs, results)
+	}()
+
+	return results
+}
+
+// dispatch hands jobs to the workers while honouring the rate limits.
+// A check counts as started once a worker has taken it, so the limits
+// hold however long the workers stay busy. The next job goes to the
+// host that may start one soonest, so a host held back by HostRate does
+// not delay the others; among hosts that are all ready, jobs keep the
+// order in which the checks and targets were given. Jobs left when ctx
+// is done are reported as cancelled.
+func (s *Scanner) dispatch(ctx context.Context, targets []checker.Target, jobs chan<- job,
+	results chan<- checker.Result,
+) {
+	global := newLimiter(s.Rate)
+	queues := s.queues(targets)
+
+	for len(queues) > 0 {
+		next := nextHost(queues, time.Now())
+		q := queues[next]
+
+		err := waitUntil(ctx, latest(q.limit.ready(), global.ready()))
+		if err == nil {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case jobs <- q.jobs[0]:
+			}
+		}
+
+		if err != nil {
+			for _, q := range queues {
+				for _, j := range q.jobs {
+					results <- cancelled(j, err)
+				}
+			}
+
+			return
+		}
+
+		now := time.Now()
+		q.limit.started(now)
+		global.started(now)
+
+		q.jobs = q.jobs[1:]
+		if len(q.jobs) == 0 {
+			queues = slices.Delete(queues, next, next+1)
+		}
+	}
+}
+
+// queues groups the jobs by host, each host ordered check by check.
+func (s *Scanner) queues(targets []checker.Target) []*hostQueue {
+	var (
+		queues []*hostQueue
+		seq    int
+	)
+
+	byHost := make(map[string]*hostQueue)
+
+	for _, c := range s.Checks {
+		for _, t := range targets {
+			q, ok := byHost[t.Host]
+			if !ok {
+				q = &hostQueue{limit: newLimiter(s.HostRate)}
+				byHost[t.Host] = q
+				queues = append(queues, q)
+			}
+
+			q.jobs = append(q.jobs, job{target: t, check: c, seq: seq})
+			seq++
+		}
+	}
+
+	return queues
+}
+
+func (s *Scanner) run(ctx context.Context, j job) checker.Result {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	return j.check.Check(ctx, j.target)
+}
+
+// cancelled returns the result of a job dropped because ctx is done.
+func cancelled(j job, err error) checker.Result {
+	res := checker.NewResult(j.check.Name(), j.target)
+	res.Finish(checker.StatusError, err)
+
+	return res
+}
+
+// nextHost returns the index of the queue whose next job may start
+// soonest after now, the one given first on a tie.
+func nextHost(queues []*hostQueue, now time.Time) int {
+	next := 0
+
+	for i, q := range queues {
+		at, nextAt := latest(now, q.limit.ready()), latest(now, queues[next].limit.ready())
+		if at.Before(nextAt) || at.Equal(nextAt) && q.jobs[0].seq < queues[next].jobs[0].seq {
+			next = i
+		}
+	}
+
+	return next
+}
+
+// latest returns the later of a and b.
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+
+	return a
+}
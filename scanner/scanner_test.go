@@ -0,0 +1,264 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/vulnerabilities/ccs"
+	"github.com/jsandas/tls-vuln-checker/vulnerabilities/heartbleed"
+)
+
+// slowChecker sleeps for delay, or until gate is closed if set, and
+// tracks how many checks overlap.
+type slowChecker struct {
+	delay   time.Duration
+	gate    chan struct{}
+	running atomic.Int32
+	peak    atomic.Int32
+
+	mu     sync.Mutex
+	starts []time.Time
+	hosts  []string
+}
+
+func (s *slowChecker) Name() string {
+	return "slow"
+}
+
+func (s *slowChecker) Check(ctx context.Context, target checker.Target) checker.Result {
+	res := checker.NewResult(s.Name(), target)
+
+	s.mu.Lock()
+	s.starts = append(s.starts, time.Now())
+	s.hosts = append(s.hosts, target.Host)
+	s.mu.Unlock()
+
+	n := s.running.Add(1)
+	for {
+		peak := s.peak.Load()
+		if n <= peak || s.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+
+	defer s.running.Add(-1)
+
+	// a nil channel never fires, leaving only the gate
+	delay := time.After(s.delay)
+	if s.gate != nil {
+		delay = nil
+	}
+
+	select {
+	case <-ctx.Done():
+		res.Finish(checker.StatusError, ctx.Err())
+	case <-delay:
+		res.Finish(checker.StatusNotVulnerable, nil)
+	case <-s.gate:
+		res.Finish(checker.StatusNotVulnerable, nil)
+	}
+
+	return res
+}
+
+func targets(n int, hosts int) []checker.Target {
+	ts := make([]checker.Target, n)
+	for i := range ts {
+		ts[i] = checker.Target{Host: fmt.Sprintf("10.0.0.%d", i%hosts), Port: fmt.Sprintf("%d", 1000+i)}
+	}
+
+	return ts
+}
+
+func collect(ch <-chan checker.Result) []checker.Result {
+	var results []checker.Result
+	for res := range ch {
+		results = append(results, res)
+	}
+
+	return results
+}
+
+func TestScanWorkerPool(t *testing.T) {
+	c := &slowChecker{delay: 20 * time.Millisecond}
+	s := &Scanner{Checks: []checker.Checker{c}, Workers: 3}
+
+	results := collect(s.Scan(context.Background(), targets(12, 12)))
+
+	if len(results) != 12 {
+		t.Fatalf("expected 12 results, got %d", len(results))
+	}
+
+	if peak := c.peak.Load(); peak > 3 || peak < 2 {
+		t.Errorf("expected at most 3 concurrent checks, peak was %d", peak)
+	}
+}
+
+func TestScanRateLimit(t *testing.T) {
+	c := &slowChecker{}
+	s := &Scanner{Checks: []checker.Checker{c}, Workers: 10, Rate: 50}
+
+	start := time.Now()
+	results := collect(s.Scan(context.Background(), targets(6, 6)))
+
+	if len(results) != 6 {
+		t.Fatalf("expected 6 results, got %d", len(results))
+	}
+
+	// 6 checks at 50/s need at least 5 intervals of 20ms
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("global rate limit not applied, took %v", elapsed)
+	}
+}
+
+func TestScanRateLimitBusyWorkers(t *testing.T) {
+	c := &slowChecker{gate: make(chan struct{})}
+	s := &Scanner{Checks: []checker.Checker{c}, Workers: 3, Rate: 20}
+
+	// the three workers all free up at once, long after the slots of
+	// the waiting checks have passed
+	time.AfterFunc(500*time.Millisecond, func() { close(c.gate) })
+
+	collect(s.Scan(context.Background(), targets(6, 6)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.starts) != 6 {
+		t.Fatalf("expected 6 checks, got %d", len(c.starts))
+	}
+
+	starts := slices.SortedFunc(slices.Values(c.starts), time.Time.Compare)
+	for i := 1; i < len(starts); i++ {
+		if gap := starts[i].Sub(starts[i-1]); gap < 40*time.Millisecond {
+			t.Errorf("checks %d and %d started %v apart, want at least 50ms", i-1, i, gap)
+		}
+	}
+}
+
+func TestScanHostRateLimit(t *testing.T) {
+	c := &slowChecker{}
+	s := &Scanner{Checks: []checker.Checker{c}, Workers: 10, HostRate: 20}
+
+	collect(s.Scan(context.Background(), targets(4, 2)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.starts) != 4 {
+		t.Fatalf("expected 4 checks, got %d", len(c.starts))
+	}
+
+	// two checks per host at 20/s: the last start trails the first by
+	// at least one 50ms interval, while the first two hosts start at once
+	if gap := c.starts[3].Sub(c.starts[0]); gap < 40*time.Millisecond {
+		t.Errorf("per-host rate limit not applied, gap %v", gap)
+	}
+
+	if gap := c.starts[1].Sub(c.starts[0]); gap > 40*time.Millisecond {
+		t.Errorf("different hosts should not wait for each other, gap %v", gap)
+	}
+}
+
+func TestScanHostRateLimitIsolated(t *testing.T) {
+	c := &slowChecker{}
+	s := &Scanner{Checks: []checker.Checker{c}, Workers: 10, HostRate: 5}
+
+	// the throttled host comes first, so handing jobs out in order would hold
+	// the other one back for three 200ms intervals
+	ts := []checker.Target{
+		{Host: "10.0.0.1", Port: "1001"},
+		{Host: "10.0.0.1", Port: "1002"},
+		{Host: "10.0.0.1", Port: "1003"},
+		{Host: "10.0.0.1", Port: "1004"},
+		{Host: "10.0.0.2", Port: "1005"},
+	}
+
+	start := time.Now()
+	collect(s.Scan(context.Background(), ts))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.starts) != 5 {
+		t.Fatalf("expected 5 checks, got %d", len(c.starts))
+	}
+
+	for i, host := range c.hosts {
+		if host == "10.0.0.2" {
+			if gap := c.starts[i].Sub(start); gap > 100*time.Millisecond {
+				t.Errorf("unthrottled host waited for the throttled one, gap %v", gap)
+			}
+		}
+	}
+
+	if gap := c.starts[len(c.starts)-1].Sub(start); gap < 500*time.Millisecond {
+		t.Errorf("per-host rate limit not applied, last start after %v", gap)
+	}
+}
+
+func TestScanCancel(t *testing.T) {
+	c := &slowChecker{delay: time.Minute}
+	s := &Scanner{Checks: []checker.Checker{c}, Workers: 2}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	results := collect(s.Scan(ctx, targets(10, 10)))
+
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("scan did not stop after cancel")
+	}
+
+	if len(results) != 10 {
+		t.Errorf("expected every check to report, got %d results", len(results))
+	}
+
+	if started := len(c.starts); started != 2 {
+		t.Errorf("expected only the 2 in-flight checks to start, got %d", started)
+	}
+
+	for _, res := range results {
+		if res.Status != checker.StatusError || res.Err == nil {
+			t.Errorf("expected cancelled check to report an error, got %s/%v", res.Status, res.Err)
+		}
+	}
+}
+
+func TestScanNetworkChecks(t *testing.T) {
+	s := &Scanner{
+		Checks:  []checker.Checker{&heartbleed.Checker{}, &ccs.Checker{}},
+		Timeout: 5 * time.Second,
+	}
+
+	// port 1 is assumed closed so both checks fail fast
+	results := collect(s.Scan(context.Background(), []checker.Target{
+		{Host: "127.0.0.1", Port: "1"},
+		{Host: "127.0.0.1", Port: "2"},
+	}))
+
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+
+	seen := make(map[string]int)
+
+	for _, res := range results {
+		seen[res.Check]++
+
+		if res.Status != checker.StatusError {
+			t.Errorf("%s %s: expected error, got %s", res.Check, res.Target, res.Status)
+		}
+	}
+
+	if seen[heartbleed.Name] != 2 || seen[ccs.Name] != 2 {
+		t.Errorf("unexpected checks in results: %v", seen)
+	}
+}
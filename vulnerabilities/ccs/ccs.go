@@ -3,11 +3,21 @@ package ccs
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
-	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"os"
+	"slices"
+	"syscall"
 	"time"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/internal/handshake"
+	"github.com/jsandas/tls-vuln-checker/internal/netutil"
+	"github.com/jsandas/tls-vuln-checker/internal/starttls"
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
 )
 
 /*
@@ -19,238 +29,454 @@ consequently hijack sessions or obtain sensitive information, via
 a crafted TLS handshake, aka the "CCS Injection" vulnerability.
 */
 
-const (
-	notVulnerable = "no"
-	vulnerable    = "yes"
-	testFailed    = "error"
-)
-
-type CCSInjection struct {
-	Vulnerable string `json:"vulnerable"`
-}
+// Response describes how the server reacted to the injected CCS.
+type Response string
 
-// TLS record types.
+// Responses to the early CCS and to the probe that follows it.
 const (
-	recordTypeChangeCipherSpec = 20
-	recordTypeAlert            = 21
-	recordTypeHandshake        = 22
+	// ResponseRejected is a reply, usually an unexpected_message alert,
+	// to the early CCS itself: the server refused it.
+	ResponseRejected Response = "rejected"
+	// ResponseBadRecordMAC is a bad_record_mac alert to the probe, as
+	// sent by vulnerable OpenSSL 1.0.1.
+	ResponseBadRecordMAC Response = "bad_record_mac"
+	// ResponseDecryptionFailed is a decryption_failed alert to the probe,
+	// as sent by vulnerable OpenSSL 0.9.8 and 1.0.0.
+	ResponseDecryptionFailed Response = "decryption_failed"
+	// ResponseZeroKeyAccepted is an unexpected_message alert to the
+	// zero-key Finished: the server decrypted it with the empty master
+	// secret, confirming the session keys are known.
+	ResponseZeroKeyAccepted Response = "zero_key_accepted"
+	// ResponseAnswered is a record other than an alert after the probe.
+	ResponseAnswered Response = "answered"
+	// ResponseAlert is any other alert to the probe.
+	ResponseAlert Response = "alert"
+	// ResponseSilent means nothing came back before the read timeout.
+	ResponseSilent Response = "no_response"
+	// ResponseClosed means the server closed the connection without an
+	// alert.
+	ResponseClosed Response = "silent_close"
+	// ResponseReset means the server reset the connection.
+	ResponseReset Response = "connection_reset"
 )
 
-// TLS handshake message types.
-const (
-	handshakeTypeClientHello     = 1
-	handshakeTypeServerHelloDone = 14
-)
+// Probe is the record sent after an early CCS the server did not refuse.
+type Probe string
 
-// TLS alert levels.
+// Probes.
 const (
-	alertLevelFatal = 2
+	// ProbeZeroKeyFinished is a Finished message encrypted under the keys
+	// derived from an empty master secret.
+	ProbeZeroKeyFinished Probe = "zero_key_finished"
+	// ProbeCCS is a second CCS, sent when the negotiated suite leaves the
+	// zero keys unknown.
+	ProbeCCS Probe = "ccs"
 )
 
-// TLS alert descriptions.
-const (
-	alertUnexpectedMessage = 10
-)
+type CCSInjection struct {
+	Vulnerable checker.Status `json:"vulnerable"`
+	// StartTLS is the protocol negotiated before TLS.
+	StartTLS checker.Protocol `json:"starttls"`
+	// Response is how the server reacted to the early CCS and the probe
+	// sent after it.
+	Response Response `json:"response,omitempty"`
+	// Probe is what was sent after a CCS the server took in silence.
+	Probe Probe `json:"probe,omitempty"`
+	// AlertRecord is the alert record the verdict rests on, in hex.
+	AlertRecord string `json:"alert_record,omitempty"`
+	// Implementation is the OpenSSL release line the alert points to.
+	Implementation string `json:"implementation,omitempty"`
+	// Version is the protocol version, CipherList the name of the list
+	// of suites offered and CipherSuite the suite negotiated by the
+	// ClientHello that produced the verdict.
+	Version     string `json:"version,omitempty"`
+	CipherList  string `json:"cipher_list,omitempty"`
+	CipherSuite string `json:"cipher_suite,omitempty"`
+	// Client is the address of the client tested by CheckClient.
+	Client string `json:"client,omitempty"`
+	// Alert names the alert the client rejected the CCS with.
+	Alert string `json:"alert,omitempty"`
+
+	// Timeouts overrides the per-phase defaults used by CheckContext.
+	Timeouts checker.Timeouts `json:"-"`
+	// Dialer opens the connection to the target. Nil dials directly.
+	Dialer checker.Dialer `json:"-"`
+	// ServerName is sent in the server_name extension. Empty uses the
+	// host unless it is an IP address.
+	ServerName string `json:"-"`
+	// Protocol is negotiated with STARTTLS before the ClientHello.
+	// Empty infers it from the port.
+	Protocol checker.Protocol `json:"-"`
+}
 
-type tlsRecordHeader struct {
-	Type    uint8
-	Version uint16
-	Length  uint16
+// defaultTimeouts apply to any phase not set in CCSInjection.Timeouts.
+// Read is how long to wait for the server to react to an injected CCS.
+var defaultTimeouts = checker.Timeouts{
+	Dial:      5 * time.Second,
+	StartTLS:  5 * time.Second,
+	Handshake: 5 * time.Second,
+	Write:     5 * time.Second,
+	Read:      1 * time.Second,
 }
 
 // Check for CCS Injection vulnerability (CVE-2014-0224).
 func (ccs *CCSInjection) Check(host string, port string) error {
-	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	return ccs.CheckContext(context.Background(), host, port)
+}
 
-	target := net.JoinHostPort(host, port)
+// CheckContext checks for CCS Injection. Every phase is bounded by its
+// entry in Timeouts and aborted as soon as ctx is done.
+//
+// The ClientHello offers each version of helloVersions in turn, each
+// with every list of cipherLists, over a new connection until the
+// server answers one; Version and CipherList report which did.
+func (ccs *CCSInjection) CheckContext(ctx context.Context, host string, port string) error {
+	var err error
+
+	for _, version := range helloVersions {
+		for _, list := range cipherLists {
+			var refused bool
+
+			refused, err = ccs.attempt(ctx, host, port, version, list)
+			if !refused {
+				if err != nil {
+					ccs.Vulnerable = checker.StatusError
+				}
+
+				return err
+			}
+		}
+	}
 
-	dialer := &net.Dialer{}
+	ccs.Vulnerable = checker.StatusError
 
-	conn, err := dialer.DialContext(ctx, "tcp", target)
-	if err != nil {
-		ccs.Vulnerable = testFailed
+	return fmt.Errorf("%w: %w", ErrNoHandshake, err)
+}
 
-		return err
+// attempt runs the check with a ClientHello offering version and the
+// suites of list. refused reports that the server turned the hello down,
+// leaving other combinations to try.
+func (ccs *CCSInjection) attempt(ctx context.Context, host, port string, version uint16,
+	list cipherList,
+) (refused bool, err error) {
+	timeouts := ccs.Timeouts.WithDefaults(defaultTimeouts)
+
+	conn, err := netutil.Dial(ctx, ccs.Dialer, "tcp", net.JoinHostPort(host, port), timeouts.Dial)
+	if err != nil {
+		return false, err
 	}
 
 	defer conn.Close()
 
-	clientHello := buildClientHello()
+	stop := netutil.AbortOnDone(ctx, conn)
+	defer stop()
+
+	serverName := ccs.ServerName
+	if serverName == "" {
+		serverName = checker.DefaultServerName(host)
+	}
+
+	ccs.StartTLS = starttls.Resolve(ccs.Protocol, port)
+
+	err = starttls.Start(ctx, conn, ccs.StartTLS, host, serverName, timeouts.StartTLS)
+	if err != nil {
+		return false, err
+	}
 
-	_, err = conn.Write(clientHello)
+	hello, clientHello, err := buildClientHello(serverName, version, list.suites)
 	if err != nil {
-		ccs.Vulnerable = testFailed
+		return false, err
+	}
 
-		return err
+	err = netutil.Write(ctx, conn, clientHello, timeouts.Write)
+	if err != nil {
+		return false, err
 	}
 
-	serverHelloDone := false
-	for !serverHelloDone {
-		header, body, err := readTLSRecord(conn)
-		if err != nil {
-			ccs.Vulnerable = testFailed
+	err = netutil.SetReadDeadline(ctx, conn, timeouts.Handshake)
+	if err != nil {
+		return false, err
+	}
 
-			return err
-		}
+	records := tlsrecord.NewReader(conn)
 
-		if header.Type != recordTypeHandshake {
-			continue
-		}
+	flight, err := handshake.ReadServerFlight(records)
+	if err != nil {
+		return ctx.Err() == nil && tlsrecord.Refused(err), err
+	}
 
-		handshakeType := body[0]
-		if handshakeType == handshakeTypeServerHelloDone {
-			serverHelloDone = true
-		}
+	ccs.Version, ccs.CipherList, ccs.CipherSuite = tlsrecord.VersionName(version), list.name, ""
+	if sh := flight.ServerHello; sh != nil {
+		version = sh.Version
+		ccs.Version, ccs.CipherSuite = tlsrecord.VersionName(version), handshake.CipherSuiteName(sh.CipherSuite)
 	}
 
-	// Send first CCS message
-	ccsMessage := []byte{recordTypeChangeCipherSpec, 0x03, 0x01, 0x00, 0x01, 0x01}
+	return false, ccs.inject(ctx, conn, records, hello, flight, version, timeouts)
+}
 
-	_, err = conn.Write(ccsMessage)
-	if err != nil {
-		ccs.Vulnerable = testFailed
+// inject sends the early ChangeCipherSpec at the negotiated version and,
+// if the server takes it in silence, the probe that shows whether it
+// switched ciphers.
+func (ccs *CCSInjection) inject(ctx context.Context, conn net.Conn, records *tlsrecord.Reader, hello []byte,
+	flight *handshake.ServerFlight, version uint16, timeouts checker.Timeouts,
+) error {
+	ccsMessage := tlsrecord.Marshal(tlsrecord.TypeChangeCipherSpec, version, []byte{0x01})
 
+	err := netutil.Write(ctx, conn, ccsMessage, timeouts.Write)
+	if err != nil {
 		return err
 	}
 
 	// A non-vulnerable server should send an alert immediately.
 	// Set a short deadline to check for this.
-	err = conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+	err = netutil.SetReadDeadline(ctx, conn, timeouts.Read)
 	if err != nil {
-		ccs.Vulnerable = testFailed
-
 		return err
 	}
 
-	_, _, err = readTLSRecord(conn)
-	if err == nil {
-		// If we read a record successfully, it's likely an alert.
-		// Assume not vulnerable.
-		ccs.Vulnerable = notVulnerable
+	record, err := records.ReadRecord()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
-		return nil
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return ccs.rejected(record, err)
 	}
 
-	// Reset deadline
-	err = conn.SetReadDeadline(time.Time{})
+	// The server accepted the CCS. Follow up with a Finished protected
+	// under the keys it now derives from an empty master secret, or with
+	// a second CCS for suites those keys cannot be computed for; either
+	// way a vulnerable server fails to read it as plaintext.
+	probe, err := zeroKeyFinished(hello, flight)
 	if err != nil {
-		ccs.Vulnerable = testFailed
-
 		return err
 	}
 
-	// Send second CCS message to force a response
-	_, err = conn.Write(ccsMessage)
-	if err != nil {
-		ccs.Vulnerable = testFailed
+	ccs.Probe = ProbeZeroKeyFinished
+	if probe == nil {
+		ccs.Probe, probe = ProbeCCS, ccsMessage
+	}
 
+	err = netutil.Write(ctx, conn, probe, timeouts.Write)
+	if err != nil {
 		return err
 	}
 
-	header, body, err := readTLSRecord(conn)
+	err = netutil.SetReadDeadline(ctx, conn, timeouts.Read)
 	if err != nil {
-		// If we can't read a record, it's inconclusive.
-		// The original script treats this as a handshake failure.
-		// We'll mark as not vulnerable to be safe.
-		ccs.Vulnerable = notVulnerable
+		return err
+	}
 
-		return nil
+	record, err = records.ReadRecord()
+
+	// A cancelled context is not a verdict.
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
 
-	if header.Type == recordTypeAlert {
-		if len(body) >= 2 && body[0] == alertLevelFatal && body[1] == alertUnexpectedMessage {
-			ccs.Vulnerable = notVulnerable
-		} else {
-			ccs.Vulnerable = vulnerable
-		}
-	} else {
-		ccs.Vulnerable = vulnerable
+	return ccs.classify(record, err)
+}
+
+// rejected records how the server reacted to the early CCS before any
+// probe: an alert, a closed connection or any other record all mean it
+// refused the CCS.
+func (ccs *CCSInjection) rejected(record *tlsrecord.Record, err error) error {
+	ccs.Vulnerable = checker.StatusNotVulnerable
+
+	response, err := readFailed(err)
+	if err != nil {
+		return err
+	}
+
+	if response == "" {
+		response = ResponseRejected
+		ccs.recordAlert(record)
 	}
 
+	ccs.Response = response
+
 	return nil
 }
 
-func readTLSRecord(r io.Reader) (*tlsRecordHeader, []byte, error) {
-	header := &tlsRecordHeader{}
+// classify maps the reply to the probe to a verdict. Bad MAC and
+// decryption alerts prove the server decrypts with the wrong keys, which
+// also tells OpenSSL releases apart; unexpected_message to the zero-key
+// Finished proves it decrypted the record and only objected to its
+// place in the handshake.
+func (ccs *CCSInjection) classify(record *tlsrecord.Record, err error) error {
+	ccs.Vulnerable = checker.StatusNotVulnerable
 
-	err := binary.Read(r, binary.BigEndian, header)
+	response, err := readFailed(err)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 
-	body := make([]byte, header.Length)
+	if response != "" {
+		ccs.Response = response
 
-	_, err = io.ReadFull(r, body)
-	if err != nil {
-		return nil, nil, err
+		return nil
+	}
+
+	if record.Type != tlsrecord.TypeAlert {
+		ccs.Vulnerable, ccs.Response = checker.StatusVulnerable, ResponseAnswered
+
+		return nil
+	}
+
+	alert := ccs.recordAlert(record)
+
+	switch {
+	case alert == nil:
+		ccs.Response = ResponseAlert
+	case alert.Description == tlsrecord.AlertBadRecordMAC:
+		ccs.Vulnerable, ccs.Response = checker.StatusVulnerable, ResponseBadRecordMAC
+		ccs.Implementation = "OpenSSL 1.0.1"
+	case alert.Description == tlsrecord.AlertDecryptionFailed:
+		ccs.Vulnerable, ccs.Response = checker.StatusVulnerable, ResponseDecryptionFailed
+		ccs.Implementation = "OpenSSL 0.9.8/1.0.0"
+	case alert.Description == tlsrecord.AlertUnexpectedMessage && ccs.Probe == ProbeZeroKeyFinished:
+		ccs.Vulnerable, ccs.Response = checker.StatusVulnerable, ResponseZeroKeyAccepted
+	default:
+		ccs.Response = ResponseAlert
 	}
 
-	return header, body, nil
+	return nil
 }
 
-func buildClientHello() []byte {
-	// A simplified ClientHello.
-	// In a real-world scenario, this would be more complex, with extensions,
-	// cipher suites, etc. For this test, a minimal hello is sufficient.
-	random := make([]byte, 32)
+// recordAlert keeps the raw bytes and name of an alert record and
+// returns it decoded, or nil for other records and malformed alerts.
+func (ccs *CCSInjection) recordAlert(record *tlsrecord.Record) *tlsrecord.AlertError {
+	if record.Type != tlsrecord.TypeAlert {
+		return nil
+	}
+
+	ccs.AlertRecord = hex.EncodeToString(tlsrecord.Marshal(record.Type, record.Version, record.Payload))
 
-	_, err := rand.Read(random)
+	alert, err := tlsrecord.ParseAlert(record.Payload)
 	if err != nil {
 		return nil
 	}
 
-	clientHello := new(bytes.Buffer)
-	// Handshake header
-	clientHello.WriteByte(handshakeTypeClientHello) // Handshake Type
-	clientHello.Write([]byte{0x00, 0x00, 0x35})     // Length
-
-	// Client Version (TLS 1.2)
-	clientHello.Write([]byte{0x03, 0x03})
+	ccs.Alert = alert.Name()
 
-	// Random
-	clientHello.Write(random)
+	return alert
+}
 
-	// Session ID
-	clientHello.WriteByte(0x00)
+// readFailed classifies a failed read: a timeout, a clean close and a
+// reset each have their own response, and other errors are returned.
+// A successful read gives an empty response.
+func readFailed(err error) (Response, error) {
+	switch {
+	case err == nil:
+		return "", nil
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return ResponseSilent, nil
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ResponseClosed, nil
+	case errors.Is(err, syscall.ECONNRESET):
+		return ResponseReset, nil
+	default:
+		return "", err
+	}
+}
 
-	// Cipher Suites
-	cipherSuites := []uint16{
-		0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014,
-		0x009c, 0x009d, 0x002f, 0x0035, 0xc012, 0x000a,
+// zeroKeyFinished returns a Finished record protected with the client
+// write keys derived from an empty master secret, which is what a
+// vulnerable server switches to on the early CCS. It returns nil when
+// the negotiated suite is not one handshake.KeySchedule supports.
+func zeroKeyFinished(hello []byte, flight *handshake.ServerFlight) ([]byte, error) {
+	sh := flight.ServerHello
+	if sh == nil || sh.Version < tlsrecord.VersionTLS10 ||
+		!slices.Contains(handshake.FullHandshakeSuites(sh.Version), sh.CipherSuite) {
+		return nil, nil
 	}
 
-	err = binary.Write(clientHello, binary.BigEndian, uint16(len(cipherSuites)*2)) // #nosec G115
+	ks, err := handshake.NewKeySchedule(sh.Version, sh.CipherSuite, nil)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
-	for _, suite := range cipherSuites {
-		err = binary.Write(clientHello, binary.BigEndian, suite)
-		if err != nil {
-			return nil
-		}
+	clientCipher, _, err := ks.Ciphers(hello[2:34], sh.Random[:])
+	if err != nil {
+		return nil, err
 	}
 
-	// Compression Methods
-	clientHello.WriteByte(0x01) // Length
-	clientHello.WriteByte(0x00) // Null compression
+	transcript := tlsrecord.HandshakeMessage(tlsrecord.HandshakeClientHello, hello)
+	for _, msg := range flight.Messages {
+		transcript = append(transcript, msg.Marshal()...)
+	}
 
-	// Extensions (empty for this simplified hello)
-	clientHello.Write([]byte{0x00, 0x00})
+	var out bytes.Buffer
 
-	// Record header
-	record := new(bytes.Buffer)
-	record.WriteByte(recordTypeHandshake) // Record Type
-	record.Write([]byte{0x03, 0x01})      // Version (TLS 1.0)
+	w := tlsrecord.NewWriter(&out, sh.Version)
+	w.SetCipher(clientCipher)
 
-	err = binary.Write(record, binary.BigEndian, uint16(clientHello.Len())) // #nosec G115
+	err = w.WriteHandshake(tlsrecord.HandshakeFinished, ks.ClientFinished(transcript))
 	if err != nil {
-		return nil
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// ErrNoHandshake is returned when the server refuses the ClientHello of
+// every version and cipher list.
+var ErrNoHandshake = errors.New("ccs: server refused every ClientHello")
+
+// helloVersions are offered in turn, newest first, until the server
+// answers.
+var helloVersions = []uint16{
+	tlsrecord.VersionTLS12,
+	tlsrecord.VersionTLS11,
+	tlsrecord.VersionTLS10,
+	tlsrecord.VersionSSL30,
+}
+
+// cipherList is a named set of suites offered together.
+type cipherList struct {
+	name   string
+	suites []uint16
+}
+
+// cipherLists are offered in turn at each version. The first covers
+// current servers; the others are for servers limited to DHE or to
+// legacy suites.
+var cipherLists = []cipherList{
+	{
+		name: "default",
+		suites: []uint16{
+			0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014,
+			0x009c, 0x009d, 0x002f, 0x0035, 0xc012, 0x000a,
+		},
+	},
+	{
+		name:   "dhe",
+		suites: []uint16{0x009e, 0x009f, 0x0033, 0x0039, 0x0067, 0x006b, 0x0045, 0x0088, 0x0016},
+	},
+	{
+		name: "legacy",
+		suites: []uint16{
+			0x0005, 0x0004, 0xc011, 0xc007, 0x0041, 0x0084, 0x0096, 0x0009, 0x0015,
+			0x0003, 0x0008, 0x0006, 0x0014,
+		},
+	},
+}
+
+// buildClientHello returns the body of a minimal ClientHello offering
+// version and suites, and the record carrying it. The record layer
+// stays at TLS 1.0 for old servers, or SSLv3 when that is offered.
+func buildClientHello(serverName string, version uint16, suites []uint16) ([]byte, []byte, error) {
+	hello := &handshake.ClientHello{
+		Version:      version,
+		CipherSuites: suites,
+		ServerName:   serverName,
 	}
 
-	record.Write(clientHello.Bytes())
+	body, err := hello.Marshal()
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return record.Bytes()
+	return body, tlsrecord.Marshal(tlsrecord.TypeHandshake, min(version, tlsrecord.VersionTLS10),
+		tlsrecord.HandshakeMessage(tlsrecord.HandshakeClientHello, body)), nil
 }
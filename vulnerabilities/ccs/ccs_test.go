@@ -1,11 +1,23 @@
 package ccs
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"io"
 	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/internal/handshake"
+	"github.com/jsandas/tls-vuln-checker/internal/starttls"
+	"github.com/jsandas/tls-vuln-checker/internal/starttls/starttlstest"
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
 )
 
 func TestCheckCCS(t *testing.T) {
@@ -41,8 +53,8 @@ func TestCheckCCS(t *testing.T) {
 			}
 
 			// Send ServerHelloDone
-			serverHelloDoneMsg := []byte{recordTypeHandshake, 0x03, 0x01, 0x00, 0x04,
-				handshakeTypeServerHelloDone, 0x00, 0x00, 0x00}
+			serverHelloDoneMsg := []byte{tlsrecord.TypeHandshake, 0x03, 0x01, 0x00, 0x04,
+				tlsrecord.HandshakeServerHelloDone, 0x00, 0x00, 0x00}
 
 			_, err = conn.Write(serverHelloDoneMsg)
 			if err != nil {
@@ -58,7 +70,10 @@ func TestCheckCCS(t *testing.T) {
 			}
 
 			// A safe server sends a fatal alert for an unexpected CCS.
-			alertMsg := []byte{recordTypeAlert, 0x03, 0x01, 0x00, 0x02, alertLevelFatal, alertUnexpectedMessage}
+			alertMsg := []byte{
+				tlsrecord.TypeAlert, 0x03, 0x01, 0x00, 0x02,
+				tlsrecord.AlertLevelFatal, tlsrecord.AlertUnexpectedMessage,
+			}
 
 			conn.Write(alertMsg)
 		}()
@@ -73,7 +88,59 @@ func TestCheckCCS(t *testing.T) {
 			t.Fatalf("Check failed with an unexpected error: %v", err)
 		}
 
-		if r.Vulnerable != notVulnerable {
+		if r.Vulnerable != checker.StatusNotVulnerable {
+			t.Errorf("Expected server to be not vulnerable, got: %s", r.Vulnerable)
+		}
+
+		if r.Response != ResponseRejected || r.Alert != "unexpected_message" || r.AlertRecord != "1503010002020a" {
+			t.Errorf("Expected the rejection to be recorded, got: %s/%q/%q", r.Response, r.Alert, r.AlertRecord)
+		}
+	})
+
+	t.Run("CoalescedHandshake", func(t *testing.T) {
+		lc := net.ListenConfig{}
+
+		ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		defer ln.Close()
+
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+			records := tlsrecord.NewReader(conn)
+			records.ReadHandshake()
+
+			// ServerHello, Certificate and ServerHelloDone in one record
+			var flight []byte
+			flight = append(flight, tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHello, make([]byte, 38))...)
+			flight = append(flight, tlsrecord.HandshakeMessage(tlsrecord.HandshakeCertificate, []byte{0, 0, 0})...)
+			flight = append(flight, tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHelloDone, nil)...)
+			conn.Write(tlsrecord.Marshal(tlsrecord.TypeHandshake, tlsrecord.VersionTLS12, flight))
+
+			records.ReadRecord()
+
+			conn.Write(tlsrecord.Marshal(tlsrecord.TypeAlert, tlsrecord.VersionTLS12,
+				[]byte{tlsrecord.AlertLevelFatal, tlsrecord.AlertUnexpectedMessage}))
+		}()
+
+		host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+		var r CCSInjection
+
+		err = r.Check(host, port)
+		if err != nil {
+			t.Fatalf("Check failed with an unexpected error: %v", err)
+		}
+
+		if r.Vulnerable != checker.StatusNotVulnerable {
 			t.Errorf("Expected server to be not vulnerable, got: %s", r.Vulnerable)
 		}
 	})
@@ -121,8 +188,8 @@ func TestCheckCCS(t *testing.T) {
 			// This signals that the server part of the handshake is over.
 			// Record Header: Handshake, TLS 1.0, length 4
 			// Handshake Header: ServerHelloDone, length 0
-			serverHelloDoneMsg := []byte{recordTypeHandshake, 0x03, 0x01, 0x00, 0x04,
-				handshakeTypeServerHelloDone, 0x00, 0x00, 0x00}
+			serverHelloDoneMsg := []byte{tlsrecord.TypeHandshake, 0x03, 0x01, 0x00, 0x04,
+				tlsrecord.HandshakeServerHelloDone, 0x00, 0x00, 0x00}
 
 			_, err = conn.Write(serverHelloDoneMsg)
 			if err != nil {
@@ -169,8 +236,663 @@ func TestCheckCCS(t *testing.T) {
 			t.Fatalf("Check failed with an unexpected error: %v", err)
 		}
 
-		if r.Vulnerable != vulnerable {
+		if r.Vulnerable != checker.StatusVulnerable {
 			t.Errorf("Expected server to be vulnerable, got: %s", r.Vulnerable)
 		}
+
+		// without a ServerHello the zero keys are unknown
+		if r.Probe != ProbeCCS || r.Response != ResponseAnswered {
+			t.Errorf("Expected a second CCS to be answered, got: %s/%s", r.Probe, r.Response)
+		}
+	})
+
+	t.Run("Checker", func(t *testing.T) {
+		c := &Checker{}
+
+		res := c.Check(context.Background(), checker.Target{Host: "127.0.0.1", Port: "1"})
+		if res.Status != checker.StatusError || res.Err == nil {
+			t.Errorf("Expected error result, got: %s/%v", res.Status, res.Err)
+		}
+	})
+
+	t.Run("ContextCanceled", func(t *testing.T) {
+		lc := net.ListenConfig{}
+
+		ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		defer ln.Close()
+
+		// Accept the connection but never answer the ClientHello
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			io.Copy(io.Discard, conn)
+		}()
+
+		host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		r := CCSInjection{Timeouts: checker.Timeouts{Handshake: time.Minute}}
+		start := time.Now()
+
+		err = r.CheckContext(ctx, host, port)
+		if err == nil || r.Vulnerable != checker.StatusError {
+			t.Errorf("Expected context error, got: %v/%s", err, r.Vulnerable)
+		}
+
+		if time.Since(start) > 2*time.Second {
+			t.Errorf("Context deadline not honoured, took %v", time.Since(start))
+		}
+	})
+
+	t.Run("ServerName", func(t *testing.T) {
+		lc := net.ListenConfig{}
+
+		ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		defer ln.Close()
+
+		// the server refuses every hello, so each combination is tried
+		names := make(chan string, len(helloVersions)*len(cipherLists)*2)
+		cfg := &tls.Config{
+			GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+				names <- info.ServerName
+
+				return nil, errors.New("unknown name")
+			},
+		}
+
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+
+				tls.Server(conn, cfg).Handshake()
+				conn.Close()
+			}
+		}()
+
+		host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+		r := CCSInjection{ServerName: "vhost.example.com"}
+		r.Check(host, port)
+
+		if got := <-names; got != "vhost.example.com" {
+			t.Errorf("Expected server name vhost.example.com, got: %q", got)
+		}
+
+		for len(names) > 0 {
+			<-names
+		}
+
+		res := (&Checker{}).Check(context.Background(), checker.Target{Host: host, Port: port, ServerName: "b.example.com"})
+
+		if got := <-names; got != "b.example.com" || res.Evidence["server_name"] != got {
+			t.Errorf("Expected server name b.example.com, got: %q (evidence %v)", got, res.Evidence)
+		}
+	})
+
+	t.Run("StartTLS", func(t *testing.T) {
+		for _, proto := range checker.Protocols {
+			t.Run(string(proto), func(t *testing.T) {
+				host, port := startTLSServer(t, proto, starttlstest.Serve)
+
+				r := CCSInjection{Protocol: proto}
+
+				err := r.Check(host, port)
+				if err != nil {
+					t.Fatalf("Check failed with an unexpected error: %v", err)
+				}
+
+				if r.Vulnerable != checker.StatusNotVulnerable {
+					t.Errorf("Expected server to be not vulnerable, got: %s", r.Vulnerable)
+				}
+			})
+		}
+	})
+
+	t.Run("StartTLSRefused", func(t *testing.T) {
+		host, port := startTLSServer(t, checker.ProtocolSMTP, starttlstest.Refuse)
+
+		res := (&Checker{Protocol: checker.ProtocolSMTP}).Check(context.Background(), checker.Target{Host: host, Port: port})
+		if res.Status != checker.StatusError || !errors.Is(res.Err, starttls.ErrNotSupported) {
+			t.Errorf("Expected STARTTLS error, got: %s/%v", res.Status, res.Err)
+		}
+	})
+
+	t.Run("Dialer", func(t *testing.T) {
+		d := &recordingDialer{}
+		r := CCSInjection{Dialer: d}
+
+		err := r.Check("127.0.0.1", "1")
+		if err == nil {
+			t.Fatal("Expected a connection error, but got nil")
+		}
+
+		if d.address != "127.0.0.1:1" {
+			t.Errorf("Expected the injected dialer to be used, got address: %q", d.address)
+		}
+	})
+}
+
+// recordingDialer dials directly and remembers the last address.
+type recordingDialer struct {
+	address string
+}
+
+func (d *recordingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.address = address
+
+	var nd net.Dialer
+
+	return nd.DialContext(ctx, network, address)
+}
+
+// startTLSServer runs serve for proto on each connection and then
+// behaves like a patched server: the injected CCS gets a fatal
+// unexpected_message alert.
+func startTLSServer(t *testing.T, proto checker.Protocol,
+	serve func(net.Conn, checker.Protocol) error,
+) (string, string) {
+	t.Helper()
+
+	lc := net.ListenConfig{}
+
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+		err = serve(conn, proto)
+		if err != nil {
+			return
+		}
+
+		records := tlsrecord.NewReader(conn)
+		records.ReadHandshake()
+
+		conn.Write(tlsrecord.Marshal(tlsrecord.TypeHandshake, tlsrecord.VersionTLS12,
+			tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHelloDone, nil)))
+
+		records.ReadRecord()
+
+		conn.Write(tlsrecord.Marshal(tlsrecord.TypeAlert, tlsrecord.VersionTLS12,
+			[]byte{tlsrecord.AlertLevelFatal, tlsrecord.AlertUnexpectedMessage}))
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	return host, port
+}
+
+// clientResult is what ServeClients reported for one client.
+type clientResult struct {
+	ccs *CCSInjection
+	err error
+}
+
+// serveClients runs ServeClients on a local listener until the test
+// ends and returns its address and the results it reports.
+func serveClients(t *testing.T) (string, <-chan clientResult) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	lc := net.ListenConfig{}
+
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	results := make(chan clientResult, 1)
+
+	go ServeClients(ctx, ln, CCSInjection{Timeouts: checker.Timeouts{Read: 300 * time.Millisecond}},
+		func(c *CCSInjection, err error) {
+			results <- clientResult{ccs: c, err: err}
+		})
+
+	return ln.Addr().String(), results
+}
+
+// ccsClient sends a ClientHello to addr, expects ServerHello followed by
+// ChangeCipherSpec and hands the connection to react.
+func ccsClient(t *testing.T, addr string, react func(conn net.Conn)) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	_, hello, err := buildClientHello("client.test", tlsrecord.VersionTLS12, cipherLists[0].suites)
+	if err != nil {
+		t.Fatalf("failed to build ClientHello: %v", err)
+	}
+
+	conn.Write(hello)
+
+	records := tlsrecord.NewReader(conn)
+
+	msgType, _, err := records.ReadHandshake()
+	if err != nil || msgType != tlsrecord.HandshakeServerHello {
+		t.Fatalf("expected ServerHello, got %d/%v", msgType, err)
+	}
+
+	record, err := records.ReadRecord()
+	if err != nil || record.Type != tlsrecord.TypeChangeCipherSpec {
+		t.Fatalf("expected ChangeCipherSpec, got %+v/%v", record, err)
+	}
+
+	react(conn)
+}
+
+func TestCheckCCSClient(t *testing.T) {
+	addr, results := serveClients(t)
+
+	tests := []struct {
+		name  string
+		react func(conn net.Conn)
+		want  checker.Status
+		alert string
+		err   error
+	}{
+		{
+			// took the CCS, then fails to authenticate the plaintext
+			// probe under the zero keys
+			name: "Accepted",
+			react: func(conn net.Conn) {
+				tlsrecord.NewReader(conn).ReadRecord()
+				conn.Write(alertRecord(tlsrecord.AlertBadRecordMAC))
+			},
+			want:  checker.StatusVulnerable,
+			alert: "bad_record_mac",
+		},
+		{
+			// silence proves nothing either way
+			name:  "Silent",
+			react: func(net.Conn) { time.Sleep(800 * time.Millisecond) },
+			want:  checker.StatusError,
+			err:   ErrInconclusive,
+		},
+		{
+			name: "Alert",
+			react: func(conn net.Conn) {
+				conn.Write(tlsrecord.Marshal(tlsrecord.TypeAlert, tlsrecord.VersionTLS12,
+					[]byte{tlsrecord.AlertLevelFatal, tlsrecord.AlertUnexpectedMessage}))
+			},
+			want:  checker.StatusNotVulnerable,
+			alert: "unexpected_message",
+		},
+		{
+			name:  "Closed",
+			react: func(net.Conn) {},
+			want:  checker.StatusNotVulnerable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ccsClient(t, addr, tt.react)
+
+			r := <-results
+			c := r.ccs
+
+			if !errors.Is(r.err, tt.err) {
+				t.Errorf("Wrong error, got: %v, want: %v.", r.err, tt.err)
+			}
+
+			if c.Vulnerable != tt.want || c.Alert != tt.alert {
+				t.Errorf("Wrong verdict, got: %s/%q, want: %s/%q.", c.Vulnerable, c.Alert, tt.want, tt.alert)
+			}
+
+			if c.ServerName != "client.test" || c.Client == "" {
+				t.Errorf("Wrong client details: %q %q.", c.ServerName, c.Client)
+			}
+		})
+	}
+
+	t.Run("Go", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer conn.Close()
+
+		tls.Client(conn, &tls.Config{ServerName: "go.test", MaxVersion: tls.VersionTLS12}).Handshake()
+
+		c := (<-results).ccs
+
+		if c.Vulnerable != checker.StatusNotVulnerable || c.Alert != "unexpected_message" {
+			t.Errorf("Wrong verdict, got: %s/%q.", c.Vulnerable, c.Alert)
+		}
+	})
+}
+
+// injectionServer answers the ClientHello with ServerHello (TLS 1.2,
+// TLS_RSA_WITH_AES_128_CBC_SHA) and ServerHelloDone, reads the early CCS
+// without complaint and hands the next record to react, along with a
+// flag telling whether it decrypts as the zero-key Finished.
+func injectionServer(t *testing.T, react func(conn net.Conn, zeroKey bool)) (string, string) {
+	t.Helper()
+
+	lc := net.ListenConfig{}
+
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+		records := tlsrecord.NewReader(conn)
+
+		_, body, err := records.ReadHandshake()
+		if err != nil {
+			return
+		}
+
+		hello, _ := handshake.ParseClientHello(body)
+		sh, _ := handshake.NewServerHello(hello, tlsrecord.VersionTLS12)
+		sh.CipherSuite = 0x002f
+		shBody, _ := sh.Marshal()
+
+		transcript := tlsrecord.HandshakeMessage(tlsrecord.HandshakeClientHello, body)
+		transcript = append(transcript, tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHello, shBody)...)
+		transcript = append(transcript, tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHelloDone, nil)...)
+
+		conn.Write(tlsrecord.Marshal(tlsrecord.TypeHandshake, tlsrecord.VersionTLS12,
+			transcript[len(transcript)-len(shBody)-8:]))
+
+		// the early CCS, taken in silence
+		records.ReadRecord()
+
+		// decrypt the probe as a vulnerable server would, with keys from
+		// an empty master secret
+		ks, _ := handshake.NewKeySchedule(sh.Version, sh.CipherSuite, nil)
+		clientCipher, _, _ := ks.Ciphers(body[2:34], sh.Random[:])
+		records.SetCipher(clientCipher)
+
+		msgType, finished, err := records.ReadHandshake()
+		zeroKey := err == nil && msgType == tlsrecord.HandshakeFinished &&
+			bytes.Equal(finished, ks.ClientFinished(transcript))
+
+		react(conn, zeroKey)
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	return host, port
+}
+
+func alertRecord(description uint8) []byte {
+	return tlsrecord.Marshal(tlsrecord.TypeAlert, tlsrecord.VersionTLS12, []byte{tlsrecord.AlertLevelFatal, description})
+}
+
+func TestCheckCCSVerdicts(t *testing.T) {
+	tests := []struct {
+		name           string
+		react          func(conn net.Conn, zeroKey bool)
+		want           checker.Status
+		response       Response
+		alert          string
+		implementation string
+	}{
+		{
+			name: "ZeroKeyAccepted",
+			react: func(conn net.Conn, zeroKey bool) {
+				if zeroKey {
+					conn.Write(alertRecord(tlsrecord.AlertUnexpectedMessage))
+				}
+			},
+			want:     checker.StatusVulnerable,
+			response: ResponseZeroKeyAccepted,
+			alert:    "unexpected_message",
+		},
+		{
+			name:           "BadRecordMAC",
+			react:          func(conn net.Conn, _ bool) { conn.Write(alertRecord(tlsrecord.AlertBadRecordMAC)) },
+			want:           checker.StatusVulnerable,
+			response:       ResponseBadRecordMAC,
+			alert:          "bad_record_mac",
+			implementation: "OpenSSL 1.0.1",
+		},
+		{
+			name:           "DecryptionFailed",
+			react:          func(conn net.Conn, _ bool) { conn.Write(alertRecord(tlsrecord.AlertDecryptionFailed)) },
+			want:           checker.StatusVulnerable,
+			response:       ResponseDecryptionFailed,
+			alert:          "decryption_failed",
+			implementation: "OpenSSL 0.9.8/1.0.0",
+		},
+		{
+			name:     "OtherAlert",
+			react:    func(conn net.Conn, _ bool) { conn.Write(alertRecord(tlsrecord.AlertHandshakeFailure)) },
+			want:     checker.StatusNotVulnerable,
+			response: ResponseAlert,
+			alert:    "handshake_failure",
+		},
+		{
+			name:     "SilentClose",
+			react:    func(net.Conn, bool) {},
+			want:     checker.StatusNotVulnerable,
+			response: ResponseClosed,
+		},
+		{
+			name: "Reset",
+			react: func(conn net.Conn, _ bool) {
+				conn.(*net.TCPConn).SetLinger(0)
+			},
+			want:     checker.StatusNotVulnerable,
+			response: ResponseReset,
+		},
+		{
+			name:     "NoResponse",
+			react:    func(net.Conn, bool) { time.Sleep(500 * time.Millisecond) },
+			want:     checker.StatusNotVulnerable,
+			response: ResponseSilent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port := injectionServer(t, tt.react)
+
+			r := CCSInjection{Timeouts: checker.Timeouts{Read: 300 * time.Millisecond}}
+
+			err := r.Check(host, port)
+			if err != nil || r.Vulnerable != tt.want {
+				t.Fatalf("Wrong return, got: %s/%v, want: %s.", r.Vulnerable, err, tt.want)
+			}
+
+			if r.Response != tt.response || r.Alert != tt.alert || r.Implementation != tt.implementation {
+				t.Errorf("Wrong verdict, got: %s/%q/%q, want: %s/%q/%q.", r.Response, r.Alert, r.Implementation,
+					tt.response, tt.alert, tt.implementation)
+			}
+
+			if r.Probe != ProbeZeroKeyFinished {
+				t.Errorf("Wrong probe, got: %q.", r.Probe)
+			}
+
+			if tt.alert != "" && !strings.HasPrefix(r.AlertRecord, "150303000202") {
+				t.Errorf("Wrong alert record, got: %q.", r.AlertRecord)
+			}
+		})
+	}
+
+	t.Run("Checker", func(t *testing.T) {
+		host, port := injectionServer(t, func(conn net.Conn, _ bool) {
+			conn.Write(alertRecord(tlsrecord.AlertBadRecordMAC))
+		})
+
+		res := (&Checker{}).Check(context.Background(), checker.Target{Host: host, Port: port})
+
+		if res.Status != checker.StatusVulnerable || res.Evidence["response"] != string(ResponseBadRecordMAC) ||
+			res.Evidence["alert_record"] != "15030300020214" || res.Evidence["implementation"] != "OpenSSL 1.0.1" {
+			t.Errorf("Wrong result, got: %s %v.", res.Status, res.Evidence)
+		}
 	})
 }
+
+// sslv3OnlyServer refuses every ClientHello but an SSLv3 one offering
+// TLS_RSA_WITH_RC4_128_SHA, and reports the version of the CCS record
+// injected after it.
+func sslv3OnlyServer(t *testing.T) (string, string, <-chan uint16) {
+	t.Helper()
+
+	lc := net.ListenConfig{}
+
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	t.Cleanup(func() { ln.Close() })
+
+	ccsVersions := make(chan uint16, 1)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+			records := tlsrecord.NewReader(conn)
+
+			_, body, _ := records.ReadHandshake()
+			hello, err := handshake.ParseClientHello(body)
+
+			if err != nil || hello.Version != tlsrecord.VersionSSL30 || !slices.Contains(hello.CipherSuites, 0x0005) {
+				conn.Write(tlsrecord.Marshal(tlsrecord.TypeAlert, tlsrecord.VersionSSL30,
+					[]byte{tlsrecord.AlertLevelFatal, tlsrecord.AlertHandshakeFailure}))
+				conn.Close()
+
+				continue
+			}
+
+			sh := handshake.ServerHello{Version: tlsrecord.VersionSSL30, CipherSuite: 0x0005}
+			shBody, _ := sh.Marshal()
+
+			flight := tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHello, shBody)
+			flight = append(flight, tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHelloDone, nil)...)
+			conn.Write(tlsrecord.Marshal(tlsrecord.TypeHandshake, tlsrecord.VersionSSL30, flight))
+
+			record, err := records.ReadRecord()
+			if err == nil {
+				ccsVersions <- record.Version
+			}
+
+			conn.Write(tlsrecord.Marshal(tlsrecord.TypeAlert, tlsrecord.VersionSSL30,
+				[]byte{tlsrecord.AlertLevelFatal, tlsrecord.AlertUnexpectedMessage}))
+			conn.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	return host, port, ccsVersions
+}
+
+func TestCheckCCSFallback(t *testing.T) {
+	host, port, ccsVersions := sslv3OnlyServer(t)
+
+	var r CCSInjection
+
+	err := r.Check(host, port)
+	if err != nil || r.Vulnerable != checker.StatusNotVulnerable {
+		t.Fatalf("Wrong return, got: %s/%v, want: %s.", r.Vulnerable, err, checker.StatusNotVulnerable)
+	}
+
+	if r.Version != "SSLv3" || r.CipherList != "legacy" || r.CipherSuite != "TLS_RSA_WITH_RC4_128_SHA" {
+		t.Errorf("Wrong combination, got: %s/%s/%s.", r.Version, r.CipherList, r.CipherSuite)
+	}
+
+	if got := <-ccsVersions; got != tlsrecord.VersionSSL30 {
+		t.Errorf("Expected the CCS at the negotiated version, got: 0x%04x.", got)
+	}
+
+	res := (&Checker{}).Check(context.Background(), checker.Target{Host: host, Port: port})
+	<-ccsVersions
+
+	if res.Evidence["version"] != "SSLv3" || res.Evidence["cipher_list"] != "legacy" {
+		t.Errorf("Wrong evidence, got: %v.", res.Evidence)
+	}
+}
+
+func TestCheckCCSAllRefused(t *testing.T) {
+	lc := net.ListenConfig{}
+
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	var attempts atomic.Int32
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			attempts.Add(1)
+
+			tlsrecord.NewReader(conn).ReadHandshake()
+			conn.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	var r CCSInjection
+
+	err = r.Check(host, port)
+	if !errors.Is(err, ErrNoHandshake) || r.Vulnerable != checker.StatusError {
+		t.Errorf("Expected ErrNoHandshake, got: %s/%v.", r.Vulnerable, err)
+	}
+
+	if want := len(helloVersions) * len(cipherLists); int(attempts.Load()) != want {
+		t.Errorf("Expected %d attempts, got %d.", want, attempts.Load())
+	}
+}
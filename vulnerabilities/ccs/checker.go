@@ -0,0 +1,78 @@
+package ccs
+
+import (
+	"context"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+)
+
+// Name identifies the CCS injection check.
+const Name = "ccs"
+
+var _ checker.Checker = (*Checker)(nil)
+
+// Checker adapts CCSInjection to the checker.Checker interface.
+type Checker struct {
+	// Timeouts overrides the per-phase defaults of the check.
+	Timeouts checker.Timeouts
+	// Dialer opens the connection to the target. Nil dials directly.
+	Dialer checker.Dialer
+	// Protocol is negotiated with STARTTLS before the ClientHello.
+	// Empty infers it from the port.
+	Protocol checker.Protocol
+}
+
+// Name returns the name of the check.
+func (c *Checker) Name() string {
+	return Name
+}
+
+// Check runs the CCS injection check against target.
+func (c *Checker) Check(ctx context.Context, target checker.Target) checker.Result {
+	res := checker.NewResult(Name, target)
+
+	r := CCSInjection{Timeouts: c.Timeouts, Dialer: c.Dialer, ServerName: target.SNI(), Protocol: c.Protocol}
+
+	err := r.CheckContext(ctx, target.Host, target.Port)
+
+	if r.StartTLS != "" {
+		res.AddEvidence("starttls", string(r.StartTLS))
+	}
+
+	if r.ServerName != "" {
+		res.AddEvidence("server_name", r.ServerName)
+	}
+
+	if r.Version != "" {
+		res.AddEvidence("version", r.Version)
+		res.AddEvidence("cipher_list", r.CipherList)
+	}
+
+	if r.CipherSuite != "" {
+		res.AddEvidence("cipher_suite", r.CipherSuite)
+	}
+
+	if r.Response != "" {
+		res.AddEvidence("response", string(r.Response))
+	}
+
+	if r.Probe != "" {
+		res.AddEvidence("probe", string(r.Probe))
+	}
+
+	if r.Alert != "" {
+		res.AddEvidence("alert", r.Alert)
+	}
+
+	if r.AlertRecord != "" {
+		res.AddEvidence("alert_record", r.AlertRecord)
+	}
+
+	if r.Implementation != "" {
+		res.AddEvidence("implementation", r.Implementation)
+	}
+
+	res.Finish(r.Vulnerable, err)
+
+	return res
+}
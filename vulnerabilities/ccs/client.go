@@ -0,0 +1,140 @@
+package ccs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/internal/handshake"
+	"github.com/jsandas/tls-vuln-checker/internal/netutil"
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
+)
+
+// ErrInconclusive is returned by CheckClient when the client neither
+// rejects the early CCS nor reacts to the probe sent after it. Silence
+// alone does not show that the CCS was accepted.
+var ErrInconclusive = errors.New("ccs: client stayed silent after the early CCS and the probe")
+
+// CheckClient tests the TLS client connected on conn for CCS Injection,
+// playing the server. The ClientHello is answered with a ServerHello
+// followed at once by a ChangeCipherSpec, long before one is allowed. A
+// patched client rejects it with an unexpected_message alert or drops
+// the connection; a vulnerable one accepts it and silently waits for the
+// rest of the handshake. A client that stays silent is sent a second
+// CCS in the clear, which one that switched to the keys of an empty
+// master secret fails to authenticate; if that too goes unanswered the
+// check returns ErrInconclusive.
+//
+// Client is set to the remote address and ServerName to the name the
+// client asked for. Dialer and Protocol do not apply. conn is left
+// open.
+func (ccs *CCSInjection) CheckClient(ctx context.Context, conn net.Conn) error {
+	err := ccs.checkClient(ctx, conn)
+	if err != nil {
+		ccs.Vulnerable = checker.StatusError
+	}
+
+	return err
+}
+
+func (ccs *CCSInjection) checkClient(ctx context.Context, conn net.Conn) error {
+	timeouts := ccs.Timeouts.WithDefaults(defaultTimeouts)
+
+	stop := netutil.AbortOnDone(ctx, conn)
+	defer stop()
+
+	ccs.Client = conn.RemoteAddr().String()
+
+	err := netutil.SetReadDeadline(ctx, conn, timeouts.Handshake)
+	if err != nil {
+		return err
+	}
+
+	records := tlsrecord.NewReader(conn)
+
+	msgType, body, err := records.ReadHandshake()
+	if err != nil {
+		return err
+	}
+
+	if msgType != tlsrecord.HandshakeClientHello {
+		return fmt.Errorf("expected ClientHello, got handshake message %d", msgType)
+	}
+
+	hello, err := handshake.ParseClientHello(body)
+	if err != nil {
+		return err
+	}
+
+	ccs.ServerName = hello.ServerName()
+
+	sh, err := handshake.NewServerHello(hello, tlsrecord.VersionTLS12)
+	if err != nil {
+		return err
+	}
+
+	body, err = sh.Marshal()
+	if err != nil {
+		return err
+	}
+
+	flight := tlsrecord.Marshal(tlsrecord.TypeHandshake, sh.Version,
+		tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHello, body))
+	flight = append(flight, tlsrecord.Marshal(tlsrecord.TypeChangeCipherSpec, sh.Version, []byte{0x01})...)
+
+	err = netutil.Write(ctx, conn, flight, timeouts.Write)
+	if err != nil {
+		return err
+	}
+
+	err = netutil.SetReadDeadline(ctx, conn, timeouts.Read)
+	if err != nil {
+		return err
+	}
+
+	record, err := records.ReadRecord()
+	err = netutil.ReadErr(ctx, err)
+
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return ccs.rejected(record, err)
+	}
+
+	ccs.Probe = ProbeCCS
+
+	err = netutil.Write(ctx, conn, tlsrecord.Marshal(tlsrecord.TypeChangeCipherSpec, sh.Version, []byte{0x01}),
+		timeouts.Write)
+	if err != nil {
+		return err
+	}
+
+	err = netutil.SetReadDeadline(ctx, conn, timeouts.Read)
+	if err != nil {
+		return err
+	}
+
+	record, err = records.ReadRecord()
+	err = netutil.ReadErr(ctx, err)
+
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return ErrInconclusive
+	}
+
+	return ccs.classify(record, err)
+}
+
+// ServeClients accepts connections on ln and runs CheckClient on each,
+// concurrently, with a copy of c's timeouts. Every result is passed to
+// report, which must be safe for concurrent use. ln is closed when ctx
+// is done and ServeClients then returns ctx.Err(), or nil if ln was
+// closed by the caller, once every check has reported.
+func ServeClients(ctx context.Context, ln net.Listener, c CCSInjection, report func(*CCSInjection, error)) error {
+	return netutil.Serve(ctx, ln, func(conn net.Conn) {
+		probe := CCSInjection{Timeouts: c.Timeouts}
+
+		err := probe.CheckClient(ctx, conn)
+		report(&probe, err)
+	})
+}
@@ -0,0 +1,351 @@
+package debianweakkey
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:generate go run gen.go -in ../../resources/weakkeys -out blacklist.bin
+
+// ErrNoBlacklist is returned when a key's type and size have no list in
+// the blacklist, or when no list could be loaded at all.
+var ErrNoBlacklist = errors.New("debianweakkey: no blacklist")
+
+// blacklistMagic starts the binary form of a Blacklist.
+const blacklistMagic = "DWK1"
+
+// fingerprintLen is the length of the fingerprints kept in the
+// blacklists: the last 80 bits of the key's hash.
+const fingerprintLen = 10
+
+type fingerprint [fingerprintLen]byte
+
+type listKey struct {
+	algorithm string
+	bits      int
+}
+
+// Blacklist is an in-memory index of weak key fingerprints, one set per
+// key type and size, as shipped in the openssl-blacklist packages. It
+// is read-only once loaded and safe for concurrent use.
+type Blacklist struct {
+	lists map[listKey]map[fingerprint]struct{}
+}
+
+// sshDir holds the openssh-blacklist lists. Their fingerprints hash the
+// OpenSSH key blob, not the OpenSSL modulus, so they are kept apart
+// from the openssl-blacklist lists of the same name.
+const sshDir = "ssh"
+
+// LoadBlacklist reads every blacklist.<TYPE>-<bits> file at the root of
+// fsys, and in its ssh directory if there is one, into a Blacklist.
+// Lines hold the last 20 hex characters of each fingerprint; blank
+// lines and # comments are skipped.
+func LoadBlacklist(fsys fs.FS) (*Blacklist, error) {
+	b := &Blacklist{lists: make(map[listKey]map[fingerprint]struct{})}
+
+	err := b.loadDir(fsys, ".", "")
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.loadDir(fsys, sshDir, sshDir+"/")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if len(b.lists) == 0 {
+		return nil, fmt.Errorf("%w files found", ErrNoBlacklist)
+	}
+
+	return b, nil
+}
+
+// parseListName splits a file name such as blacklist.RSA-2048.
+func parseListName(name string) (listKey, bool) {
+	rest, ok := strings.CutPrefix(name, "blacklist.")
+	if !ok {
+		return listKey{}, false
+	}
+
+	algorithm, size, ok := strings.Cut(rest, "-")
+	if !ok || algorithm == "" {
+		return listKey{}, false
+	}
+
+	bits, err := strconv.Atoi(size)
+	if err != nil || bits <= 0 {
+		return listKey{}, false
+	}
+
+	return listKey{algorithm: algorithm, bits: bits}, true
+}
+
+// Len returns the number of fingerprints in the blacklist.
+func (b *Blacklist) Len() int {
+	var n int
+	for _, set := range b.lists {
+		n += len(set)
+	}
+
+	return n
+}
+
+// MarshalBinary encodes the blacklist in a compact form read back by
+// UnmarshalBinary: a magic string and list count, then each list's type,
+// size and count followed by its sorted raw fingerprints.
+func (b *Blacklist) MarshalBinary() ([]byte, error) {
+	keys := make([]listKey, 0, len(b.lists))
+	for key := range b.lists {
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(x, y listKey) int {
+		if c := strings.Compare(x.algorithm, y.algorithm); c != 0 {
+			return c
+		}
+
+		return x.bits - y.bits
+	})
+
+	buf := make([]byte, 0, len(blacklistMagic)+4+b.Len()*fingerprintLen)
+	buf = append(buf, blacklistMagic...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(keys))) // #nosec G115
+
+	for _, key := range keys {
+		if len(key.algorithm) > 0xff {
+			return nil, fmt.Errorf("debianweakkey: key type %q too long", key.algorithm)
+		}
+
+		set := b.lists[key]
+
+		fps := make([]fingerprint, 0, len(set))
+		for fp := range set {
+			fps = append(fps, fp)
+		}
+
+		slices.SortFunc(fps, func(x, y fingerprint) int { return bytes.Compare(x[:], y[:]) })
+
+		buf = append(buf, byte(len(key.algorithm)))
+		buf = append(buf, key.algorithm...)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(key.bits)) // #nosec G115
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(fps))) // #nosec G115
+
+		for _, fp := range fps {
+			buf = append(buf, fp[:]...)
+		}
+	}
+
+	return buf, nil
+}
+
+// UnmarshalBinary replaces the blacklist with one encoded by
+// MarshalBinary.
+func (b *Blacklist) UnmarshalBinary(data []byte) error {
+	errTruncated := fmt.Errorf("debianweakkey: truncated blacklist: %w", io.ErrUnexpectedEOF)
+
+	rest, ok := bytes.CutPrefix(data, []byte(blacklistMagic))
+	if !ok {
+		return errors.New("debianweakkey: not a binary blacklist")
+	}
+
+	if len(rest) < 4 {
+		return errTruncated
+	}
+
+	count := binary.BigEndian.Uint32(rest)
+	rest = rest[4:]
+	lists := make(map[listKey]map[fingerprint]struct{}, min(count, 64))
+
+	for range count {
+		if len(rest) < 1 {
+			return errTruncated
+		}
+
+		l := int(rest[0])
+		if len(rest) < 1+l+8 {
+			return errTruncated
+		}
+
+		key := listKey{algorithm: string(rest[1 : 1+l])}
+		rest = rest[1+l:]
+
+		key.bits = int(binary.BigEndian.Uint32(rest))
+		n := int(binary.BigEndian.Uint32(rest[4:]))
+		rest = rest[8:]
+
+		if len(rest)/fingerprintLen < n {
+			return errTruncated
+		}
+
+		set := make(map[fingerprint]struct{}, n)
+		for i := range n {
+			set[fingerprint(rest[i*fingerprintLen:])] = struct{}{}
+		}
+
+		lists[key] = set
+		rest = rest[n*fingerprintLen:]
+	}
+
+	if len(rest) != 0 {
+		return errors.New("debianweakkey: trailing data after blacklist")
+	}
+
+	b.lists = lists
+
+	return nil
+}
+
+// loadDir loads the lists in dir, prefixing their key types with prefix.
+func (b *Blacklist) loadDir(fsys fs.FS, dir, prefix string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		key, ok := parseListName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+
+		key.algorithm = prefix + key.algorithm
+
+		err = b.load(fsys, path.Join(dir, entry.Name()), key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *Blacklist) load(fsys fs.FS, name string, key listKey) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	set := b.lists[key]
+	if set == nil {
+		set = make(map[fingerprint]struct{})
+		b.lists[key] = set
+	}
+
+	scanner := bufio.NewScanner(f)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		if len(text) != 2*fingerprintLen {
+			return fmt.Errorf("%s:%d: want %d hex characters, got %q", name, line, 2*fingerprintLen, text)
+		}
+
+		var fp fingerprint
+
+		_, err = hex.Decode(fp[:], []byte(text))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+
+		set[fp] = struct{}{}
+	}
+
+	return scanner.Err()
+}
+
+// contains reports whether fp is listed for the key type and size, and
+// whether there is a list for them at all.
+func (b *Blacklist) contains(algorithm string, bits int, fp fingerprint) (found, listed bool) {
+	set, listed := b.lists[listKey{algorithm: algorithm, bits: bits}]
+	if !listed {
+		return false, false
+	}
+
+	_, found = set[fp]
+
+	return found, true
+}
+
+// hasPrefix reports whether any list's key type starts with prefix.
+func (b *Blacklist) hasPrefix(prefix string) bool {
+	for key := range b.lists {
+		if strings.HasPrefix(key.algorithm, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// embeddedBlacklist holds the binary blacklist compiled in with the
+// weakkeys_embed build tag, or nil.
+var embeddedBlacklist []byte
+
+var (
+	loadEmbedded = sync.OnceValues(func() (*Blacklist, error) {
+		b := &Blacklist{}
+
+		err := b.UnmarshalBinary(embeddedBlacklist)
+		if err != nil {
+			return nil, err
+		}
+
+		return b, nil
+	})
+
+	dirMu    sync.Mutex
+	dirLists = make(map[string]func() (*Blacklist, error))
+)
+
+// DefaultBlacklist returns the blacklist used when DebianWeakKey has none
+// of its own. It is read once from the directory named by WEAKKEY_PATH
+// if set, else from the copy compiled in with the weakkeys_embed build
+// tag, else from resources/weakkeys (see make setup-local). A directory
+// is only read once per process, so a failure to load it is returned
+// again without going back to the disk.
+func DefaultBlacklist() (*Blacklist, error) {
+	dir, ok := os.LookupEnv("WEAKKEY_PATH")
+	if !ok || dir == "" {
+		if embeddedBlacklist != nil {
+			return loadEmbedded()
+		}
+
+		dir = "resources/weakkeys"
+	}
+
+	dirMu.Lock()
+
+	load, ok := dirLists[dir]
+	if !ok {
+		load = sync.OnceValues(func() (*Blacklist, error) {
+			b, err := LoadBlacklist(os.DirFS(dir))
+			if err != nil {
+				return nil, fmt.Errorf("loading %s: %w", dir, err)
+			}
+
+			return b, nil
+		})
+		dirLists[dir] = load
+	}
+
+	dirMu.Unlock()
+
+	return load()
+}
@@ -0,0 +1,107 @@
+package debianweakkey
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+)
+
+// Name identifies the Debian weak key check.
+const Name = "debianweakkey"
+
+var _ checker.Checker = (*Checker)(nil)
+
+// Checker adapts DebianWeakKey to the checker.Checker interface. When a
+// Modulus is supplied that key is looked up and the target only
+// recorded in the result; otherwise the certificate chain served by the
+// target is fetched and every key in it checked.
+type Checker struct {
+	KeySize int
+	Modulus string
+
+	// Blacklist is the index to search. Nil uses DefaultBlacklist.
+	Blacklist *Blacklist
+	// Timeouts overrides the per-phase defaults of the check.
+	Timeouts checker.Timeouts
+	// Dialer opens the connection to the target. Nil dials directly.
+	Dialer checker.Dialer
+	// Protocol is negotiated with STARTTLS before the ClientHello.
+	// Empty infers it from the port.
+	Protocol checker.Protocol
+}
+
+// Name returns the name of the check.
+func (c *Checker) Name() string {
+	return Name
+}
+
+// Check looks up the configured key, or the keys of the target's
+// certificate chain, in the weak key blacklist.
+func (c *Checker) Check(ctx context.Context, target checker.Target) checker.Result {
+	if c.Modulus == "" {
+		return c.checkHost(ctx, target)
+	}
+
+	res := checker.NewResult(Name, target)
+
+	w := DebianWeakKey{Blacklist: c.Blacklist}
+
+	err := w.CheckContext(ctx, c.KeySize, c.Modulus)
+
+	res.AddEvidence("keysize", strconv.Itoa(c.KeySize))
+
+	if w.Reason != "" {
+		res.AddEvidence("reason", w.Reason)
+	}
+
+	res.Finish(w.Vulnerable, err)
+
+	return res
+}
+
+// checkHost runs CheckHost against target, with one group of evidence
+// per chain position.
+func (c *Checker) checkHost(ctx context.Context, target checker.Target) checker.Result {
+	res := checker.NewResult(Name, target)
+
+	w := DebianWeakKey{
+		Blacklist:  c.Blacklist,
+		Timeouts:   c.Timeouts,
+		Dialer:     c.Dialer,
+		ServerName: target.SNI(),
+		Protocol:   c.Protocol,
+	}
+
+	err := w.CheckHost(ctx, target.Host, target.Port)
+
+	if w.StartTLS != "" {
+		res.AddEvidence("starttls", string(w.StartTLS))
+	}
+
+	if w.ServerName != "" {
+		res.AddEvidence("server_name", w.ServerName)
+	}
+
+	for _, cr := range w.Chain {
+		prefix := "chain." + strconv.Itoa(cr.Position) + "."
+
+		res.AddEvidence(prefix+"subject", cr.Subject)
+		res.AddEvidence(prefix+"key", fmt.Sprintf("%s-%d", cr.Algorithm, cr.KeySize))
+		res.AddEvidence(prefix+"fingerprint", cr.Fingerprint)
+		res.AddEvidence(prefix+"status", string(cr.Vulnerable))
+
+		if cr.Reason != "" {
+			res.AddEvidence(prefix+"reason", cr.Reason)
+		}
+	}
+
+	if w.Reason != "" {
+		res.AddEvidence("reason", w.Reason)
+	}
+
+	res.Finish(w.Vulnerable, err)
+
+	return res
+}
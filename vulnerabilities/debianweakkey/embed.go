@@ -0,0 +1,14 @@
+//go:build weakkeys_embed
+
+package debianweakkey
+
+import _ "embed"
+
+// blacklistBin is written by go generate from resources/weakkeys.
+//
+//go:embed blacklist.bin
+var blacklistBin []byte
+
+func init() {
+	embeddedBlacklist = blacklistBin
+}
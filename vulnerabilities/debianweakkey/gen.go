@@ -0,0 +1,39 @@
+//go:build ignore
+
+// gen packs the blacklist files of a directory into the binary form
+// compiled in with the weakkeys_embed build tag.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jsandas/tls-vuln-checker/vulnerabilities/debianweakkey"
+)
+
+func main() {
+	in := flag.String("in", "resources/weakkeys", "directory holding the blacklist files")
+	out := flag.String("out", "blacklist.bin", "file to write")
+	flag.Parse()
+
+	b, err := debianweakkey.LoadBlacklist(os.DirFS(*in))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	data, err := b.MarshalBinary()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	err = os.WriteFile(*out, data, 0o600)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s: %d fingerprints, %d bytes\n", *out, b.Len(), len(data))
+}
@@ -0,0 +1,363 @@
+package debianweakkey
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+)
+
+// ErrNoKey is returned when the input holds no public key to check.
+var ErrNoKey = errors.New("debianweakkey: no key found")
+
+// KeyResult is the verdict on one key.
+type KeyResult struct {
+	// Subject is the subject of the certificate or request holding the
+	// key, empty for a bare key.
+	Subject string `json:"subject,omitempty"`
+	// Algorithm is the key type, such as RSA or ECDSA.
+	Algorithm string `json:"algorithm"`
+	KeySize   int    `json:"keysize,omitempty"`
+	// Fingerprint is the hex SHA-256 of the key's DER
+	// SubjectPublicKeyInfo, or for keys read from OpenSSH files the
+	// SHA256: fingerprint ssh-keygen -l shows.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Line is the line of an OpenSSH file the key was read from.
+	Line       int            `json:"line,omitempty"`
+	Vulnerable checker.Status `json:"vulnerable"`
+	// Reason explains a not applicable verdict.
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// keyInfo is a public key with where it came from.
+type keyInfo struct {
+	subject string
+	pub     crypto.PublicKey
+	// spki is the DER SubjectPublicKeyInfo, when already at hand.
+	spki []byte
+	// algorithm names key types that pub does not hold.
+	algorithm string
+	dsa       *dsaKey
+	// ssh is set for keys read in the OpenSSH format, kept in blob.
+	ssh  bool
+	blob []byte
+}
+
+// keySet collects the results of a file holding several keys.
+type keySet struct {
+	results []KeyResult
+	errs    []error
+	status  checker.Status
+	// reason is the reason of the result status was taken from.
+	reason string
+}
+
+// CheckCertificate checks the public key of cert.
+func (w *DebianWeakKey) CheckCertificate(ctx context.Context, cert *x509.Certificate) (KeyResult, error) {
+	return w.checkKey(ctx, keyInfo{
+		subject: cert.Subject.String(),
+		pub:     cert.PublicKey,
+		spki:    cert.RawSubjectPublicKeyInfo,
+	})
+}
+
+// CheckPublicKey checks pub. Only RSA and DSA keys can be weak; other
+// types are reported as not applicable.
+func (w *DebianWeakKey) CheckPublicKey(ctx context.Context, pub crypto.PublicKey) (KeyResult, error) {
+	return w.checkKey(ctx, keyInfo{pub: pub})
+}
+
+// CheckDER checks the key in a DER certificate, certificate request,
+// public key (PKIX or PKCS #1) or private key (PKCS #1, PKCS #8, or
+// OpenSSL's own EC and DSA forms).
+func (w *DebianWeakKey) CheckDER(ctx context.Context, der []byte) (KeyResult, error) {
+	key, err := parseDER(der)
+	if err != nil {
+		w.Vulnerable = checker.StatusError
+		w.Reason = ""
+
+		return KeyResult{Vulnerable: checker.StatusError, Error: err.Error()}, err
+	}
+
+	return w.checkKey(ctx, key)
+}
+
+// CheckPEM checks every key in a PEM bundle, in order: certificates,
+// certificate requests, public and private keys. Other blocks are
+// skipped. A block that cannot be parsed or checked gets an error
+// result and the errors are joined; ErrNoKey is returned when there is
+// no key at all. Vulnerable is set to the worst verdict.
+func (w *DebianWeakKey) CheckPEM(ctx context.Context, data []byte) ([]KeyResult, error) {
+	var set keySet
+
+	for n := 1; ; n++ {
+		var block *pem.Block
+
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		key, ok, err := parseBlock(block)
+		if !ok {
+			continue
+		}
+
+		res := KeyResult{Vulnerable: checker.StatusError}
+		if err == nil {
+			res, err = w.checkKey(ctx, key)
+		}
+
+		set.add(res, err, fmt.Sprintf("PEM block %d (%s)", n, block.Type))
+	}
+
+	return set.finish(w)
+}
+
+// checkKey looks up key and sets Vulnerable to its verdict. RSA keys
+// are looked up in the openssl-blacklist lists, DSA keys and keys read
+// from OpenSSH files in the openssh-blacklist lists.
+func (w *DebianWeakKey) checkKey(ctx context.Context, key keyInfo) (KeyResult, error) {
+	switch {
+	case key.dsa != nil:
+	case key.spki != nil:
+		key.dsa, _ = parseDSASPKI(key.spki)
+	default:
+		key.dsa = publicDSA(key.pub)
+		if key.dsa != nil {
+			key.spki, _ = dsaSPKI(key.dsa)
+		}
+	}
+
+	res := KeyResult{
+		Subject:     key.subject,
+		Algorithm:   key.algorithm,
+		KeySize:     keySize(key.pub),
+		Fingerprint: keyFingerprint(key),
+	}
+
+	if res.Algorithm == "" {
+		res.Algorithm = keyAlgorithm(key.pub)
+	}
+
+	if key.dsa != nil {
+		res.Algorithm = x509.DSA.String()
+		res.KeySize = key.dsa.P.BitLen()
+	}
+
+	var err error
+
+	rsaPub, isRSA := key.pub.(*rsa.PublicKey)
+
+	switch {
+	case key.dsa != nil || isRSA && key.ssh:
+		err = w.checkSSH(ctx, res.Algorithm, res.KeySize, sshBlob(key))
+	case isRSA:
+		err = w.CheckContext(ctx, res.KeySize, rsaPub.N.Text(16))
+	case key.pub == nil && key.algorithm == "":
+		err = ErrNoKey
+		w.Vulnerable = checker.StatusError
+		w.Reason = ""
+	default:
+		w.Vulnerable = checker.StatusNotApplicable
+		w.Reason = ""
+	}
+
+	res.Vulnerable = w.Vulnerable
+	res.Reason = w.Reason
+
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	return res, err
+}
+
+// add records the result of the key found at where.
+func (s *keySet) add(res KeyResult, err error, where string) {
+	if err != nil {
+		res.Error = err.Error()
+		s.errs = append(s.errs, fmt.Errorf("%s: %w", where, err))
+	}
+
+	s.results = append(s.results, res)
+
+	if worse(s.status, res.Vulnerable) != s.status {
+		s.status = res.Vulnerable
+		s.reason = res.Reason
+	}
+}
+
+// finish sets w's verdict and its reason to the worst one collected and
+// returns the results with the errors joined, or ErrNoKey if there were
+// no keys.
+func (s *keySet) finish(w *DebianWeakKey) ([]KeyResult, error) {
+	if len(s.results) == 0 {
+		w.Vulnerable = checker.StatusError
+		w.Reason = ""
+
+		return nil, ErrNoKey
+	}
+
+	w.Vulnerable = s.status
+	w.Reason = s.reason
+
+	return s.results, errors.Join(s.errs...)
+}
+
+// keyFingerprint returns the fingerprint reported for key.
+func keyFingerprint(key keyInfo) string {
+	if key.ssh {
+		return sshSHA256(key.blob)
+	}
+
+	spki := key.spki
+	if spki == nil {
+		spki, _ = x509.MarshalPKIXPublicKey(key.pub)
+	}
+
+	if spki == nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(spki)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// parseBlock extracts the key from a PEM block. ok is false for block
+// types that hold no key.
+func parseBlock(block *pem.Block) (key keyInfo, ok bool, err error) {
+	switch block.Type {
+	case "CERTIFICATE", "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST",
+		"PUBLIC KEY", "RSA PUBLIC KEY", "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY", "DSA PRIVATE KEY":
+	default:
+		return keyInfo{}, false, nil
+	}
+
+	if _, encrypted := block.Headers["DEK-Info"]; encrypted {
+		return keyInfo{}, true, errors.New("encrypted PEM block")
+	}
+
+	key, err = parseDER(block.Bytes)
+
+	return key, true, err
+}
+
+// parseDER extracts the key from the DER forms accepted by CheckDER.
+func parseDER(der []byte) (keyInfo, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err == nil {
+		return keyInfo{
+			subject: cert.Subject.String(),
+			pub:     cert.PublicKey,
+			spki:    cert.RawSubjectPublicKeyInfo,
+		}, nil
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		return keyInfo{
+			subject: csr.Subject.String(),
+			pub:     csr.PublicKey,
+			spki:    csr.RawSubjectPublicKeyInfo,
+		}, nil
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err == nil {
+		return keyInfo{pub: pub, spki: der}, nil
+	}
+
+	rsaPub, err := x509.ParsePKCS1PublicKey(der)
+	if err == nil {
+		return keyInfo{pub: rsaPub}, nil
+	}
+
+	rsaPriv, err := x509.ParsePKCS1PrivateKey(der)
+	if err == nil {
+		return keyInfo{pub: &rsaPriv.PublicKey}, nil
+	}
+
+	priv, err := x509.ParsePKCS8PrivateKey(der)
+	if err == nil {
+		if signer, ok := priv.(crypto.Signer); ok {
+			return keyInfo{pub: signer.Public()}, nil
+		}
+	}
+
+	ecPriv, err := x509.ParseECPrivateKey(der)
+	if err == nil {
+		return keyInfo{pub: &ecPriv.PublicKey}, nil
+	}
+
+	// crypto/x509 does not parse DSA private keys
+	dsa, ok := parseDSAPrivateKey(der)
+	if ok {
+		spki, err := dsaSPKI(dsa)
+		if err != nil {
+			return keyInfo{}, err
+		}
+
+		return keyInfo{dsa: dsa, spki: spki}, nil
+	}
+
+	return keyInfo{}, ErrNoKey
+}
+
+// keyAlgorithm names the type of pub.
+func keyAlgorithm(pub crypto.PublicKey) string {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return x509.RSA.String()
+	case *ecdsa.PublicKey:
+		return x509.ECDSA.String()
+	case ed25519.PublicKey:
+		return x509.Ed25519.String()
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%T", pub)
+	}
+}
+
+// keySize returns the size of pub in bits, or 0 if unknown.
+func keySize(pub crypto.PublicKey) int {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		return pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 8 * len(pub)
+	default:
+		return 0
+	}
+}
+
+// statusRank orders verdicts from least to most severe.
+var statusRank = map[checker.Status]int{
+	checker.StatusNotApplicable: 1,
+	checker.StatusNotVulnerable: 2,
+	checker.StatusError:         3,
+	checker.StatusVulnerable:    4,
+}
+
+// worse returns the more severe of a and b.
+func worse(a, b checker.Status) checker.Status {
+	if statusRank[b] > statusRank[a] {
+		return b
+	}
+
+	return a
+}
@@ -0,0 +1,216 @@
+package debianweakkey
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/internal/handshake"
+	"github.com/jsandas/tls-vuln-checker/internal/netutil"
+	"github.com/jsandas/tls-vuln-checker/internal/starttls"
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
+)
+
+// defaultTimeouts apply to any phase not set in DebianWeakKey.Timeouts.
+var defaultTimeouts = checker.Timeouts{
+	Dial:      3 * time.Second,
+	StartTLS:  3 * time.Second,
+	Handshake: 5 * time.Second,
+	Write:     2 * time.Second,
+}
+
+// chainSuites are offered when fetching a chain: every key exchange
+// authenticated by a certificate, DSS suites included, since the keys
+// of 2008 were often DSA or served over old suites only.
+var chainSuites = []uint16{
+	0xc02b, 0xc02f, 0xc02c, 0xc030, 0xc009, 0xc013, 0xc00a, 0xc014, // ECDHE
+	0x009c, 0x009d, 0x002f, 0x0035, 0x000a, 0x0005, 0x0004, // RSA
+	0x009e, 0x0033, 0x0039, 0x0016, // DHE_RSA
+	0x00a2, 0x0032, 0x0038, 0x0013, // DHE_DSS
+}
+
+// chainSignatureAlgorithms adds the DSA schemes to the usual RSA and
+// ECDSA ones.
+var chainSignatureAlgorithms = []uint16{0x0401, 0x0403, 0x0402, 0x0501, 0x0503, 0x0201, 0x0203, 0x0202}
+
+// ChainResult is the verdict on the key of one certificate in a chain.
+type ChainResult struct {
+	KeyResult
+
+	// Position is the index of the certificate in the chain the server
+	// sent, 0 for the leaf.
+	Position int `json:"position"`
+}
+
+// CheckHost fetches the certificate chain served on host:port and checks
+// the key of every certificate in it, leaf first, recording each in
+// Chain. Vulnerable is set to the worst verdict.
+//
+// The chain is read from the server's first flight to a TLS 1.2
+// ClientHello, which also reaches SSLv3 servers and DSA certificates;
+// a server refusing it is asked again over TLS 1.3. Certificates are
+// not verified. Every phase is bounded by its entry in Timeouts.
+func (w *DebianWeakKey) CheckHost(ctx context.Context, host, port string) error {
+	w.Chain = nil
+	w.Reason = ""
+
+	certs, err := w.fetchChain(ctx, host, port)
+	if err != nil {
+		w.Vulnerable = checker.StatusError
+		return err
+	}
+
+	var set keySet
+
+	for i, der := range certs {
+		res := KeyResult{Vulnerable: checker.StatusError}
+
+		cert, err := x509.ParseCertificate(der)
+		if err == nil {
+			res, err = w.CheckCertificate(ctx, cert)
+		}
+
+		set.add(res, err, fmt.Sprintf("certificate %d", i))
+		w.Chain = append(w.Chain, ChainResult{KeyResult: set.results[i], Position: i})
+	}
+
+	_, err = set.finish(w)
+
+	return err
+}
+
+// fetchChain returns the DER certificates the server sends, leaf first.
+func (w *DebianWeakKey) fetchChain(ctx context.Context, host, port string) ([][]byte, error) {
+	certs, err := w.withConn(ctx, host, port, readChain)
+
+	if ctx.Err() == nil && tlsrecord.Refused(err) {
+		// the server may only speak TLS 1.3, which encrypts the chain
+		var err13 error
+
+		certs, err13 = w.withConn(ctx, host, port, readChainTLS13)
+		if err13 != nil {
+			err = fmt.Errorf("%w; over TLS 1.3: %w", err, err13)
+		} else {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s sent no certificate", net.JoinHostPort(host, port))
+	}
+
+	return certs, nil
+}
+
+// withConn dials host:port, negotiates STARTTLS and passes the
+// connection to read along with the server name to announce.
+func (w *DebianWeakKey) withConn(ctx context.Context, host, port string,
+	read func(ctx context.Context, conn net.Conn, serverName string, timeouts checker.Timeouts) ([][]byte, error),
+) ([][]byte, error) {
+	timeouts := w.Timeouts.WithDefaults(defaultTimeouts)
+
+	conn, err := netutil.Dial(ctx, w.Dialer, "tcp", net.JoinHostPort(host, port), timeouts.Dial)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stop := netutil.AbortOnDone(ctx, conn)
+	defer stop()
+
+	serverName := w.ServerName
+	if serverName == "" {
+		serverName = checker.DefaultServerName(host)
+	}
+
+	w.StartTLS = starttls.Resolve(w.Protocol, port)
+
+	err = starttls.Start(ctx, conn, w.StartTLS, host, serverName, timeouts.StartTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	return read(ctx, conn, serverName, timeouts)
+}
+
+// readChain sends a TLS 1.2 ClientHello and reads the Certificate
+// message of the reply.
+func readChain(ctx context.Context, conn net.Conn, serverName string,
+	timeouts checker.Timeouts,
+) ([][]byte, error) {
+	hello := &handshake.ClientHello{
+		RecordVersion:       tlsrecord.VersionTLS10,
+		Version:             tlsrecord.VersionTLS12,
+		CipherSuites:        chainSuites,
+		ServerName:          serverName,
+		SupportedGroups:     []uint16{handshake.GroupX25519, handshake.GroupSecp256r1, handshake.GroupSecp384r1},
+		ECPointFormats:      []uint8{handshake.PointFormatUncompressed},
+		SignatureAlgorithms: chainSignatureAlgorithms,
+		RenegotiationInfo:   true,
+	}
+
+	record, err := hello.Record()
+	if err != nil {
+		return nil, err
+	}
+
+	err = netutil.Write(ctx, conn, record, timeouts.Write)
+	if err != nil {
+		return nil, err
+	}
+
+	err = netutil.SetReadDeadline(ctx, conn, timeouts.Handshake)
+	if err != nil {
+		return nil, err
+	}
+
+	// the flight may break off after the Certificate, which is all
+	// that is needed
+	flight, err := handshake.ReadServerFlight(tlsrecord.NewReader(conn))
+	if flight.Certificate != nil {
+		return flight.Certificate.Certificates, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// readChainTLS13 completes a TLS 1.3 handshake with crypto/tls and
+// returns the chain it received.
+func readChainTLS13(ctx context.Context, conn net.Conn, serverName string,
+	timeouts checker.Timeouts,
+) ([][]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Handshake)
+	defer cancel()
+
+	// The certificates are inspected, not trusted, so verification is
+	// deliberately skipped.
+	tlsConn := tls.Client(conn, &tls.Config{ // #nosec G402
+		ServerName:         serverName,
+		MinVersion:         tls.VersionTLS13,
+		InsecureSkipVerify: true,
+	})
+
+	err := tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs [][]byte
+	for _, cert := range tlsConn.ConnectionState().PeerCertificates {
+		certs = append(certs, cert.Raw)
+	}
+
+	return certs, nil
+}
@@ -0,0 +1,467 @@
+package debianweakkey
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/dsa"
+	"crypto/md5" /* #nosec */
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+)
+
+// OpenSSH key type names.
+const (
+	sshRSA     = "ssh-rsa"
+	sshDSS     = "ssh-dss"
+	sshEd25519 = "ssh-ed25519"
+	sshECDSA   = "ecdsa-sha2-"
+)
+
+// maxSSHLine bounds a line of an authorized_keys or known_hosts file.
+const maxSSHLine = 1 << 20
+
+// oidDSA identifies DSA keys in a SubjectPublicKeyInfo.
+var oidDSA = asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 1}
+
+// maxDSABits bounds the size of the DSA keys decoded, as the public half
+// of a PKCS #8 key is computed from it.
+const maxDSABits = 8192
+
+// dsaKey is a DSA public key. crypto/dsa is deprecated, so DSA keys are
+// decoded here from their SubjectPublicKeyInfo, private key or OpenSSH
+// blob, and the crypto/dsa keys crypto/x509 still returns are converted.
+type dsaKey struct {
+	P, Q, G, Y *big.Int
+}
+
+// CheckAuthorizedKeys checks every key in an OpenSSH authorized_keys
+// file against the openssh-blacklist lists. Options before the key are
+// skipped and the comment after it is reported as the subject. Results
+// and errors are collected as by CheckPEM.
+func (w *DebianWeakKey) CheckAuthorizedKeys(ctx context.Context, data []byte) ([]KeyResult, error) {
+	return w.checkSSHFile(ctx, data, false)
+}
+
+// CheckKnownHosts checks every key in an OpenSSH known_hosts file like
+// CheckAuthorizedKeys, reporting the host patterns as the subject.
+func (w *DebianWeakKey) CheckKnownHosts(ctx context.Context, data []byte) ([]KeyResult, error) {
+	return w.checkSSHFile(ctx, data, true)
+}
+
+// CheckSSHPublicKey checks an OpenSSH public key in its wire format, the
+// base64-decoded second field of an authorized_keys line.
+func (w *DebianWeakKey) CheckSSHPublicKey(ctx context.Context, blob []byte) (KeyResult, error) {
+	key, err := parseSSHKey(blob)
+	if err != nil {
+		w.Vulnerable = checker.StatusError
+		w.Reason = ""
+
+		return KeyResult{Vulnerable: checker.StatusError, Error: err.Error()}, err
+	}
+
+	return w.checkKey(ctx, key)
+}
+
+// checkSSH looks up an OpenSSH key blob in the openssh-blacklist list
+// for its type and size. Sizes without a list are reported as not
+// applicable, with the reason set.
+func (w *DebianWeakKey) checkSSH(ctx context.Context, algorithm string, bits int, blob []byte) error {
+	w.Vulnerable = checker.StatusNotVulnerable
+	w.Reason = ""
+
+	bl, err := w.blacklist(ctx)
+	if err != nil {
+		w.Vulnerable = checker.StatusError
+		return err
+	}
+
+	if !bl.hasPrefix(sshDir + "/") {
+		w.Vulnerable = checker.StatusError
+		return fmt.Errorf("%w: no OpenSSH lists in %s", ErrNoBlacklist, sshDir)
+	}
+
+	found, listed := bl.contains(sshDir+"/"+algorithm, bits, sshFingerprint(blob))
+
+	switch {
+	case !listed:
+		w.Vulnerable = checker.StatusNotApplicable
+		w.Reason = reasonUncommonKey
+	case found:
+		w.Vulnerable = checker.StatusVulnerable
+	}
+
+	return nil
+}
+
+func (w *DebianWeakKey) checkSSHFile(ctx context.Context, data []byte, knownHosts bool) ([]KeyResult, error) {
+	var set keySet
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, maxSSHLine)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		key, err := parseSSHLine(line, knownHosts)
+
+		res := KeyResult{Vulnerable: checker.StatusError}
+		if err == nil {
+			res, err = w.checkKey(ctx, key)
+		}
+
+		res.Line = n
+		set.add(res, err, fmt.Sprintf("line %d", n))
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		w.Vulnerable = checker.StatusError
+		w.Reason = ""
+
+		return nil, err
+	}
+
+	return set.finish(w)
+}
+
+// parseSSHLine finds the key on an authorized_keys or known_hosts line:
+// the first field naming a key type that is followed by a blob of that
+// type.
+func parseSSHLine(line string, knownHosts bool) (keyInfo, error) {
+	fields := sshFields(line)
+
+	for i := 0; i+1 < len(fields); i++ {
+		blob, err := base64.StdEncoding.DecodeString(fields[i+1])
+		if err != nil {
+			continue
+		}
+
+		typ, _, ok := sshString(blob)
+		if !ok || string(typ) != fields[i] {
+			continue
+		}
+
+		key, err := parseSSHKey(blob)
+		if err != nil {
+			return keyInfo{}, err
+		}
+
+		if knownHosts {
+			if i > 0 {
+				key.subject = fields[i-1]
+			}
+		} else {
+			key.subject = strings.Join(fields[i+2:], " ")
+		}
+
+		return key, nil
+	}
+
+	return keyInfo{}, ErrNoKey
+}
+
+// sshFields splits line on spaces and tabs outside double quotes, which
+// may enclose spaces in authorized_keys options.
+func sshFields(line string) []string {
+	var (
+		fields []string
+		quoted bool
+		start  = -1
+	)
+
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case (c == ' ' || c == '\t') && !quoted:
+			if start >= 0 {
+				fields = append(fields, line[start:i])
+				start = -1
+			}
+
+			continue
+		}
+
+		if start < 0 {
+			start = i
+		}
+	}
+
+	if start >= 0 {
+		fields = append(fields, line[start:])
+	}
+
+	return fields
+}
+
+// parseSSHKey decodes an OpenSSH public key blob. RSA and DSA keys are
+// decoded; other types are only named, as the Debian bug predates them.
+func parseSSHKey(blob []byte) (keyInfo, error) {
+	typ, rest, ok := sshString(blob)
+	if !ok {
+		return keyInfo{}, ErrNoKey
+	}
+
+	key := keyInfo{ssh: true, blob: blob}
+
+	switch name := string(typ); {
+	case name == sshRSA:
+		var e, n *big.Int
+
+		rest, ok = sshMPInts(rest, &e, &n)
+		if !ok || len(rest) != 0 || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return keyInfo{}, fmt.Errorf("malformed %s key", name)
+		}
+
+		key.pub = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case name == sshDSS:
+		d := &dsaKey{}
+
+		rest, ok = sshMPInts(rest, &d.P, &d.Q, &d.G, &d.Y)
+		if !ok || len(rest) != 0 {
+			return keyInfo{}, fmt.Errorf("malformed %s key", name)
+		}
+
+		key.dsa = d
+	case name == sshEd25519:
+		key.algorithm = "Ed25519"
+	case strings.HasPrefix(name, sshECDSA):
+		key.algorithm = "ECDSA"
+	default:
+		key.algorithm = name
+	}
+
+	return key, nil
+}
+
+// sshString reads a uint32 length-prefixed string.
+func sshString(b []byte) (s, rest []byte, ok bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+
+	n := binary.BigEndian.Uint32(b)
+	if uint64(n) > uint64(len(b)-4) {
+		return nil, nil, false
+	}
+
+	return b[4 : 4+n], b[4+n:], true
+}
+
+// sshMPInts reads non-negative mpints into ints.
+func sshMPInts(b []byte, ints ...**big.Int) ([]byte, bool) {
+	for _, v := range ints {
+		s, rest, ok := sshString(b)
+		if !ok || len(s) > 0 && s[0]&0x80 != 0 {
+			return nil, false
+		}
+
+		*v = new(big.Int).SetBytes(s)
+		b = rest
+	}
+
+	return b, true
+}
+
+// sshBlob encodes key in the OpenSSH wire format hashed by the
+// openssh-blacklist lists.
+func sshBlob(key keyInfo) []byte {
+	if key.blob != nil {
+		return key.blob
+	}
+
+	var blob []byte
+
+	switch {
+	case key.dsa != nil:
+		blob = appendSSHString(blob, []byte(sshDSS))
+		for _, v := range []*big.Int{key.dsa.P, key.dsa.Q, key.dsa.G, key.dsa.Y} {
+			blob = appendMPInt(blob, v)
+		}
+	default:
+		pub, ok := key.pub.(*rsa.PublicKey)
+		if !ok {
+			return nil
+		}
+
+		blob = appendSSHString(blob, []byte(sshRSA))
+		blob = appendMPInt(blob, big.NewInt(int64(pub.E)))
+		blob = appendMPInt(blob, pub.N)
+	}
+
+	return blob
+}
+
+func appendSSHString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s))) // #nosec G115
+
+	return append(b, s...)
+}
+
+// appendMPInt appends v, which must not be negative, as an mpint.
+func appendMPInt(b []byte, v *big.Int) []byte {
+	s := v.Bytes()
+	if len(s) > 0 && s[0]&0x80 != 0 {
+		s = append([]byte{0}, s...)
+	}
+
+	return appendSSHString(b, s)
+}
+
+// sshFingerprint returns the key's OpenSSH MD5 fingerprint as listed in
+// openssh-blacklist: its last 80 bits.
+func sshFingerprint(blob []byte) fingerprint {
+	sum := md5.Sum(blob) /* #nosec */
+
+	return fingerprint(sum[len(sum)-fingerprintLen:])
+}
+
+// sshSHA256 formats the fingerprint OpenSSH shows for blob.
+func sshSHA256(blob []byte) string {
+	sum := sha256.Sum256(blob)
+
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// parseDSASPKI decodes a DSA SubjectPublicKeyInfo.
+func parseDSASPKI(spki []byte) (*dsaKey, bool) {
+	var info struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+
+	rest, err := asn1.Unmarshal(spki, &info)
+	if err != nil || len(rest) != 0 || !info.Algorithm.Algorithm.Equal(oidDSA) {
+		return nil, false
+	}
+
+	var params struct {
+		P, Q, G *big.Int
+	}
+
+	_, err = asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params)
+	if err != nil {
+		return nil, false
+	}
+
+	key := &dsaKey{P: params.P, Q: params.Q, G: params.G}
+
+	_, err = asn1.Unmarshal(info.PublicKey.RightAlign(), &key.Y)
+	if err != nil || key.P.Sign() <= 0 || key.Y.Sign() <= 0 {
+		return nil, false
+	}
+
+	return key, true
+}
+
+// publicDSA converts a crypto/dsa public key, as crypto/x509 returns
+// for DSA certificates, to a dsaKey. It returns nil for other keys.
+func publicDSA(pub crypto.PublicKey) *dsaKey {
+	key, ok := pub.(*dsa.PublicKey)
+	if !ok || key.P == nil || key.Q == nil || key.G == nil || key.Y == nil {
+		return nil
+	}
+
+	return &dsaKey{P: key.P, Q: key.Q, G: key.G, Y: key.Y}
+}
+
+// dsaSPKI encodes key as a SubjectPublicKeyInfo.
+func dsaSPKI(key *dsaKey) ([]byte, error) {
+	params, err := asn1.Marshal(struct{ P, Q, G *big.Int }{key.P, key.Q, key.G})
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := asn1.Marshal(key.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidDSA, Parameters: asn1.RawValue{FullBytes: params}},
+		PublicKey: asn1.BitString{Bytes: y, BitLength: 8 * len(y)},
+	})
+}
+
+// parseDSAPrivateKey decodes the public half of a DSA private key, in
+// the OpenSSL form of a DSA PRIVATE KEY block or in PKCS #8.
+func parseDSAPrivateKey(der []byte) (*dsaKey, bool) {
+	var openssl struct {
+		Version       int
+		P, Q, G, Y, X *big.Int
+	}
+
+	rest, err := asn1.Unmarshal(der, &openssl)
+	if err == nil && len(rest) == 0 && openssl.Version == 0 {
+		key := &dsaKey{P: openssl.P, Q: openssl.Q, G: openssl.G, Y: openssl.Y}
+
+		// y = g^x mod p tells the key apart from other SEQUENCEs of
+		// integers, such as a PKCS #1 RSA key
+		return key, validDSA(key) && openssl.X.Sign() > 0 && new(big.Int).Exp(key.G, openssl.X, key.P).Cmp(key.Y) == 0
+	}
+
+	var pkcs8 struct {
+		Version    int
+		Algorithm  pkix.AlgorithmIdentifier
+		PrivateKey []byte
+	}
+
+	rest, err = asn1.Unmarshal(der, &pkcs8)
+	if err != nil || len(rest) != 0 || !pkcs8.Algorithm.Algorithm.Equal(oidDSA) {
+		return nil, false
+	}
+
+	var params struct {
+		P, Q, G *big.Int
+	}
+
+	_, err = asn1.Unmarshal(pkcs8.Algorithm.Parameters.FullBytes, &params)
+	if err != nil {
+		return nil, false
+	}
+
+	var x *big.Int
+
+	_, err = asn1.Unmarshal(pkcs8.PrivateKey, &x)
+	if err != nil || x.Sign() <= 0 {
+		return nil, false
+	}
+
+	key := &dsaKey{P: params.P, Q: params.Q, G: params.G, Y: big.NewInt(1)}
+	if !validDSA(key) {
+		return nil, false
+	}
+
+	key.Y = new(big.Int).Exp(key.G, x, key.P)
+
+	return key, true
+}
+
+// validDSA reports whether the parameters of key are positive and of a
+// size that can be handled.
+func validDSA(key *dsaKey) bool {
+	for _, v := range []*big.Int{key.P, key.Q, key.G, key.Y} {
+		if v == nil || v.Sign() <= 0 {
+			return false
+		}
+	}
+
+	return key.P.BitLen() <= maxDSABits
+}
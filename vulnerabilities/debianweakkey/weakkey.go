@@ -1,13 +1,13 @@
 package debianweakkey
 
 import (
-	"bufio"
+	"context"
 	"crypto/sha1" /* #nosec */
-	"encoding/hex"
 	"fmt"
-	"os"
-	"strconv"
+	"slices"
 	"strings"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
 )
 
 var commonKeySizes = []int{512, 1024, 2048, 4096}
@@ -17,88 +17,96 @@ var commonKeySizes = []int{512, 1024, 2048, 4096}
 	This check compares the sha1 hash of the certificate modulus
 	a list of known weak keys based on keysize
 
-	Note: because this require referencing binary files a hack
-	was added to detect if running locally which overrides the
-	value of blpath
+	The blacklists are indexed in memory once, from WEAKKEY_PATH,
+	resources/weakkeys or a copy compiled in with the weakkeys_embed
+	build tag, so a lookup is a single hash probe.
 */
 
-const (
-	notVulnerable = "no"
-	vulnerable    = "yes"
-	uncommonKey   = "uncommonKey"
-	testFailed    = "error"
-)
+// reasonUncommonKey explains a not applicable verdict on a key whose
+// size has no blacklist and which therefore cannot be checked.
+const reasonUncommonKey = "no blacklist for key size"
 
 type DebianWeakKey struct {
-	Vulnerable string `json:"vulnerable"`
+	Vulnerable checker.Status `json:"vulnerable"`
+	// Reason explains a not applicable verdict, such as a key size
+	// without a blacklist.
+	Reason string `json:"reason,omitempty"`
+	// Chain holds the verdict on every certificate fetched by
+	// CheckHost, leaf first.
+	Chain []ChainResult `json:"chain,omitempty"`
+	// StartTLS is the protocol negotiated before TLS by CheckHost.
+	StartTLS checker.Protocol `json:"starttls,omitempty"`
+
+	// Blacklist is the index to search. Nil uses DefaultBlacklist.
+	Blacklist *Blacklist `json:"-"`
+	// Timeouts overrides the per-phase defaults used by CheckHost.
+	Timeouts checker.Timeouts `json:"-"`
+	// Dialer opens the connection to the target. Nil dials directly.
+	Dialer checker.Dialer `json:"-"`
+	// ServerName is sent in the server_name extension. Empty uses the
+	// host unless it is an IP address.
+	ServerName string `json:"-"`
+	// Protocol is negotiated with STARTTLS before the ClientHello.
+	// Empty infers it from the port.
+	Protocol checker.Protocol `json:"-"`
 }
 
 // WeakKey detects if key was generated with weak Debian openssl.
 func (w *DebianWeakKey) Check(keysize int, modulus string) error {
-	w.Vulnerable = notVulnerable
-
-	// only test if common keysize
-	var found bool
+	return w.CheckContext(context.Background(), keysize, modulus)
+}
 
-	for _, ks := range commonKeySizes {
-		if keysize == ks {
-			found = true
-		}
-	}
+// CheckContext is Check with a context, which is only consulted before
+// the lookup.
+func (w *DebianWeakKey) CheckContext(ctx context.Context, keysize int, modulus string) error {
+	w.Vulnerable = checker.StatusNotVulnerable
+	w.Reason = ""
 
-	if !found {
-		w.Vulnerable = uncommonKey
+	// only test if common keysize
+	if !slices.Contains(commonKeySizes, keysize) {
+		w.Vulnerable = checker.StatusNotApplicable
+		w.Reason = reasonUncommonKey
 		return nil
 	}
 
-	// blpath is the location of weakkeys blacklist files. Override with
-	// environment variable `WEAKKEY_PATH` when running tests or in CI.
-	blpath := "resources/weakkeys"
-	if v, ok := os.LookupEnv("WEAKKEY_PATH"); ok && v != "" {
-		blpath = v
+	bl, err := w.blacklist(ctx)
+	if err != nil {
+		w.Vulnerable = checker.StatusError
+		return err
 	}
 
-	mod := fmt.Sprintf("Modulus=%s\n", strings.ToUpper(modulus))
-	ks := strconv.Itoa(keysize)
-
-	// Compute SHA-1 of the modulus string. Use sha1.Sum to avoid extra
-	// allocations from streaming writer.
-	hsum := sha1.Sum([]byte(mod)) /* #nosec */
-	sh := hex.EncodeToString(hsum[:])
+	found, listed := bl.contains("RSA", keysize, rsaFingerprint(modulus))
+	if !listed {
+		w.Vulnerable = checker.StatusError
+		return fmt.Errorf("%w for RSA-%d", ErrNoBlacklist, keysize)
+	}
 
-	// Test overrides
-	// ks = "2048"
-	// sh = "24a319be7f63b8b46e9cd10d992069d592fe1766"
+	if found {
+		w.Vulnerable = checker.StatusVulnerable
+	}
 
-	// load weak key file - use DirFS to safely restrict path access
-	fsys := os.DirFS(blpath)
+	return nil
+}
 
-	f, err := fsys.Open("blacklist.RSA-" + ks)
+// blacklist returns the index to search, unless ctx is done.
+func (w *DebianWeakKey) blacklist(ctx context.Context) (*Blacklist, error) {
+	err := ctx.Err()
 	if err != nil {
-		w.Vulnerable = testFailed
-		return err
+		return nil, err
 	}
-	defer f.Close()
 
-	file := f
-
-	scanner := bufio.NewScanner(file)
-	// Compare against the last 20 hex characters of the SHA-1 (same
-	// format used by the blacklist files). Stop early when found.
-	target := sh[20:]
-
-	for scanner.Scan() {
-		if scanner.Text() == target {
-			w.Vulnerable = vulnerable
-			return nil
-		}
+	if w.Blacklist != nil {
+		return w.Blacklist, nil
 	}
 
-	err = scanner.Err()
-	if err != nil {
-		w.Vulnerable = testFailed
-		return err
-	}
+	return DefaultBlacklist()
+}
 
-	return nil
+// rsaFingerprint returns the part of the SHA-1 of "Modulus=<HEX>\n", as
+// printed by openssl rsa -modulus, that the blacklists keep.
+func rsaFingerprint(modulus string) fingerprint {
+	mod := fmt.Sprintf("Modulus=%s\n", strings.ToUpper(modulus))
+	sum := sha1.Sum([]byte(mod)) /* #nosec */
+
+	return fingerprint(sum[len(sum)-fingerprintLen:])
 }
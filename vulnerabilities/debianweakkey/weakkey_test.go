@@ -1,12 +1,31 @@
 package debianweakkey
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/internal/starttls/starttlstest"
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
 )
 
 const weak1024 = `
@@ -93,9 +112,7 @@ d7+0ksvbDUmlMMZRIppmHit25taSAGPxmprKhIs2U/39qbfsrXwp4Q==
 -----END CERTIFICATE-----`
 
 func TestWeakKeyBad1024(t *testing.T) {
-	var r DebianWeakKey
-
-	os.Setenv("WEAKKEY_PATH", "../../resources/weakkeys")
+	r := DebianWeakKey{Blacklist: weakBlacklist(t)}
 
 	block, _ := pem.Decode([]byte(weak1024))
 	if block == nil {
@@ -112,15 +129,13 @@ func TestWeakKeyBad1024(t *testing.T) {
 	mod := fmt.Sprintf("%x", pk.N)
 	r.Check(ks, mod)
 
-	if r.Vulnerable != vulnerable {
-		t.Errorf("Did not detect weak key, got: %v, want: %v.", r.Vulnerable, vulnerable)
+	if r.Vulnerable != checker.StatusVulnerable {
+		t.Errorf("Did not detect weak key, got: %v, want: %v.", r.Vulnerable, checker.StatusVulnerable)
 	}
 }
 
 func TestWeakKeyBad2048(t *testing.T) {
-	var r DebianWeakKey
-
-	os.Setenv("WEAKKEY_PATH", "../../resources/weakkeys")
+	r := DebianWeakKey{Blacklist: weakBlacklist(t)}
 
 	block, _ := pem.Decode([]byte(weak2048))
 	if block == nil {
@@ -137,15 +152,13 @@ func TestWeakKeyBad2048(t *testing.T) {
 	mod := fmt.Sprintf("%x", pk.N)
 	r.Check(ks, mod)
 
-	if r.Vulnerable != vulnerable {
-		t.Errorf("Did not detect weak key, got: %v, want: %v.", r.Vulnerable, vulnerable)
+	if r.Vulnerable != checker.StatusVulnerable {
+		t.Errorf("Did not detect weak key, got: %v, want: %v.", r.Vulnerable, checker.StatusVulnerable)
 	}
 }
 
 func TestWeakKeyGood2048(t *testing.T) {
-	var r DebianWeakKey
-
-	os.Setenv("WEAKKEY_PATH", "../../resources/weakkeys")
+	r := DebianWeakKey{Blacklist: weakBlacklist(t)}
 
 	block, _ := pem.Decode([]byte(good2048))
 	if block == nil {
@@ -162,16 +175,14 @@ func TestWeakKeyGood2048(t *testing.T) {
 	mod := fmt.Sprintf("%x", pk.N)
 	r.Check(ks, mod)
 
-	if r.Vulnerable == vulnerable {
-		t.Errorf("Did not detect weak key, got: %v, want: %v.", r.Vulnerable, notVulnerable)
+	if r.Vulnerable != checker.StatusNotVulnerable {
+		t.Errorf("Did not detect weak key, got: %v, want: %v.", r.Vulnerable, checker.StatusNotVulnerable)
 	}
 }
 
 func TestWeakKeyUncommonKeySize(t *testing.T) {
 	var r DebianWeakKey
 
-	os.Setenv("WEAKKEY_PATH", "../../resources/weakkeys")
-
 	block, _ := pem.Decode([]byte(oddSize1784))
 	if block == nil {
 		panic("failed to parse certificate PEM")
@@ -187,18 +198,17 @@ func TestWeakKeyUncommonKeySize(t *testing.T) {
 	mod := fmt.Sprintf("%x", pk.N)
 	r.Check(ks, mod)
 
-	if r.Vulnerable != uncommonKey {
-		t.Errorf("wrong return, got: %v, want: %v.", r.Vulnerable, uncommonKey)
+	if r.Vulnerable != checker.StatusNotApplicable || r.Reason != reasonUncommonKey {
+		t.Errorf("wrong return, got: %v (%s), want: %v (%s).", r.Vulnerable, r.Reason,
+			checker.StatusNotApplicable, reasonUncommonKey)
 	}
 }
 
 func TestWeakKeyMissingKeyFile(t *testing.T) {
-	var r DebianWeakKey
+	r := DebianWeakKey{Blacklist: weakBlacklist(t)}
 
 	commonKeySizes = []int{512, 1024, 1784, 2048, 4096}
 
-	os.Setenv("WEAKKEY_PATH", "../../resources/weakkeys")
-
 	block, _ := pem.Decode([]byte(oddSize1784))
 	if block == nil {
 		panic("failed to parse certificate PEM")
@@ -218,3 +228,697 @@ func TestWeakKeyMissingKeyFile(t *testing.T) {
 		t.Errorf("Expected error for odd key size")
 	}
 }
+
+func TestWeakKeyCheckerUncommonKeySize(t *testing.T) {
+	c := &Checker{KeySize: 1000, Modulus: "C0FFEE"}
+
+	res := c.Check(context.Background(), checker.Target{})
+	if res.Status != checker.StatusNotApplicable || res.Err != nil {
+		t.Errorf("wrong return, got: %v/%v, want: %v.", res.Status, res.Err, checker.StatusNotApplicable)
+	}
+
+	if res.Evidence["reason"] != reasonUncommonKey {
+		t.Errorf("wrong reason, got: %q, want: %q.", res.Evidence["reason"], reasonUncommonKey)
+	}
+}
+
+// rsaKey returns the key size and hex modulus of the certificate in
+// pemCert.
+func rsaKey(t *testing.T, pemCert string) (int, string) {
+	t.Helper()
+
+	block, _ := pem.Decode([]byte(pemCert))
+	if block == nil {
+		t.Fatal("failed to parse certificate PEM")
+	}
+
+	crt, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("error parsing certificate for test: %v", err)
+	}
+
+	pk := crt.PublicKey.(*rsa.PublicKey)
+
+	return pk.Size() * 8, fmt.Sprintf("%x", pk.N)
+}
+
+// testBlacklist lists weak1024 and one unrelated 2048-bit fingerprint.
+func testBlacklist(t *testing.T) *Blacklist {
+	t.Helper()
+
+	_, mod := rsaKey(t, weak1024)
+	fp := rsaFingerprint(mod)
+
+	b, err := LoadBlacklist(fstest.MapFS{
+		"blacklist.RSA-1024": {Data: []byte("# weak keys\n\n" + hex.EncodeToString(fp[:]) + "\n")},
+		"blacklist.RSA-2048": {Data: []byte("0123456789abcdef0123\n")},
+		"README":             {Data: []byte("not a blacklist\n")},
+	})
+	if err != nil {
+		t.Fatalf("LoadBlacklist: %v", err)
+	}
+
+	return b
+}
+
+// weakBlacklist lists the keys of weak1024 and weak2048, so the checks
+// run without the files of make setup-local.
+func weakBlacklist(t *testing.T) *Blacklist {
+	t.Helper()
+
+	fsys := fstest.MapFS{}
+
+	for _, cert := range []string{weak1024, weak2048} {
+		size, mod := rsaKey(t, cert)
+		fp := rsaFingerprint(mod)
+		fsys[fmt.Sprintf("blacklist.RSA-%d", size)] = &fstest.MapFile{Data: []byte(hex.EncodeToString(fp[:]) + "\n")}
+	}
+
+	b, err := LoadBlacklist(fsys)
+	if err != nil {
+		t.Fatalf("LoadBlacklist: %v", err)
+	}
+
+	return b
+}
+
+func TestBlacklistLookup(t *testing.T) {
+	bl := testBlacklist(t)
+
+	if bl.Len() != 2 {
+		t.Errorf("wrong length, got: %d, want: 2.", bl.Len())
+	}
+
+	tests := []struct {
+		name    string
+		pemCert string
+		want    checker.Status
+	}{
+		{"weak", weak1024, checker.StatusVulnerable},
+		{"good", good2048, checker.StatusNotVulnerable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks, mod := rsaKey(t, tt.pemCert)
+			r := DebianWeakKey{Blacklist: bl}
+
+			err := r.Check(ks, mod)
+			if err != nil || r.Vulnerable != tt.want {
+				t.Errorf("wrong return, got: %v/%v, want: %v.", r.Vulnerable, err, tt.want)
+			}
+		})
+	}
+
+	r := DebianWeakKey{Blacklist: bl}
+
+	err := r.Check(1000, "C0FFEE")
+	if err != nil || r.Vulnerable != checker.StatusNotApplicable || r.Reason != reasonUncommonKey {
+		t.Errorf("wrong return for uncommon size, got: %v/%v (%s), want: %v.", r.Vulnerable, err, r.Reason,
+			checker.StatusNotApplicable)
+	}
+
+	err = r.Check(4096, "C0FFEE")
+	if !errors.Is(err, ErrNoBlacklist) || r.Vulnerable != checker.StatusError {
+		t.Errorf("wrong return for unlisted size, got: %v/%v, want: %v.", r.Vulnerable, err, ErrNoBlacklist)
+	}
+
+	ks, mod := rsaKey(t, weak1024)
+	c := &Checker{KeySize: ks, Modulus: mod, Blacklist: bl}
+
+	res := c.Check(context.Background(), checker.Target{})
+	if res.Status != checker.StatusVulnerable {
+		t.Errorf("wrong checker status, got: %v, want: %v.", res.Status, checker.StatusVulnerable)
+	}
+}
+
+func TestBlacklistBinary(t *testing.T) {
+	bl := testBlacklist(t)
+
+	data, err := bl.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	var got Blacklist
+
+	err = got.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+
+	ks, mod := rsaKey(t, weak1024)
+	r := DebianWeakKey{Blacklist: &got}
+
+	err = r.Check(ks, mod)
+	if err != nil || r.Vulnerable != checker.StatusVulnerable || got.Len() != bl.Len() {
+		t.Errorf("round trip lost entries, got: %v/%v with %d, want: %v with %d.",
+			r.Vulnerable, err, got.Len(), checker.StatusVulnerable, bl.Len())
+	}
+
+	for _, bad := range [][]byte{nil, []byte("DWK0"), data[:len(data)-1], append(data, 0)} {
+		if got.UnmarshalBinary(bad) == nil {
+			t.Errorf("UnmarshalBinary accepted %d corrupt bytes", len(bad))
+		}
+	}
+
+	// the longest key type MarshalBinary accepts
+	long := strings.Repeat("X", 0xff)
+	fp := fingerprint{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	bl = &Blacklist{lists: map[listKey]map[fingerprint]struct{}{
+		{algorithm: long, bits: 1024}: {fp: {}},
+	}}
+
+	data, err = bl.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	err = got.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf("UnmarshalBinary with a %d byte key type: %v", len(long), err)
+	}
+
+	if found, _ := got.contains(long, 1024, fp); !found {
+		t.Errorf("round trip lost the %d byte key type", len(long))
+	}
+}
+
+func TestLoadBlacklistErrors(t *testing.T) {
+	_, err := LoadBlacklist(fstest.MapFS{"README": {Data: []byte("x")}})
+	if !errors.Is(err, ErrNoBlacklist) {
+		t.Errorf("wrong error for empty directory, got: %v, want: %v.", err, ErrNoBlacklist)
+	}
+
+	_, err = LoadBlacklist(fstest.MapFS{"blacklist.RSA-1024": {Data: []byte("not hex\n")}})
+	if err == nil {
+		t.Errorf("Expected error for malformed blacklist")
+	}
+
+	_, err = LoadBlacklist(os.DirFS("does-not-exist"))
+	if err == nil {
+		t.Errorf("Expected error for missing directory")
+	}
+}
+
+// parseCert decodes the certificate in pemCert.
+func parseCert(t *testing.T, pemCert string) *x509.Certificate {
+	t.Helper()
+
+	block, _ := pem.Decode([]byte(pemCert))
+	if block == nil {
+		t.Fatal("failed to parse certificate PEM")
+	}
+
+	crt, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("error parsing certificate for test: %v", err)
+	}
+
+	return crt
+}
+
+func TestCheckCertificate(t *testing.T) {
+	w := DebianWeakKey{Blacklist: testBlacklist(t)}
+	crt := parseCert(t, weak1024)
+	sum := sha256.Sum256(crt.RawSubjectPublicKeyInfo)
+	fp := hex.EncodeToString(sum[:])
+
+	res, err := w.CheckCertificate(context.Background(), crt)
+	if err != nil {
+		t.Fatalf("CheckCertificate: %v", err)
+	}
+
+	if res.Vulnerable != checker.StatusVulnerable || w.Vulnerable != checker.StatusVulnerable {
+		t.Errorf("Did not detect weak key, got: %v/%v, want: %v.", res.Vulnerable, w.Vulnerable, checker.StatusVulnerable)
+	}
+
+	if !strings.Contains(res.Subject, "O=My Company Ltd") || res.Algorithm != "RSA" || res.KeySize != 1024 ||
+		res.Fingerprint != fp {
+		t.Errorf("wrong key details, got: %+v, want fingerprint %s.", res, fp)
+	}
+
+	// the bare key and its DER forms give the same verdict and fingerprint
+	pub := crt.PublicKey.(*rsa.PublicKey)
+
+	res, err = w.CheckPublicKey(context.Background(), pub)
+	if err != nil || res.Vulnerable != checker.StatusVulnerable || res.Fingerprint != fp || res.Subject != "" {
+		t.Errorf("CheckPublicKey: got %+v/%v, want fingerprint %s.", res, err, fp)
+	}
+
+	for name, der := range map[string][]byte{
+		"certificate": crt.Raw,
+		"pkix":        crt.RawSubjectPublicKeyInfo,
+		"pkcs1":       x509.MarshalPKCS1PublicKey(pub),
+	} {
+		res, err = w.CheckDER(context.Background(), der)
+		if err != nil || res.Vulnerable != checker.StatusVulnerable || res.Fingerprint != fp {
+			t.Errorf("CheckDER(%s): got %+v/%v, want fingerprint %s.", name, res, err, fp)
+		}
+	}
+
+	_, err = w.CheckDER(context.Background(), []byte("garbage"))
+	if !errors.Is(err, ErrNoKey) || w.Vulnerable != checker.StatusError {
+		t.Errorf("wrong return for garbage, got: %v/%v, want: %v.", w.Vulnerable, err, ErrNoKey)
+	}
+}
+
+func TestCheckPEM(t *testing.T) {
+	w := DebianWeakKey{Blacklist: testBlacklist(t)}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecDER, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := good2048 + "\n" + weak1024 + "\n" +
+		string(pem.EncodeToMemory(&pem.Block{Type: "DH PARAMETERS", Bytes: []byte{0x30, 0x00}})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: ecDER})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("garbage")}))
+
+	results, err := w.CheckPEM(context.Background(), []byte(bundle))
+	if !errors.Is(err, ErrNoKey) || !strings.Contains(err.Error(), "PEM block 5") {
+		t.Errorf("wrong error, got: %v, want: %v for block 5.", err, ErrNoKey)
+	}
+
+	want := []struct {
+		algorithm string
+		status    checker.Status
+	}{
+		{"RSA", checker.StatusNotVulnerable},
+		{"RSA", checker.StatusVulnerable},
+		{"ECDSA", checker.StatusNotApplicable},
+		{"", checker.StatusError},
+	}
+
+	if len(results) != len(want) {
+		t.Fatalf("wrong number of results, got: %d, want: %d.", len(results), len(want))
+	}
+
+	for i, res := range results {
+		if res.Algorithm != want[i].algorithm || res.Vulnerable != want[i].status {
+			t.Errorf("result %d: got %s/%v, want: %s/%v.", i, res.Algorithm, res.Vulnerable, want[i].algorithm, want[i].status)
+		}
+	}
+
+	if w.Vulnerable != checker.StatusVulnerable {
+		t.Errorf("wrong overall verdict, got: %v, want: %v.", w.Vulnerable, checker.StatusVulnerable)
+	}
+
+	_, err = w.CheckPEM(context.Background(), []byte("no PEM here"))
+	if !errors.Is(err, ErrNoKey) || w.Vulnerable != checker.StatusError {
+		t.Errorf("wrong return for empty bundle, got: %v/%v, want: %v.", w.Vulnerable, err, ErrNoKey)
+	}
+}
+
+// fixtureBlacklist loads testdata/weakkeys, which lists weak1024 and the
+// legacy@debian and root@etch keys of the OpenSSH fixtures.
+func fixtureBlacklist(t *testing.T) *Blacklist {
+	t.Helper()
+
+	b, err := LoadBlacklist(os.DirFS("testdata/weakkeys"))
+	if err != nil {
+		t.Fatalf("LoadBlacklist: %v", err)
+	}
+
+	return b
+}
+
+type sshWant struct {
+	line      int
+	subject   string
+	algorithm string
+	status    checker.Status
+}
+
+func checkSSHResults(t *testing.T, results []KeyResult, want []sshWant) {
+	t.Helper()
+
+	if len(results) != len(want) {
+		t.Fatalf("wrong number of results, got: %d, want: %d.", len(results), len(want))
+	}
+
+	for i, res := range results {
+		w := want[i]
+		if res.Line != w.line || res.Subject != w.subject || res.Algorithm != w.algorithm || res.Vulnerable != w.status {
+			t.Errorf("result %d: got %d/%q/%s/%v, want: %d/%q/%s/%v.", i,
+				res.Line, res.Subject, res.Algorithm, res.Vulnerable, w.line, w.subject, w.algorithm, w.status)
+		}
+	}
+}
+
+func TestCheckAuthorizedKeys(t *testing.T) {
+	data, err := os.ReadFile("testdata/authorized_keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := DebianWeakKey{Blacklist: fixtureBlacklist(t)}
+
+	results, err := w.CheckAuthorizedKeys(context.Background(), data)
+	if !errors.Is(err, ErrNoKey) || !strings.Contains(err.Error(), "line 8") {
+		t.Errorf("wrong error, got: %v, want: %v on line 8.", err, ErrNoKey)
+	}
+
+	checkSSHResults(t, results, []sshWant{
+		{3, "legacy@debian", "DSA", checker.StatusVulnerable},
+		{4, "root@etch", "RSA", checker.StatusVulnerable},
+		{5, "alice@laptop", "RSA", checker.StatusNotVulnerable},
+		{6, "bob@ci", "ECDSA", checker.StatusNotApplicable},
+		{7, "carol@desk", "Ed25519", checker.StatusNotApplicable},
+		{8, "", "", checker.StatusError},
+	})
+
+	if w.Vulnerable != checker.StatusVulnerable {
+		t.Errorf("wrong overall verdict, got: %v, want: %v.", w.Vulnerable, checker.StatusVulnerable)
+	}
+
+	// the same fingerprints as ssh-keygen -l
+	if fp := results[0].Fingerprint; fp != "SHA256:cpJzl5i5kqrVtlR2DCKb4KwOCouTobb7mxO2783TxBU" {
+		t.Errorf("wrong DSA fingerprint, got: %s.", fp)
+	}
+
+	if fp := results[1].Fingerprint; fp != "SHA256:mX8oWDQ6lHJhoL5A/ye300BWOczbRS7gsjeIlNB6QC4" {
+		t.Errorf("wrong RSA fingerprint, got: %s.", fp)
+	}
+
+	// DSA keys need the OpenSSH lists, which testBlacklist lacks
+	w.Blacklist = testBlacklist(t)
+
+	_, err = w.CheckAuthorizedKeys(context.Background(), data)
+	if !errors.Is(err, ErrNoBlacklist) {
+		t.Errorf("wrong error without OpenSSH lists, got: %v, want: %v.", err, ErrNoBlacklist)
+	}
+}
+
+func TestCheckKnownHosts(t *testing.T) {
+	data, err := os.ReadFile("testdata/known_hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := DebianWeakKey{Blacklist: fixtureBlacklist(t)}
+
+	results, err := w.CheckKnownHosts(context.Background(), data)
+	if err != nil {
+		t.Fatalf("CheckKnownHosts: %v", err)
+	}
+
+	checkSSHResults(t, results, []sshWant{
+		{2, "legacy.example.com,192.0.2.1", "DSA", checker.StatusVulnerable},
+		{3, "|1|JfKTdBh7rNbXkVAQCRp4OQoPfmI=|USECr3SWf1JUPsms5AqfD5QfxkM=", "RSA", checker.StatusNotVulnerable},
+		{4, "*.example.com", "RSA", checker.StatusVulnerable},
+		{5, "[git.example.com]:2222", "Ed25519", checker.StatusNotApplicable},
+	})
+}
+
+func TestCheckDSAKey(t *testing.T) {
+	data, err := os.ReadFile("testdata/dsa.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the fixture is the public half of legacy@debian
+	w := DebianWeakKey{Blacklist: fixtureBlacklist(t)}
+
+	results, err := w.CheckPEM(context.Background(), data)
+	if err != nil {
+		t.Fatalf("CheckPEM: %v", err)
+	}
+
+	res := results[0]
+	if res.Algorithm != "DSA" || res.KeySize != 1024 || res.Vulnerable != checker.StatusVulnerable {
+		t.Errorf("wrong result, got: %s/%d/%v, want: DSA/1024/%v.", res.Algorithm, res.KeySize, res.Vulnerable,
+			checker.StatusVulnerable)
+	}
+}
+
+func TestCheckDSAPublicKey(t *testing.T) {
+	data, err := os.ReadFile("testdata/dsa.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(data)
+
+	// a *dsa.PublicKey, as crypto/x509 returns for DSA keys
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := DebianWeakKey{Blacklist: fixtureBlacklist(t)}
+
+	want, err := w.CheckDER(context.Background(), block.Bytes)
+	if err != nil {
+		t.Fatalf("CheckDER: %v", err)
+	}
+
+	res, err := w.CheckPublicKey(context.Background(), pub)
+	if err != nil || res.Algorithm != "DSA" || res.KeySize != 1024 || res.Vulnerable != checker.StatusVulnerable {
+		t.Errorf("wrong result, got: %s/%d/%v/%v, want: DSA/1024/%v.", res.Algorithm, res.KeySize, res.Vulnerable,
+			err, checker.StatusVulnerable)
+	}
+
+	if res.Fingerprint == "" || res.Fingerprint != want.Fingerprint {
+		t.Errorf("wrong fingerprint, got: %q, want: %q.", res.Fingerprint, want.Fingerprint)
+	}
+}
+
+func TestCheckDSAPrivateKey(t *testing.T) {
+	public, err := os.ReadFile("testdata/dsa.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same key as dsa.pem, in the OpenSSL and PKCS #8 forms
+	private, err := os.ReadFile("testdata/dsa_private.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := DebianWeakKey{Blacklist: fixtureBlacklist(t)}
+
+	want, err := w.CheckPEM(context.Background(), public)
+	if err != nil {
+		t.Fatalf("CheckPEM: %v", err)
+	}
+
+	results, err := w.CheckPEM(context.Background(), private)
+	if err != nil || len(results) != 2 {
+		t.Fatalf("CheckPEM: %v with %d results, want 2.", err, len(results))
+	}
+
+	for i, res := range results {
+		if res.Algorithm != "DSA" || res.KeySize != 1024 || res.Vulnerable != checker.StatusVulnerable {
+			t.Errorf("block %d: wrong result, got: %s/%d/%v, want: DSA/1024/%v.", i, res.Algorithm, res.KeySize,
+				res.Vulnerable, checker.StatusVulnerable)
+		}
+
+		if res.Fingerprint != want[0].Fingerprint {
+			t.Errorf("block %d: wrong fingerprint, got: %s, want: %s.", i, res.Fingerprint, want[0].Fingerprint)
+		}
+	}
+
+	block, _ := pem.Decode(private)
+
+	res, err := w.CheckDER(context.Background(), block.Bytes)
+	if err != nil || res.Vulnerable != checker.StatusVulnerable {
+		t.Errorf("wrong CheckDER return, got: %v/%v, want: %v.", res.Vulnerable, err, checker.StatusVulnerable)
+	}
+}
+
+func TestDefaultBlacklist(t *testing.T) {
+	t.Setenv("WEAKKEY_PATH", "testdata/weakkeys")
+
+	ks, mod := rsaKey(t, weak1024)
+
+	var r DebianWeakKey
+
+	err := r.Check(ks, mod)
+	if err != nil || r.Vulnerable != checker.StatusVulnerable {
+		t.Errorf("Did not detect weak key, got: %v/%v, want: %v.", r.Vulnerable, err, checker.StatusVulnerable)
+	}
+
+	t.Setenv("WEAKKEY_PATH", "testdata/missing")
+
+	err = r.Check(ks, mod)
+	if err == nil || r.Vulnerable != checker.StatusError {
+		t.Errorf("wrong return for missing directory, got: %v/%v, want: %v.", r.Vulnerable, err, checker.StatusError)
+	}
+
+	// a failed load is kept, not retried for every key
+	dir := t.TempDir()
+	t.Setenv("WEAKKEY_PATH", dir)
+
+	_, err = DefaultBlacklist()
+	if !errors.Is(err, ErrNoBlacklist) {
+		t.Fatalf("wrong return for empty directory, got: %v, want: %v.", err, ErrNoBlacklist)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "blacklist.RSA-1024"), []byte("0123456789abcdef0123\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = DefaultBlacklist()
+	if !errors.Is(err, ErrNoBlacklist) {
+		t.Errorf("directory read again, got: %v, want: %v.", err, ErrNoBlacklist)
+	}
+}
+
+// chainServer negotiates proto and then answers the ClientHello with a
+// TLS 1.2 flight carrying the certificates in certs.
+func chainServer(t *testing.T, proto checker.Protocol, certs ...string) (string, string) {
+	t.Helper()
+
+	var list []byte
+
+	for _, c := range certs {
+		der := parseCert(t, c).Raw
+		list = append(list, byte(len(der)>>16), byte(len(der)>>8), byte(len(der)))
+		list = append(list, der...)
+	}
+
+	body := append([]byte{byte(len(list) >> 16), byte(len(list) >> 8), byte(len(list))}, list...)
+
+	lc := net.ListenConfig{}
+
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+		err = starttlstest.Serve(conn, proto)
+		if err != nil {
+			return
+		}
+
+		_, _, err = tlsrecord.NewReader(conn).ReadHandshake()
+		if err != nil {
+			return
+		}
+
+		hello := []byte{0x03, 0x03}
+		hello = append(hello, make([]byte, 32)...)
+		hello = append(hello, 0x00, 0x00, 0x2f, 0x00)
+
+		var flight []byte
+		flight = append(flight, tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHello, hello)...)
+		flight = append(flight, tlsrecord.HandshakeMessage(tlsrecord.HandshakeCertificate, body)...)
+		flight = append(flight, tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHelloDone, nil)...)
+		conn.Write(tlsrecord.Marshal(tlsrecord.TypeHandshake, tlsrecord.VersionTLS12, flight))
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	return host, port
+}
+
+func TestCheckHost(t *testing.T) {
+	host, port := chainServer(t, checker.ProtocolNone, good2048, weak1024)
+
+	w := DebianWeakKey{Blacklist: testBlacklist(t)}
+
+	err := w.CheckHost(context.Background(), host, port)
+	if err != nil {
+		t.Fatalf("CheckHost: %v", err)
+	}
+
+	if w.Vulnerable != checker.StatusVulnerable || len(w.Chain) != 2 {
+		t.Fatalf("wrong return, got: %v with %d certificates, want: %v with 2.", w.Vulnerable, len(w.Chain),
+			checker.StatusVulnerable)
+	}
+
+	for i, want := range []checker.Status{checker.StatusNotVulnerable, checker.StatusVulnerable} {
+		if cr := w.Chain[i]; cr.Position != i || cr.Vulnerable != want || cr.Subject == "" {
+			t.Errorf("position %d: got %d/%v/%q, want: %v.", i, cr.Position, cr.Vulnerable, cr.Subject, want)
+		}
+	}
+}
+
+func TestCheckHostStartTLS(t *testing.T) {
+	for _, proto := range checker.Protocols {
+		t.Run(string(proto), func(t *testing.T) {
+			host, port := chainServer(t, proto, weak1024)
+
+			w := DebianWeakKey{Blacklist: testBlacklist(t), Protocol: proto}
+
+			err := w.CheckHost(context.Background(), host, port)
+			if err != nil || w.Vulnerable != checker.StatusVulnerable {
+				t.Errorf("wrong return, got: %v/%v, want: %v.", w.Vulnerable, err, checker.StatusVulnerable)
+			}
+
+			if w.StartTLS != proto {
+				t.Errorf("Wrong STARTTLS protocol, got: %q, want: %q", w.StartTLS, proto)
+			}
+		})
+	}
+}
+
+func TestCheckHostTLS13(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{MinVersion: tls.VersionTLS13}
+	server.StartTLS()
+	defer server.Close()
+
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "https://"))
+	sum := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+
+	w := DebianWeakKey{Blacklist: testBlacklist(t)}
+
+	err := w.CheckHost(context.Background(), host, port)
+	if err != nil || len(w.Chain) == 0 {
+		t.Fatalf("CheckHost: %v", err)
+	}
+
+	if w.Chain[0].Fingerprint != hex.EncodeToString(sum[:]) || w.Vulnerable == checker.StatusVulnerable {
+		t.Errorf("wrong leaf, got: %+v.", w.Chain[0])
+	}
+}
+
+func TestWeakKeyCheckerHost(t *testing.T) {
+	host, port := chainServer(t, checker.ProtocolNone, good2048, weak1024)
+
+	c := &Checker{Blacklist: testBlacklist(t)}
+
+	res := c.Check(context.Background(), checker.Target{Host: host, Port: port})
+	if res.Status != checker.StatusVulnerable || res.Err != nil {
+		t.Fatalf("wrong result, got: %v/%v, want: %v.", res.Status, res.Err, checker.StatusVulnerable)
+	}
+
+	want := map[string]string{
+		"chain.0.key":    "RSA-2048",
+		"chain.0.status": "no",
+		"chain.1.key":    "RSA-1024",
+		"chain.1.status": "yes",
+		"starttls":       "none",
+	}
+
+	for k, v := range want {
+		if res.Evidence[k] != v {
+			t.Errorf("evidence %s: got %q, want: %q.", k, res.Evidence[k], v)
+		}
+	}
+
+	res = c.Check(context.Background(), checker.Target{Host: "127.0.0.1", Port: "1"})
+	if res.Status != checker.StatusError || res.Err == nil {
+		t.Errorf("Expected error result, got: %s/%v", res.Status, res.Err)
+	}
+}
@@ -0,0 +1,130 @@
+package heartbleed
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+)
+
+// Name identifies the Heartbleed check.
+const Name = "heartbleed"
+
+var _ checker.Checker = (*Checker)(nil)
+
+// Checker adapts Heartbleed to the checker.Checker interface.
+type Checker struct {
+	// TLSVersion is the protocol version offered in the ClientHello.
+	// Zero selects TLS 1.2.
+	TLSVersion int
+	// Timeouts overrides the per-phase defaults of the check.
+	Timeouts checker.Timeouts
+	// Dialer opens the connection to the target. Nil dials directly.
+	Dialer checker.Dialer
+	// Protocol is negotiated with STARTTLS before the ClientHello.
+	// Empty infers it from the port.
+	Protocol checker.Protocol
+	// CaptureLeak scans the memory returned by a vulnerable server and
+	// adds its length, hash and redacted findings to the evidence.
+	CaptureLeak bool
+	// Phases lists the phases to send the heartbeat in until one leaks.
+	// Empty probes during the handshake only.
+	Phases []Phase
+	// Sweep tries every version from SSLv3 to TLS 1.2 instead of
+	// TLSVersion.
+	Sweep Sweep
+	// DTLS runs the check over UDP with DTLS 1.2, or DTLS 1.0 when
+	// TLSVersion is older than TLS 1.2.
+	DTLS bool
+}
+
+// Name returns the name of the check.
+func (c *Checker) Name() string {
+	return Name
+}
+
+// Check runs the Heartbleed check against target.
+func (c *Checker) Check(ctx context.Context, target checker.Target) checker.Result {
+	res := checker.NewResult(Name, target)
+
+	tlsVers := c.TLSVersion
+	if tlsVers == 0 {
+		tlsVers = tls.VersionTLS12
+	}
+
+	h := Heartbleed{
+		Timeouts:    c.Timeouts,
+		Dialer:      c.Dialer,
+		ServerName:  target.SNI(),
+		Protocol:    c.Protocol,
+		CaptureLeak: c.CaptureLeak,
+		Phases:      c.Phases,
+		Sweep:       c.Sweep,
+		DTLS:        c.DTLS,
+	}
+
+	err := h.CheckContext(ctx, target.Host, target.Port, tlsVers)
+
+	if h.StartTLS != "" {
+		res.AddEvidence("starttls", string(h.StartTLS))
+	}
+
+	if h.ServerName != "" {
+		res.AddEvidence("server_name", h.ServerName)
+	}
+
+	if err == nil {
+		res.AddEvidence("extension", strconv.FormatBool(h.ExtensionEnabled))
+	}
+
+	if h.Response != "" {
+		res.AddEvidence("response", string(h.Response))
+	}
+
+	if h.Response == ResponseLeaked || h.Response == ResponseEchoed {
+		res.AddEvidence("payload_length", strconv.Itoa(h.PayloadLength))
+	}
+
+	if h.Version != "" {
+		res.AddEvidence("version", h.Version)
+	}
+
+	for name, status := range h.Versions {
+		res.AddEvidence("version_"+name, string(status))
+	}
+
+	if h.Phase != "" {
+		res.AddEvidence("phase", string(h.Phase))
+	}
+
+	if h.Alert != "" {
+		res.AddEvidence("alert", h.Alert)
+	}
+
+	if h.Leak != nil {
+		addLeakEvidence(&res, h.Leak)
+	}
+
+	res.Finish(h.Vulnerable, err)
+
+	return res
+}
+
+// addLeakEvidence describes a captured leak without its raw contents.
+func addLeakEvidence(res *checker.Result, leak *Leak) {
+	res.AddEvidence("leak_length", strconv.Itoa(leak.Length))
+	res.AddEvidence("leak_sha256", leak.SHA256)
+
+	if len(leak.Findings) == 0 {
+		return
+	}
+
+	res.AddEvidence("leak_findings", strings.Join(leak.Kinds(), ","))
+
+	for i, f := range leak.Findings {
+		res.AddEvidence(fmt.Sprintf("leak_finding_%d", i+1), fmt.Sprintf("%s@%d %s", f.Kind, f.Offset, f.Snippet))
+	}
+}
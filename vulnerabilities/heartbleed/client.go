@@ -0,0 +1,133 @@
+package heartbleed
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/internal/handshake"
+	"github.com/jsandas/tls-vuln-checker/internal/netutil"
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
+)
+
+// CheckClient runs the Heartbleed test against the TLS client connected
+// on conn, playing the server. The ClientHello is answered with a
+// ServerHello accepting the heartbeat extension, followed at once by an
+// over-long heartbeat_request: vulnerable OpenSSL clients process it
+// before the handshake completes and reply with their own memory.
+//
+// Client is set to the remote address and ServerName to the name the
+// client asked for. Dialer, Protocol, Phases, Sweep and DTLS do not
+// apply. conn is left open.
+func (h *Heartbleed) CheckClient(ctx context.Context, conn net.Conn) error {
+	err := h.checkClient(ctx, conn)
+	if err != nil {
+		h.Vulnerable = checker.StatusError
+	}
+
+	return err
+}
+
+func (h *Heartbleed) checkClient(ctx context.Context, conn net.Conn) error {
+	timeouts := h.Timeouts.WithDefaults(defaultTimeouts)
+
+	stop := netutil.AbortOnDone(ctx, conn)
+	defer stop()
+
+	h.Client = conn.RemoteAddr().String()
+
+	err := netutil.SetReadDeadline(ctx, conn, timeouts.Handshake)
+	if err != nil {
+		return err
+	}
+
+	records := tlsrecord.NewReader(conn)
+
+	msgType, body, err := records.ReadHandshake()
+	if err != nil {
+		return err
+	}
+
+	if msgType != tlsrecord.HandshakeClientHello {
+		return fmt.Errorf("expected ClientHello, got handshake message %d", msgType)
+	}
+
+	hello, err := handshake.ParseClientHello(body)
+	if err != nil {
+		return err
+	}
+
+	h.ServerName = hello.ServerName()
+
+	if _, ok := hello.HeartbeatMode(); !ok {
+		h.Vulnerable = checker.StatusNotApplicable
+
+		// the alert only tells the client why it is turned away
+		_ = netutil.Write(ctx, conn, tlsrecord.Marshal(tlsrecord.TypeAlert, hello.Version,
+			[]byte{tlsrecord.AlertLevelFatal, tlsrecord.AlertHandshakeFailure}), timeouts.Write)
+
+		return nil
+	}
+
+	h.ExtensionEnabled = true
+
+	sh, err := handshake.NewServerHello(hello, tlsrecord.VersionTLS12)
+	if err != nil {
+		return err
+	}
+
+	sh.Extensions = []handshake.Extension{
+		{Type: handshake.ExtHeartbeat, Data: []byte{handshake.HeartbeatPeerAllowedToSend}},
+	}
+
+	body, err = sh.Marshal()
+	if err != nil {
+		return err
+	}
+
+	h.Version = VersionName(sh.Version)
+
+	flight := tlsrecord.Marshal(tlsrecord.TypeHandshake, sh.Version,
+		tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHello, body))
+	flight = append(flight, makePayload(int(sh.Version))...)
+
+	err = netutil.Write(ctx, conn, flight, timeouts.Write)
+	if err != nil {
+		return err
+	}
+
+	err = netutil.SetReadDeadline(ctx, conn, timeouts.Read)
+	if err != nil {
+		return err
+	}
+
+	reply, err := heartbeatListen(records)
+
+	err = netutil.ReadErr(ctx, err)
+	if err != nil {
+		return err
+	}
+
+	h.record(reply)
+
+	return nil
+}
+
+// ServeClients accepts connections on ln and runs CheckClient on each,
+// concurrently, with a copy of h's settings. Every result is passed to
+// report, which must be safe for concurrent use. ln is closed when ctx
+// is done and ServeClients then returns ctx.Err(), or nil if ln was
+// closed by the caller, once every check has reported.
+func ServeClients(ctx context.Context, ln net.Listener, h Heartbleed, report func(*Heartbleed, error)) error {
+	return netutil.Serve(ctx, ln, func(conn net.Conn) {
+		probe := Heartbleed{
+			Timeouts:    h.Timeouts,
+			CaptureLeak: h.CaptureLeak,
+			KeepRawLeak: h.KeepRawLeak,
+		}
+
+		err := probe.CheckClient(ctx, conn)
+		report(&probe, err)
+	})
+}
@@ -0,0 +1,436 @@
+package heartbleed
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/internal/handshake"
+	"github.com/jsandas/tls-vuln-checker/internal/netutil"
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
+)
+
+// DTLS versions as carried in record and handshake headers.
+const (
+	versionDTLS10 uint16 = 0xfeff
+	versionDTLS12 uint16 = 0xfefd
+)
+
+// DTLS framing from RFC 6347.
+const (
+	dtlsRecordHeaderLen         = 13
+	dtlsHandshakeHeaderLen      = 12
+	handshakeHelloVerifyRequest = 3
+	// maxDatagram is the largest datagram read. A vulnerable server
+	// sends its whole over-long reply in one.
+	maxDatagram = 1 << 16
+	// dtlsRetransmit is how long to wait for the server's flight before
+	// sending ours again (RFC 6347 section 4.2.4.1).
+	dtlsRetransmit = time.Second
+)
+
+// dtlsVersion maps the version passed to Check onto DTLS: TLS 1.2 offers
+// DTLS 1.2, anything older DTLS 1.0.
+func dtlsVersion(tlsVers int) uint16 {
+	if tlsVers >= int(tlsrecord.VersionTLS12) {
+		return versionDTLS12
+	}
+
+	return versionDTLS10
+}
+
+// checkDTLS runs the Heartbleed test over DTLS: a ClientHello answered by
+// HelloVerifyRequest is repeated with the cookie, and once the server's
+// flight is complete an over-long heartbeat is sent in the clear.
+func (h *Heartbleed) checkDTLS(ctx context.Context, host, port string, tlsVers int) error {
+	timeouts := h.Timeouts.WithDefaults(defaultTimeouts)
+
+	conn, err := netutil.Dial(ctx, h.Dialer, "udp", net.JoinHostPort(host, port), timeouts.Dial)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := netutil.AbortOnDone(ctx, conn)
+	defer stop()
+
+	serverName := h.ServerName
+	if serverName == "" {
+		serverName = checker.DefaultServerName(host)
+	}
+
+	version := dtlsVersion(tlsVers)
+
+	hello := makeClientHello(tlsVers, serverName, PhaseHandshake)
+	hello.Version = version
+	hello.CipherSuites = dtlsCipherSuites()
+	hello.SessionTicket = false
+
+	body, err := hello.Marshal()
+	if err != nil {
+		return err
+	}
+
+	dc := newDTLSConn(conn)
+
+	sh, err := dc.handshake(ctx, body, netutil.Deadline(ctx, timeouts.Handshake), timeouts.Write)
+	if err != nil && !tlsrecord.Refused(err) {
+		return err
+	}
+
+	if sh == nil || !heartbeatAllowed(sh) {
+		h.Vulnerable = checker.StatusNotApplicable
+
+		return nil
+	}
+
+	h.ExtensionEnabled = true
+	h.Version = dtlsVersionName(dc.version)
+
+	payload := makePayload(int(dc.version))
+
+	err = dc.writeRecord(ctx, recordTypeHeartbeat, payload[tlsrecord.HeaderLen:], timeouts.Write)
+	if err != nil {
+		return err
+	}
+
+	err = netutil.SetReadDeadline(ctx, conn, timeouts.Read)
+	if err != nil {
+		return err
+	}
+
+	reply, err := dc.heartbeatListen()
+
+	err = netutil.ReadErr(ctx, err)
+	if err != nil {
+		return err
+	}
+
+	h.record(reply)
+
+	return nil
+}
+
+// dtlsVersionName returns the name reported in Heartbleed.Version.
+func dtlsVersionName(version uint16) string {
+	if version == versionDTLS12 {
+		return "DTLSv1.2"
+	}
+
+	return "DTLSv1.0"
+}
+
+// dtlsCipherSuites returns the default suites without RC4, which DTLS
+// does not allow.
+func dtlsCipherSuites() []uint16 {
+	var suites []uint16
+
+	for _, id := range defaultCipherSuites {
+		if !strings.Contains(handshake.CipherSuiteName(id), "_RC4_") {
+			suites = append(suites, id)
+		}
+	}
+
+	return suites
+}
+
+// heartbeatAllowed reports whether the ServerHello accepted heartbeats
+// from the client.
+func heartbeatAllowed(sh *handshake.ServerHello) bool {
+	mode, ok := sh.HeartbeatMode()
+
+	return ok && mode == handshake.HeartbeatPeerAllowedToSend
+}
+
+// dtlsRecord is a DTLS record of epoch 0.
+type dtlsRecord struct {
+	typ     uint8
+	payload []byte
+}
+
+// dtlsMessage is a handshake message being reassembled from fragments.
+type dtlsMessage struct {
+	typ  uint8
+	body []byte
+	have []bool
+	left int
+}
+
+// dtlsConn exchanges the unprotected epoch 0 records of a DTLS
+// handshake over a datagram connection.
+type dtlsConn struct {
+	conn net.Conn
+	// version stamps the records sent: DTLS 1.0 until the ServerHello
+	// names the version the server picked.
+	version uint16
+	// seq is the sequence number of the next record sent and msgSeq the
+	// message_seq of the next handshake message.
+	seq    uint64
+	msgSeq uint16
+
+	// partial holds incoming messages by message_seq until complete, and
+	// done the message_seqs already returned.
+	partial map[uint16]*dtlsMessage
+	done    map[uint16]bool
+	ready   []handshake.Message
+}
+
+func newDTLSConn(conn net.Conn) *dtlsConn {
+	return &dtlsConn{
+		conn:    conn,
+		version: versionDTLS10,
+		partial: make(map[uint16]*dtlsMessage),
+		done:    make(map[uint16]bool),
+	}
+}
+
+// record frames payload as one DTLS record of epoch 0.
+func (c *dtlsConn) record(typ uint8, payload []byte) []byte {
+	out := []byte{typ, byte(c.version >> 8), byte(c.version & 0xff), 0, 0}
+	out = append(out, byte(c.seq>>40), byte(c.seq>>32), byte(c.seq>>24), byte(c.seq>>16), byte(c.seq>>8), byte(c.seq))
+	out = binary.BigEndian.AppendUint16(out, tlsrecord.Uint16Length(len(payload)))
+	c.seq++
+
+	return append(out, payload...)
+}
+
+func (c *dtlsConn) writeRecord(ctx context.Context, typ uint8, payload []byte, timeout time.Duration) error {
+	return netutil.Write(ctx, c.conn, c.record(typ, payload), timeout)
+}
+
+// clientHello returns a record holding the ClientHello body with cookie
+// inserted after the session id, as a new handshake message.
+func (c *dtlsConn) clientHello(body, cookie []byte) []byte {
+	sidEnd := 35 + int(body[34])
+
+	msg := append([]byte(nil), body[:sidEnd]...)
+	msg = append(msg, byte(len(cookie))) // #nosec G115 -- cookies are at most 255 bytes
+	msg = append(msg, cookie...)
+	msg = append(msg, body[sidEnd:]...)
+
+	n := len(msg)
+	hdr := []byte{tlsrecord.HandshakeClientHello, byte(n >> 16), byte(n >> 8), byte(n)}
+	hdr = binary.BigEndian.AppendUint16(hdr, c.msgSeq)
+	hdr = append(hdr, 0, 0, 0, byte(n>>16), byte(n>>8), byte(n))
+	c.msgSeq++
+
+	return c.record(tlsrecord.TypeHandshake, append(hdr, msg...))
+}
+
+// handshake sends the ClientHello, repeats it with the cookie of a
+// HelloVerifyRequest and reads the server's flight up to
+// ServerHelloDone. Our last flight is sent again whenever the server
+// stays silent for dtlsRetransmit, until deadline.
+func (c *dtlsConn) handshake(ctx context.Context, body []byte, deadline time.Time,
+	writeTimeout time.Duration,
+) (*handshake.ServerHello, error) {
+	var sh *handshake.ServerHello
+
+	flight, send := c.clientHello(body, nil), true
+
+	for {
+		if send {
+			err := netutil.Write(ctx, c.conn, flight, writeTimeout)
+			if err != nil {
+				return nil, err
+			}
+
+			send = false
+		}
+
+		msg, err := c.readHandshake(ctx, deadline)
+		if errors.Is(err, os.ErrDeadlineExceeded) && time.Now().Before(deadline) {
+			send = true
+
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch msg.Type {
+		case handshakeHelloVerifyRequest:
+			cookie, err := parseHelloVerifyRequest(msg.Body)
+			if err != nil {
+				return nil, err
+			}
+
+			// the server keeps no state until the cookie comes back, so
+			// its message numbering may start over
+			clear(c.done)
+
+			flight, send = c.clientHello(body, cookie), true
+		case tlsrecord.HandshakeServerHello:
+			sh, err = handshake.ParseServerHello(msg.Body)
+			if err != nil {
+				return nil, err
+			}
+
+			// servers drop records stamped with any other version
+			if sh.Version == versionDTLS10 || sh.Version == versionDTLS12 {
+				c.version = sh.Version
+			}
+		case tlsrecord.HandshakeServerHelloDone:
+			if sh == nil {
+				return nil, errors.New("server sent no ServerHello")
+			}
+
+			return sh, nil
+		}
+	}
+}
+
+// parseHelloVerifyRequest returns the cookie of a HelloVerifyRequest.
+func parseHelloVerifyRequest(body []byte) ([]byte, error) {
+	if len(body) < 3 || len(body) < 3+int(body[2]) {
+		return nil, fmt.Errorf("HelloVerifyRequest: %w", handshake.ErrTruncated)
+	}
+
+	return body[3 : 3+int(body[2])], nil
+}
+
+// readHandshake returns the next complete handshake message, waiting at
+// most dtlsRetransmit and never past deadline. Alerts are returned as
+// *tlsrecord.AlertError.
+func (c *dtlsConn) readHandshake(ctx context.Context, deadline time.Time) (handshake.Message, error) {
+	for len(c.ready) == 0 {
+		err := netutil.SetReadDeadline(ctx, c.conn, min(time.Until(deadline), dtlsRetransmit))
+		if err != nil {
+			return handshake.Message{}, err
+		}
+
+		records, err := c.readDatagram()
+		if err != nil {
+			return handshake.Message{}, err
+		}
+
+		for _, rec := range records {
+			switch rec.typ {
+			case tlsrecord.TypeAlert:
+				alert, err := tlsrecord.ParseAlert(rec.payload)
+				if err != nil {
+					return handshake.Message{}, err
+				}
+
+				return handshake.Message{}, alert
+			case tlsrecord.TypeHandshake:
+				c.addFragments(rec.payload)
+			}
+		}
+	}
+
+	msg := c.ready[0]
+	c.ready = c.ready[1:]
+
+	return msg, nil
+}
+
+// readDatagram reads one datagram and splits it into records.
+func (c *dtlsConn) readDatagram() ([]dtlsRecord, error) {
+	buf := make([]byte, maxDatagram)
+
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []dtlsRecord
+
+	for p := buf[:n]; len(p) >= dtlsRecordHeaderLen; {
+		length := int(binary.BigEndian.Uint16(p[11:13]))
+		if p[1] != 0xfe || len(p) < dtlsRecordHeaderLen+length {
+			return records, tlsrecord.ErrNotTLS
+		}
+
+		records = append(records, dtlsRecord{typ: p[0], payload: p[dtlsRecordHeaderLen : dtlsRecordHeaderLen+length]})
+		p = p[dtlsRecordHeaderLen+length:]
+	}
+
+	return records, nil
+}
+
+// addFragments reassembles the handshake fragments of a record and
+// queues the messages they complete.
+func (c *dtlsConn) addFragments(p []byte) {
+	for len(p) >= dtlsHandshakeHeaderLen {
+		typ := p[0]
+		length := int(p[1])<<16 | int(p[2])<<8 | int(p[3])
+		seq := binary.BigEndian.Uint16(p[4:6])
+		offset := int(p[6])<<16 | int(p[7])<<8 | int(p[8])
+		fragLen := int(p[9])<<16 | int(p[10])<<8 | int(p[11])
+
+		if len(p) < dtlsHandshakeHeaderLen+fragLen || offset+fragLen > length || length > tlsrecord.MaxHandshake {
+			return
+		}
+
+		frag := p[dtlsHandshakeHeaderLen : dtlsHandshakeHeaderLen+fragLen]
+		p = p[dtlsHandshakeHeaderLen+fragLen:]
+
+		if c.done[seq] {
+			continue
+		}
+
+		msg, ok := c.partial[seq]
+		if !ok || len(msg.body) != length {
+			msg = &dtlsMessage{typ: typ, body: make([]byte, length), have: make([]bool, length), left: length}
+			c.partial[seq] = msg
+		}
+
+		copy(msg.body[offset:], frag)
+
+		for i := offset; i < offset+fragLen; i++ {
+			if !msg.have[i] {
+				msg.have[i] = true
+				msg.left--
+			}
+		}
+
+		if msg.left == 0 {
+			delete(c.partial, seq)
+			c.done[seq] = true
+			c.ready = append(c.ready, handshake.Message{Type: msg.typ, Body: msg.body})
+		}
+	}
+}
+
+// heartbeatListen waits for the answer to the heartbeat request. Over
+// DTLS a reply is never split, so the first heartbeat_response decides.
+func (c *dtlsConn) heartbeatListen() (*heartbeatReply, error) {
+	for {
+		records, err := c.readDatagram()
+
+		switch {
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			return &heartbeatReply{response: ResponseDropped}, nil
+		case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, io.EOF):
+			return &heartbeatReply{response: ResponseReset}, nil
+		case err != nil:
+			return nil, err
+		}
+
+		for _, rec := range records {
+			switch rec.typ {
+			case tlsrecord.TypeAlert:
+				alert, err := tlsrecord.ParseAlert(rec.payload)
+				if err != nil {
+					return nil, err
+				}
+
+				return &heartbeatReply{response: ResponseAlert, alert: alert}, nil
+			case recordTypeHeartbeat:
+				if len(rec.payload) >= heartbeatHeaderLen && rec.payload[0] == heartbeatResponse {
+					return parseHeartbeatResponse(rec.payload), nil
+				}
+			}
+		}
+	}
+}
@@ -1,77 +1,119 @@
 package heartbleed
 
 import (
-	"bufio"
 	"context"
 	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
-	"strings"
+	"os"
+	"syscall"
 	"time"
 
-	"github.com/jsandas/starttls-go/starttls"
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/internal/handshake"
+	"github.com/jsandas/tls-vuln-checker/internal/netutil"
+	"github.com/jsandas/tls-vuln-checker/internal/starttls"
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
 )
 
 // startTLSFunc is a package-level variable so it can be replaced in tests.
-var startTLSFunc = starttls.StartTLS
+var startTLSFunc = starttls.Start
 
-// write data to network conn.
-func write(conn net.Conn, data []byte, timeout time.Duration) error {
-	err := conn.SetWriteDeadline(time.Now().Add(timeout * time.Second))
-	if err != nil {
-		return err
-	}
-
-	_, err = conn.Write(data)
-
-	return err
-}
-
-// // read data from network conn.
-// func read(conn net.Conn, timeout time.Duration) ([]byte, error) {
-// 	var bytes []byte
-// 	conn.SetReadDeadline(time.Now().Add(timeout * time.Second))
-// 	buff := bufio.NewReader(conn)
-// 	for {
-// 		b, err := buff.ReadByte()
-// 		bytes = append(bytes, b)
-// 		if err != nil {
-// 			return bytes, err
-// 		}
-// 		if buff.Buffered() == 0 {
-// 			break
-// 		}
-// 	}
-// 	return bytes, nil
-// }
+// Phase is the point of the connection at which the heartbeat request
+// is sent.
+type Phase string
 
+// Phases of the connection.
 const (
-	notApplicable = "n/a"
-	notVulnerable = "no"
-	vulnerable    = "yes"
-	testFailed    = "error"
+	// PhaseHandshake sends the request in the clear right after
+	// ServerHelloDone, before any key exchange.
+	PhaseHandshake Phase = "handshake"
+	// PhasePostHandshake completes the handshake first and sends the
+	// request encrypted, for servers that ignore heartbeats until then.
+	PhasePostHandshake Phase = "post_handshake"
 )
 
 type Heartbleed struct {
-	Vulnerable       string `json:"vulnerable"`
-	ExtensionEnabled bool   `json:"extension"`
+	Vulnerable       checker.Status `json:"vulnerable"`
+	ExtensionEnabled bool           `json:"extension"`
+	// StartTLS is the protocol negotiated before TLS.
+	StartTLS checker.Protocol `json:"starttls"`
+	// Response is how the server answered the heartbeat request.
+	Response Response `json:"response,omitempty"`
+	// PayloadLength is the payload_length of the heartbeat_response.
+	PayloadLength int `json:"payload_length,omitempty"`
+	// Alert names the alert sent in reply to the heartbeat request.
+	Alert string `json:"alert,omitempty"`
+	// Leak is the memory returned by a vulnerable server, scanned and
+	// redacted. It is only set when CaptureLeak is.
+	Leak *Leak `json:"leak,omitempty"`
+	// Phase is the phase in which the server leaked memory.
+	Phase Phase `json:"phase,omitempty"`
+	// Version names the protocol version that produced the verdict of a
+	// sweep, and Versions holds the verdict of every version tried.
+	Version  string                    `json:"version,omitempty"`
+	Versions map[string]checker.Status `json:"versions,omitempty"`
+	// Client is the address of the client tested by CheckClient.
+	Client string `json:"client,omitempty"`
+
+	// Timeouts overrides the per-phase defaults used by CheckContext.
+	Timeouts checker.Timeouts `json:"-"`
+	// Dialer opens the connection to the target. Nil dials directly.
+	Dialer checker.Dialer `json:"-"`
+	// ServerName is sent in the server_name extension. Empty uses the
+	// host unless it is an IP address.
+	ServerName string `json:"-"`
+	// Protocol is negotiated with STARTTLS before the ClientHello.
+	// Empty infers it from the port.
+	Protocol checker.Protocol `json:"-"`
+	// CaptureLeak keeps the heartbeat reply of a vulnerable server and
+	// scans it for secrets. Only redacted findings are reported.
+	CaptureLeak bool `json:"-"`
+	// KeepRawLeak additionally keeps the unredacted memory in Leak.Raw.
+	KeepRawLeak bool `json:"-"`
+	// Phases lists the phases to probe, each over its own connection,
+	// until one leaks. Empty probes during the handshake only.
+	Phases []Phase `json:"-"`
+	// Sweep tries SSLv3 to TLS 1.2 in turn instead of the version passed
+	// to Check.
+	Sweep Sweep `json:"-"`
+	// DTLS probes over UDP instead: TLS 1.2 selects DTLS 1.2 and older
+	// versions DTLS 1.0. Protocol, Phases and Sweep do not apply.
+	DTLS bool `json:"-"`
+}
+
+// defaultTimeouts apply to any phase not set in Heartbleed.Timeouts.
+var defaultTimeouts = checker.Timeouts{
+	Dial:      3 * time.Second,
+	StartTLS:  3 * time.Second,
+	Handshake: 3 * time.Second,
+	Write:     2 * time.Second,
+	Read:      1 * time.Second,
 }
 
 // Heartbleed test.
 func (h *Heartbleed) Check(host string, port string, tlsVers int) error {
-	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	dialer := &net.Dialer{}
+	return h.CheckContext(context.Background(), host, port, tlsVers)
+}
 
-	conn, err := dialer.DialContext(ctx, "tcp", host+":"+port)
-	if err != nil {
-		h.Vulnerable = testFailed
+// CheckContext runs the Heartbleed test. Every phase is bounded by its
+// entry in Timeouts and aborted as soon as ctx is done.
+func (h *Heartbleed) CheckContext(ctx context.Context, host string, port string, tlsVers int) error {
+	if h.DTLS {
+		err := h.checkDTLS(ctx, host, port, tlsVers)
+		if err != nil {
+			h.Vulnerable = checker.StatusError
+		}
 
 		return err
 	}
-	defer conn.Close()
+
+	if h.Sweep != SweepOff {
+		return h.sweep(ctx, host, port)
+	}
 
 	/*
 		only test up to tlsv1.2 because not possible
@@ -81,150 +123,355 @@ func (h *Heartbleed) Check(host string, port string, tlsVers int) error {
 		tlsVers = tls.VersionTLS12
 	}
 
-	err = startTLSFunc(ctx, conn, port)
+	phases := h.Phases
+	if len(phases) == 0 {
+		phases = []Phase{PhaseHandshake}
+	}
+
+	var (
+		best      *Heartbleed
+		extension bool
+	)
+
+	for _, phase := range phases {
+		probe := h.probeConfig()
+
+		err := probe.probe(ctx, host, port, tlsVers, phase)
+		h.StartTLS = probe.StartTLS
+
+		if err != nil {
+			h.Vulnerable = checker.StatusError
+
+			return err
+		}
+
+		extension = extension || probe.ExtensionEnabled
+
+		if best == nil || verdictRank(probe.Vulnerable) > verdictRank(best.Vulnerable) {
+			best = &probe
+		}
+
+		// without the extension no phase has anything to test, and a
+		// leak in one phase settles the check
+		if !extension || best.Vulnerable == checker.StatusVulnerable {
+			break
+		}
+	}
+
+	h.merge(best, extension)
+
+	return nil
+}
+
+// probeConfig returns a Heartbleed with h's configuration and no
+// results, to probe one phase or version into.
+func (h *Heartbleed) probeConfig() Heartbleed {
+	return Heartbleed{
+		Timeouts:    h.Timeouts,
+		Dialer:      h.Dialer,
+		ServerName:  h.ServerName,
+		Protocol:    h.Protocol,
+		CaptureLeak: h.CaptureLeak,
+		KeepRawLeak: h.KeepRawLeak,
+		Phases:      h.Phases,
+	}
+}
+
+// merge takes the results of the most telling phase probed, and whether
+// any phase saw the heartbeat extension.
+func (h *Heartbleed) merge(best *Heartbleed, extension bool) {
+	h.Vulnerable = best.Vulnerable
+	h.ExtensionEnabled = extension
+	h.Response = best.Response
+	h.PayloadLength = best.PayloadLength
+	h.Alert = best.Alert
+	h.Leak = best.Leak
+	h.Phase = best.Phase
+}
+
+// probe sends the heartbeat request in phase over a new connection.
+func (h *Heartbleed) probe(ctx context.Context, host, port string, tlsVers int, phase Phase) error {
+	timeouts := h.Timeouts.WithDefaults(defaultTimeouts)
+
+	conn, err := netutil.Dial(ctx, h.Dialer, "tcp", net.JoinHostPort(host, port), timeouts.Dial)
 	if err != nil {
-		h.Vulnerable = testFailed
+		return err
+	}
+	defer conn.Close()
+
+	stop := netutil.AbortOnDone(ctx, conn)
+	defer stop()
+
+	serverName := h.ServerName
+	if serverName == "" {
+		serverName = checker.DefaultServerName(host)
+	}
 
+	h.StartTLS = starttls.Resolve(h.Protocol, port)
+
+	err = startTLSFunc(ctx, conn, h.StartTLS, host, serverName, timeouts.StartTLS)
+	if err != nil {
 		return err
 	}
 
 	// Send clientHello
-	clientHello := makeClientHello(tlsVers)
+	hello, err := makeClientHello(tlsVers, serverName, phase).Marshal()
+	if err != nil {
+		return err
+	}
 
-	err = write(conn, clientHello, 2)
+	err = netutil.Write(ctx, conn, clientHelloRecord(tlsVers, hello), timeouts.Write)
 	if err != nil {
-		h.Vulnerable = testFailed
+		return err
+	}
 
+	err = netutil.SetReadDeadline(ctx, conn, timeouts.Handshake)
+	if err != nil {
 		return err
 	}
 
-	connbuf := bufio.NewReader(conn)
+	records := tlsrecord.NewReader(conn)
 
-	hBEnabled, err := checkExtension(connbuf)
-	if err != nil {
-		switch err.Error() {
-		// some applications reset the tcp connection
-		// when probing for heartbleed
-		case "EOF":
-		default:
-			h.Vulnerable = testFailed
+	flight, hBEnabled, err := checkExtension(records)
+	if err != nil && !tlsrecord.Refused(err) {
+		return err
+	}
 
-			return err
-		}
+	if !hBEnabled {
+		h.Vulnerable = checker.StatusNotApplicable
+
+		return nil
 	}
 
-	if hBEnabled {
-		h.ExtensionEnabled = true
+	h.ExtensionEnabled = true
+
+	send := func(payload []byte) error {
+		return netutil.Write(ctx, conn, payload, timeouts.Write)
+	}
 
-		payload := makePayload(tlsVers)
+	if phase == PhasePostHandshake {
+		err = conn.SetDeadline(netutil.Deadline(ctx, timeouts.Handshake))
+		if err != nil {
+			return err
+		}
 
-		err = write(conn, payload, 2)
+		session, err := handshake.Finish(conn, records, hello, flight)
 		if err != nil {
-			h.Vulnerable = testFailed
+			return fmt.Errorf("completing handshake: %w", err)
+		}
 
-			return err
+		records = session.Reader
+		send = func(payload []byte) error {
+			err := conn.SetWriteDeadline(netutil.Deadline(ctx, timeouts.Write))
+			if err != nil {
+				return err
+			}
+
+			return session.Writer.WriteRecord(recordTypeHeartbeat, payload[tlsrecord.HeaderLen:])
 		}
+	}
 
-		h.Vulnerable = heartbeatListen(connbuf)
+	err = send(makePayload(tlsVers))
+	if err != nil {
+		return err
+	}
 
-		return nil
+	err = netutil.SetReadDeadline(ctx, conn, timeouts.Read)
+	if err != nil {
+		return err
 	}
 
-	h.Vulnerable = notApplicable
+	reply, err := heartbeatListen(records)
+
+	err = netutil.ReadErr(ctx, err)
+	if err != nil {
+		return err
+	}
+
+	h.record(reply)
+
+	if reply.response == ResponseLeaked {
+		h.Phase = phase
+	}
 
 	return nil
 }
 
+// record stores the server's answer to the heartbeat request.
+func (h *Heartbleed) record(reply *heartbeatReply) {
+	h.Vulnerable = reply.status()
+	h.Response = reply.response
+	h.PayloadLength = reply.payloadLen
+
+	if reply.alert != nil {
+		h.Alert = reply.alert.Name()
+	}
+
+	if h.CaptureLeak && reply.response == ResponseLeaked {
+		h.Leak = ScanLeak(reply.payload)
+		if h.KeepRawLeak {
+			h.Leak.Raw = reply.payload
+		}
+	}
+}
+
 // checks if handshake was successful and if the
 // heartbeat extension is enabled.
-func checkExtension(buff *bufio.Reader) (bool, error) {
-	var data []byte
+func checkExtension(records *tlsrecord.Reader) (*handshake.ServerFlight, bool, error) {
+	flight, err := handshake.ReadServerFlight(records)
+	if err != nil {
+		return flight, false, err
+	}
+
+	if flight.ServerHello == nil {
+		return flight, false, errors.New("server sent no ServerHello")
+	}
 
-	var err error
+	mode, ok := flight.ServerHello.HeartbeatMode()
 
-	hBEnabled := false
+	return flight, ok && mode == handshake.HeartbeatPeerAllowedToSend, nil
+}
+
+// Response describes how the server answered the heartbeat request.
+type Response string
+
+// Responses to the over-long heartbeat request.
+const (
+	// ResponseLeaked is a heartbeat_response carrying more payload than
+	// was sent: the server read past the request.
+	ResponseLeaked Response = "vulnerable"
+	// ResponseEchoed is a heartbeat_response whose payload matches the
+	// request.
+	ResponseEchoed Response = "echoed"
+	// ResponseDropped means nothing came back before the read timeout,
+	// which is how patched OpenSSL discards the request.
+	ResponseDropped Response = "silently_dropped"
+	// ResponseAlert is an alert sent in reply to the request.
+	ResponseAlert Response = "alert"
+	// ResponseReset means the server closed or reset the connection.
+	ResponseReset Response = "connection_reset"
+)
+
+// Heartbeat message types from RFC 6520.
+const (
+	heartbeatRequest  = 1
+	heartbeatResponse = 2
+)
+
+// heartbeatHeaderLen is the size of the type and payload_length fields
+// that start a heartbeat message.
+const heartbeatHeaderLen = 3
+
+// sentPayloadLen is the payload actually carried by the request built by
+// makePayload, whatever its payload_length field claims.
+const sentPayloadLen = 0
+
+// maxLeak bounds the memory kept from one heartbeat reply.
+const maxLeak = 64 << 10
+
+// heartbeatReply is what the server sent back for the heartbeat request.
+type heartbeatReply struct {
+	response Response
+	// payloadLen is the payload_length field of the heartbeat_response.
+	payloadLen int
+	// payload is the payload received, cut to payloadLen.
+	payload []byte
+	alert   *tlsrecord.AlertError
+}
+
+// status maps the reply to the result of the check.
+func (r *heartbeatReply) status() checker.Status {
+	if r.response == ResponseLeaked {
+		return checker.StatusVulnerable
+	}
+
+	return checker.StatusNotVulnerable
+}
+
+// heartbeatListen reads records until a heartbeat_response has been
+// reassembled, an alert arrives or the connection ends, and reports how
+// the server answered. Heartbeat requests from the server and other
+// records are skipped.
+func heartbeatListen(records *tlsrecord.Reader) (*heartbeatReply, error) {
+	var message []byte
 
 	for {
-		b, err := buff.ReadByte()
+		record, err := records.ReadRecord()
 		if err != nil {
-			return hBEnabled, err
+			return readFailed(message, err)
 		}
 
-		data = append(data, b)
+		switch record.Type {
+		case tlsrecord.TypeAlert:
+			alert, err := tlsrecord.ParseAlert(record.Payload)
+			if err != nil {
+				return nil, err
+			}
 
-		// is heartbeat extension enabled?
-		if strings.HasSuffix(fmt.Sprintf("%X", data), "000F000101") {
-			hBEnabled = true
+			return &heartbeatReply{response: ResponseAlert, alert: alert}, nil
+		case tlsrecord.TypeHeartbeat:
+			message = append(message, record.Payload...)
+			if !heartbeatComplete(message) {
+				continue
+			}
+
+			if message[0] != heartbeatResponse {
+				message = nil
+
+				continue
+			}
+
+			return parseHeartbeatResponse(message), nil
 		}
+	}
+}
 
-		// is serverHello finished
-		if strings.HasSuffix(fmt.Sprintf("%X", data), "0E000000") {
-			break
+// readFailed classifies a read error that ended heartbeatListen. A
+// response cut short still shows whether memory was leaked.
+func readFailed(message []byte, err error) (*heartbeatReply, error) {
+	if len(message) >= heartbeatHeaderLen && message[0] == heartbeatResponse {
+		reply := parseHeartbeatResponse(message)
+		if reply.response == ResponseLeaked {
+			return reply, nil
 		}
 	}
 
-	return hBEnabled, err
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return &heartbeatReply{response: ResponseDropped}, nil
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET):
+		return &heartbeatReply{response: ResponseReset}, nil
+	}
+
+	return nil, err
 }
 
-// Reads from buffer and checks the size of the response
-// to determine if heartbleed was exploited.
-func heartbeatListen(buff *bufio.Reader) string {
-	// Create a channel to signal when to stop reading
-	done := make(chan struct{})
-	defer close(done)
-
-	// Create a channel for collecting data
-	dataChan := make(chan []byte, 1)
-
-	// Start reader goroutine with proper error handling
-	go func() {
-		var data []byte
-
-		defer func() {
-			dataChan <- data // Send collected data before exiting
-		}()
-
-		timeout := time.After(1 * time.Second)
-		i := 0
-
-		for {
-			select {
-			case <-done:
-				return
-			case <-timeout:
-				if len(data) == 0 {
-					return // No data received within timeout
-				}
-			default:
-				if i > 3 && buff.Buffered() == 0 {
-					return // No more data to read
-				}
-
-				b, err := buff.ReadByte()
-				if err != nil {
-					return
-				}
-
-				data = append(data, b)
-				if len(data) >= 1600 {
-					return // Found vulnerability
-				}
-
-				if buff.Buffered() == 0 {
-					i++
-					// Small sleep to prevent tight loop
-					time.Sleep(100 * time.Millisecond)
-				}
-			}
-		}
-	}()
+// heartbeatComplete reports whether message holds the whole payload its
+// header announces, or as much as maxLeak allows.
+func heartbeatComplete(message []byte) bool {
+	if len(message) >= maxLeak {
+		return true
+	}
 
-	// Wait for the reader goroutine to finish and collect data
-	data := <-dataChan
+	return len(message) >= heartbeatHeaderLen &&
+		len(message) >= heartbeatHeaderLen+int(binary.BigEndian.Uint16(message[1:heartbeatHeaderLen]))
+}
+
+// parseHeartbeatResponse compares the payload of a heartbeat_response
+// with the request. Padding after the announced length is dropped.
+func parseHeartbeatResponse(message []byte) *heartbeatReply {
+	n := int(binary.BigEndian.Uint16(message[1:heartbeatHeaderLen]))
+	payload := message[heartbeatHeaderLen:]
+
+	reply := &heartbeatReply{
+		response:   ResponseEchoed,
+		payloadLen: n,
+		payload:    payload[:min(n, len(payload), maxLeak)],
+	}
 
-	if len(data) >= 1600 {
-		return vulnerable
+	if len(reply.payload) > sentPayloadLen {
+		reply.response = ResponseLeaked
 	}
 
-	return notVulnerable
+	return reply
 }
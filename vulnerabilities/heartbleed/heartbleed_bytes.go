@@ -2,17 +2,16 @@ package heartbleed
 
 import (
 	"bytes"
-	"crypto/rand"
 	"encoding/binary"
-	"math"
+
+	"github.com/jsandas/tls-vuln-checker/internal/handshake"
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
 )
 
 // TLS record types.
 const (
-	recordTypeHandshake  = 0x16
 	recordTypeHeartbeat  = 0x18
 	heartbeatMessageType = 0x01
-	handshakeTypeHello   = 0x01
 	defaultHeartbeatLen  = 0x4000 // 16384 bytes
 )
 
@@ -70,18 +69,9 @@ var defaultCipherSuites = []uint16{
 	0x0003, // TLS_RSA_EXPORT_WITH_RC4_40_MD5
 }
 
-// TLS extension types.
-const (
-	extensionServerName          uint16 = 0x0000
-	extensionSecureRenegotiation uint16 = 0xff01
-	extensionSessionTicket       uint16 = 0x0023
-	extensionHeartbeat           uint16 = 0x000f
-)
-
-// TLS compression methods.
-const (
-	compressionNone uint8 = 0
-)
+// TLS ec_point_formats: uncompressed, ansiX962_compressed_prime and
+// ansiX962_compressed_char2.
+var defaultPointFormats = []uint8{0x00, 0x01, 0x02}
 
 // HeartbeatMessage represents the structure of a TLS heartbeat message.
 type HeartbeatMessage struct {
@@ -92,46 +82,6 @@ type HeartbeatMessage struct {
 	PayloadLen  uint16
 }
 
-// ClientHelloMessage represents the structure of a TLS client hello message.
-type ClientHelloMessage struct {
-	ContentType   uint8
-	TLSVersion    uint16
-	Length        uint16
-	HandshakeType uint8
-	HSLength      [3]byte // 24-bit integer
-	HSVersion     uint16
-	Random        [32]byte
-	SessionIDLen  uint8
-	CipherSuites  []uint16
-	CompMethods   []uint8
-	Extensions    []Extension
-}
-
-// Extension represents a TLS extension.
-type Extension struct {
-	Type uint16
-	Data []byte
-}
-
-func mustUint16Length(length int) uint16 {
-	if length < 0 || length > math.MaxUint16 {
-		panic("tls record length exceeds uint16")
-	}
-
-	return uint16(length) // #nosec G115 -- bounded above by math.MaxUint16 and rejected when negative
-}
-
-func mustUint24Length(length int) [3]byte {
-	if length < 0 || length > 0x00ffffff {
-		panic("tls handshake length exceeds 24-bit field")
-	}
-
-	var encoded [4]byte
-	binary.BigEndian.PutUint32(encoded[:], uint32(length)) // #nosec G115 -- bounded to 24 bits and rejected when negative
-
-	return [3]byte{encoded[1], encoded[2], encoded[3]}
-}
-
 func makePayload(tlsVers int) []byte {
 	buf := new(bytes.Buffer)
 	msg := HeartbeatMessage{
@@ -151,92 +101,29 @@ func makePayload(tlsVers int) []byte {
 	return buf.Bytes()
 }
 
-func makeClientHello(tlsVers int) []byte {
-	buf := new(bytes.Buffer)
-
-	// Generate random bytes for the client hello
-	var random [32]byte
-
-	_, _ = rand.Read(random[:])
-
-	// Create extensions
-	extensions := []Extension{
-		{
-			Type: extensionServerName,
-			Data: []byte{0x00, 0x0b, 0x00, 0x04, 0x03, 0x00, 0x01, 0x02}, // SNI extension data
-		},
-		{
-			Type: extensionSecureRenegotiation,
-			Data: []byte{0x00}, // Empty renegotiation info
-		},
-		{
-			Type: extensionSessionTicket,
-			Data: []byte{}, // Empty session ticket
-		},
-		{
-			Type: extensionHeartbeat,
-			Data: []byte{0x01}, // Peer allowed to send heartbeat requests
-		},
-	}
+// makeClientHello returns the ClientHello for phase. Probing after the
+// handshake offers only what handshake.Finish can complete.
+func makeClientHello(tlsVers int, serverName string, phase Phase) *handshake.ClientHello {
+	if phase == PhasePostHandshake {
+		hello := handshake.NewFullHandshakeHello(uint16(tlsVers), serverName) // #nosec G115
+		hello.HeartbeatMode = handshake.HeartbeatPeerAllowedToSend
 
-	// Create client hello message
-	msg := ClientHelloMessage{
-		ContentType:   recordTypeHandshake,
-		TLSVersion:    uint16(tlsVers), // #nosec G115
-		Length:        0xdc,            // Total length will be updated
-		HandshakeType: handshakeTypeHello,
-		HSLength:      [3]byte{0, 0, 0xd8}, // Will be updated
-		HSVersion:     uint16(tlsVers),     // #nosec G115
-		Random:        random,
-		SessionIDLen:  0,
-		CipherSuites:  defaultCipherSuites,
-		CompMethods:   []uint8{compressionNone},
-		Extensions:    extensions,
+		return hello
 	}
 
-	// Create a temporary buffer to calculate the handshake message length
-	hsBuf := new(bytes.Buffer)
-
-	// Write handshake message body
-	_ = binary.Write(hsBuf, binary.BigEndian, msg.HSVersion)
-	hsBuf.Write(msg.Random[:])
-	_ = binary.Write(hsBuf, binary.BigEndian, msg.SessionIDLen)
-
-	// Write cipher suites
-	_ = binary.Write(hsBuf, binary.BigEndian, uint16(len(msg.CipherSuites)*2)) // #nosec G115
-	for _, suite := range msg.CipherSuites {
-		_ = binary.Write(hsBuf, binary.BigEndian, suite)
+	return &handshake.ClientHello{
+		Version:           uint16(tlsVers), // #nosec G115
+		ServerName:        serverName,
+		CipherSuites:      defaultCipherSuites,
+		ECPointFormats:    defaultPointFormats,
+		RenegotiationInfo: true,
+		SessionTicket:     true,
+		HeartbeatMode:     handshake.HeartbeatPeerAllowedToSend,
 	}
+}
 
-	// Write compression methods
-	_ = binary.Write(hsBuf, binary.BigEndian, uint8(len(msg.CompMethods))) // #nosec G115
-	for _, comp := range msg.CompMethods {
-		_ = binary.Write(hsBuf, binary.BigEndian, comp)
-	}
-
-	// Write extensions length
-	extBuf := new(bytes.Buffer)
-	for _, ext := range msg.Extensions {
-		_ = binary.Write(extBuf, binary.BigEndian, ext.Type)
-		_ = binary.Write(extBuf, binary.BigEndian, uint16(len(ext.Data))) // #nosec G115
-		extBuf.Write(ext.Data)
-	}
-
-	_ = binary.Write(hsBuf, binary.BigEndian, uint16(extBuf.Len())) // #nosec G115
-	hsBuf.Write(extBuf.Bytes())
-
-	// Update lengths
-	hsLen := hsBuf.Len()
-	msg.Length = mustUint16Length(hsLen + 4) // +4 for handshake type and 24-bit length
-	msg.HSLength = mustUint24Length(hsLen)
-
-	// Write the final message
-	_ = binary.Write(buf, binary.BigEndian, msg.ContentType)
-	_ = binary.Write(buf, binary.BigEndian, msg.TLSVersion)
-	_ = binary.Write(buf, binary.BigEndian, msg.Length)
-	_ = binary.Write(buf, binary.BigEndian, msg.HandshakeType)
-	buf.Write(msg.HSLength[:])
-	buf.Write(hsBuf.Bytes())
-
-	return buf.Bytes()
+// clientHelloRecord frames a ClientHello body as a handshake record.
+func clientHelloRecord(tlsVers int, hello []byte) []byte {
+	return tlsrecord.Marshal(tlsrecord.TypeHandshake, uint16(tlsVers), // #nosec G115
+		tlsrecord.HandshakeMessage(tlsrecord.HandshakeClientHello, hello))
 }
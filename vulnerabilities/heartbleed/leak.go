@@ -0,0 +1,192 @@
+package heartbleed
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// FindingKind names the kind of secret spotted in leaked memory.
+type FindingKind string
+
+// Kinds of secret recognized by ScanLeak.
+const (
+	FindingPrivateKey    FindingKind = "private_key"
+	FindingCookie        FindingKind = "cookie"
+	FindingAuthorization FindingKind = "authorization"
+	FindingPassword      FindingKind = "password"
+)
+
+// maxFindings bounds the findings reported for one capture. A heap full
+// of session cookies says no more after the first few dozen.
+const maxFindings = 32
+
+// Leak describes the server memory returned by an over-long heartbeat.
+type Leak struct {
+	// Length is the number of leaked bytes captured.
+	Length int `json:"length"`
+	// SHA256 is the hex digest of the captured bytes, so captures can be
+	// correlated without passing the memory around.
+	SHA256 string `json:"sha256"`
+	// Findings lists the secrets spotted in the capture, redacted.
+	Findings []Finding `json:"findings,omitempty"`
+	// Raw holds the captured bytes. It is only kept when
+	// Heartbleed.KeepRawLeak is set and is never serialized.
+	Raw []byte `json:"-"`
+}
+
+// Finding is one secret spotted in leaked memory. The secret itself is
+// never included, nor anything derived from it: Snippet keeps only the
+// surrounding label and the secret's length.
+type Finding struct {
+	Kind    FindingKind `json:"kind"`
+	Offset  int         `json:"offset"`
+	Snippet string      `json:"snippet"`
+}
+
+// textPattern matches a labelled secret in text. The pattern has two
+// groups: the label kept in the snippet and the secret value.
+type textPattern struct {
+	kind FindingKind
+	re   *regexp.Regexp
+}
+
+var textPatterns = []textPattern{
+	{FindingPrivateKey, regexp.MustCompile(`(-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY-----)([A-Za-z0-9+/=\r\n:,\- ]*)`)},
+	{FindingCookie, regexp.MustCompile(`(?i)((?:set-)?cookie:[ \t]*)([^\r\n\x00]+)`)},
+	{FindingAuthorization, regexp.MustCompile(`(?i)((?:proxy-)?authorization:[ \t]*(?:[a-z0-9-]+ )?)([^\r\n\x00]+)`)},
+	{FindingPassword, regexp.MustCompile(`(?i)((?:pass(?:word|wd)?|pwd)["']?[ \t]*[=:][ \t]*["']?)([^&\s"';,\x00]+)`)},
+}
+
+// derPrefixes start a DER encoded RSA private key: a SEQUENCE with a
+// two-byte length holding version 0 and then either the modulus
+// (PKCS #1) or the rsaEncryption algorithm identifier (PKCS #8). The
+// length bytes at offsets 2 and 3 are wildcards.
+var derPrefixes = [][]byte{
+	{0x30, 0x82, 0, 0, 0x02, 0x01, 0x00, 0x02, 0x82},
+	{0x30, 0x82, 0, 0, 0x02, 0x01, 0x00, 0x30, 0x0d, 0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x01, 0x01},
+}
+
+// ScanLeak hashes data and looks for private keys, cookies,
+// Authorization headers and passwords in it.
+func ScanLeak(data []byte) *Leak {
+	sum := sha256.Sum256(data)
+
+	leak := &Leak{
+		Length: len(data),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+
+	for _, p := range textPatterns {
+		for _, m := range p.re.FindAllSubmatchIndex(data, -1) {
+			label, secret := data[m[2]:m[3]], data[m[4]:m[5]]
+			leak.add(p.kind, m[0], redact(p.kind, label, secret))
+		}
+	}
+
+	for _, off := range derKeys(data) {
+		// the SEQUENCE length plus its four byte header, cut at the
+		// end of the capture
+		n := min((int(data[off+2])<<8|int(data[off+3]))+4, len(data)-off)
+		leak.add(FindingPrivateKey, off, "DER RSA private key "+redacted(n))
+	}
+
+	return leak
+}
+
+// add records a finding unless maxFindings has been reached.
+func (l *Leak) add(kind FindingKind, offset int, snippet string) {
+	if len(l.Findings) >= maxFindings {
+		return
+	}
+
+	l.Findings = append(l.Findings, Finding{Kind: kind, Offset: offset, Snippet: snippet})
+}
+
+// Kinds returns the distinct kinds of secret found, in order of first
+// appearance in Findings.
+func (l *Leak) Kinds() []string {
+	var kinds []string
+
+	for _, f := range l.Findings {
+		if !slices.Contains(kinds, string(f.Kind)) {
+			kinds = append(kinds, string(f.Kind))
+		}
+	}
+
+	return kinds
+}
+
+// redact renders a finding with its label and the secret replaced by
+// its length. Cookie names are kept so the affected session is known.
+func redact(kind FindingKind, label, secret []byte) string {
+	if kind != FindingCookie {
+		return printable(label) + redacted(len(secret))
+	}
+
+	var parts []string
+
+	for part := range strings.SplitSeq(string(secret), ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			parts = append(parts, redacted(len(name)))
+
+			continue
+		}
+
+		parts = append(parts, printable([]byte(name))+"="+redacted(len(value)))
+	}
+
+	return printable(label) + strings.Join(parts, "; ")
+}
+
+func redacted(n int) string {
+	return fmt.Sprintf("[REDACTED %d bytes]", n)
+}
+
+// printable replaces bytes outside printable ASCII with dots, as hex
+// dumps do, so snippets are safe to print.
+func printable(b []byte) string {
+	out := bytes.Clone(b)
+
+	for i, c := range out {
+		if c < 0x20 || c > 0x7e {
+			out[i] = '.'
+		}
+	}
+
+	return string(out)
+}
+
+// derKeys returns the offsets of the DER private key headers in data.
+func derKeys(data []byte) []int {
+	var offsets []int
+
+	for off := 0; off+4 < len(data); off++ {
+		if data[off] != 0x30 || data[off+1] != 0x82 {
+			continue
+		}
+
+		for _, prefix := range derPrefixes {
+			if hasDERPrefix(data[off:], prefix) {
+				offsets = append(offsets, off)
+
+				break
+			}
+		}
+	}
+
+	return offsets
+}
+
+func hasDERPrefix(data, prefix []byte) bool {
+	if len(data) < len(prefix) {
+		return false
+	}
+
+	return bytes.Equal(data[:2], prefix[:2]) && bytes.Equal(data[4:len(prefix)], prefix[4:])
+}
@@ -0,0 +1,93 @@
+package heartbleed
+
+import (
+	"context"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
+)
+
+// Sweep selects whether CheckContext tries every protocol version
+// instead of the one it is given.
+type Sweep int
+
+// Sweep modes.
+const (
+	// SweepOff tests only the version passed to Check.
+	SweepOff Sweep = iota
+	// SweepFirst tests versions from SSLv3 up and stops at the first
+	// conclusive verdict, vulnerable or not.
+	SweepFirst
+	// SweepAll tests every version.
+	SweepAll
+)
+
+// sweepVersions are tried in order by a sweep.
+var sweepVersions = []uint16{
+	tlsrecord.VersionSSL30,
+	tlsrecord.VersionTLS10,
+	tlsrecord.VersionTLS11,
+	tlsrecord.VersionTLS12,
+}
+
+// VersionName returns the name used for version in Heartbleed.Versions.
+func VersionName(version uint16) string {
+	return tlsrecord.VersionName(version)
+}
+
+// verdictRank orders statuses by how much they say about the server: a
+// leak outranks a patched server, which outranks a version without the
+// extension and, last, a failed probe.
+func verdictRank(status checker.Status) int {
+	switch status {
+	case checker.StatusVulnerable:
+		return 3
+	case checker.StatusNotVulnerable:
+		return 2
+	case checker.StatusNotApplicable:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// sweep runs the check once per version in sweepVersions, each on its
+// own connections, and keeps the results of the most telling version.
+// The verdict of every version tried is recorded in Versions.
+func (h *Heartbleed) sweep(ctx context.Context, host, port string) error {
+	var (
+		best    *Heartbleed
+		bestErr error
+	)
+
+	h.Versions = make(map[string]checker.Status, len(sweepVersions))
+
+	for _, version := range sweepVersions {
+		probe := h.probeConfig()
+
+		err := probe.CheckContext(ctx, host, port, int(version))
+		h.Versions[VersionName(version)] = probe.Vulnerable
+
+		// a cancelled sweep says nothing about the versions left
+		if ctx.Err() != nil {
+			h.Vulnerable = checker.StatusError
+
+			return ctx.Err()
+		}
+
+		if best == nil || verdictRank(probe.Vulnerable) > verdictRank(best.Vulnerable) {
+			probe.Version = VersionName(version)
+			best, bestErr = &probe, err
+		}
+
+		if h.Sweep == SweepFirst && verdictRank(probe.Vulnerable) >= verdictRank(checker.StatusNotVulnerable) {
+			break
+		}
+	}
+
+	mode, versions := h.Sweep, h.Versions
+	*h = *best
+	h.Sweep, h.Versions = mode, versions
+
+	return bestErr
+}
@@ -0,0 +1,49 @@
+package poodle
+
+import (
+	"context"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+)
+
+// Name identifies the POODLE check.
+const Name = "poodle"
+
+var _ checker.Checker = (*Checker)(nil)
+
+// Checker adapts Poodle to the checker.Checker interface.
+type Checker struct {
+	// Timeouts overrides the per-phase defaults of the check.
+	Timeouts checker.Timeouts
+	// Dialer opens the connection to the target. Nil dials directly.
+	Dialer checker.Dialer
+	// Protocol is negotiated with STARTTLS before the ClientHello.
+	// Empty infers it from the port.
+	Protocol checker.Protocol
+}
+
+// Name returns the name of the check.
+func (c *Checker) Name() string {
+	return Name
+}
+
+// Check runs the POODLE check against target.
+func (c *Checker) Check(ctx context.Context, target checker.Target) checker.Result {
+	res := checker.NewResult(Name, target)
+
+	p := Poodle{Timeouts: c.Timeouts, Dialer: c.Dialer, ServerName: target.SNI(), Protocol: c.Protocol}
+
+	err := p.CheckContext(ctx, target.Host, target.Port)
+
+	if p.StartTLS != "" {
+		res.AddEvidence("starttls", string(p.StartTLS))
+	}
+
+	if p.CipherSuite != "" {
+		res.AddEvidence("cipher", p.CipherSuite)
+	}
+
+	res.Finish(p.Vulnerable, err)
+
+	return res
+}
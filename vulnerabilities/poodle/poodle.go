@@ -0,0 +1,183 @@
+package poodle
+
+import (
+	"context"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/internal/handshake"
+	"github.com/jsandas/tls-vuln-checker/internal/netutil"
+	"github.com/jsandas/tls-vuln-checker/internal/starttls"
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
+)
+
+/*
+SSL 3.0 uses nondeterministic CBC padding, which makes it easier for
+man-in-the-middle attackers to obtain cleartext data via a
+padding-oracle attack, aka the "POODLE" issue (CVE-2014-3566).
+
+A server is affected when it still negotiates SSLv3 with a CBC cipher.
+*/
+
+type Poodle struct {
+	Vulnerable checker.Status `json:"vulnerable"`
+	// CipherSuite is the CBC suite the server accepted over SSLv3.
+	CipherSuite string `json:"cipher,omitempty"`
+	// StartTLS is the protocol negotiated before TLS.
+	StartTLS checker.Protocol `json:"starttls"`
+
+	// Timeouts overrides the per-phase defaults used by CheckContext.
+	Timeouts checker.Timeouts `json:"-"`
+	// Dialer opens the connection to the target. Nil dials directly.
+	Dialer checker.Dialer `json:"-"`
+	// ServerName is announced during STARTTLS where the protocol asks
+	// for it. Empty uses the host unless it is an IP address. It is not
+	// sent in the ClientHello, which carries no extensions.
+	ServerName string `json:"-"`
+	// Protocol is negotiated with STARTTLS before the ClientHello.
+	// Empty infers it from the port.
+	Protocol checker.Protocol `json:"-"`
+}
+
+// defaultTimeouts apply to any phase not set in Poodle.Timeouts.
+var defaultTimeouts = checker.Timeouts{
+	Dial:      3 * time.Second,
+	StartTLS:  3 * time.Second,
+	Handshake: 3 * time.Second,
+	Write:     2 * time.Second,
+}
+
+// cbcCipherSuites are the SSLv3 CBC suites offered in the ClientHello.
+var cbcCipherSuites = []uint16{
+	0xc014, // TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA
+	0xc00a, // TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA
+	0x0039, // TLS_DHE_RSA_WITH_AES_256_CBC_SHA
+	0x0038, // TLS_DHE_DSS_WITH_AES_256_CBC_SHA
+	0x0088, // TLS_DHE_RSA_WITH_CAMELLIA_256_CBC_SHA
+	0x0087, // TLS_DHE_DSS_WITH_CAMELLIA_256_CBC_SHA
+	0x0035, // TLS_RSA_WITH_AES_256_CBC_SHA
+	0x0084, // TLS_RSA_WITH_CAMELLIA_256_CBC_SHA
+	0xc012, // TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA
+	0xc008, // TLS_ECDHE_ECDSA_WITH_3DES_EDE_CBC_SHA
+	0x0016, // TLS_DHE_RSA_WITH_3DES_EDE_CBC_SHA
+	0x0013, // TLS_DHE_DSS_WITH_3DES_EDE_CBC_SHA
+	0x000a, // TLS_RSA_WITH_3DES_EDE_CBC_SHA
+	0xc013, // TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA
+	0xc009, // TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA
+	0x0033, // TLS_DHE_RSA_WITH_AES_128_CBC_SHA
+	0x0032, // TLS_DHE_DSS_WITH_AES_128_CBC_SHA
+	0x009a, // TLS_DHE_RSA_WITH_SEED_CBC_SHA
+	0x0099, // TLS_DHE_DSS_WITH_SEED_CBC_SHA
+	0x0045, // TLS_DHE_RSA_WITH_CAMELLIA_128_CBC_SHA
+	0x0044, // TLS_DHE_DSS_WITH_CAMELLIA_128_CBC_SHA
+	0x002f, // TLS_RSA_WITH_AES_128_CBC_SHA
+	0x0096, // TLS_RSA_WITH_SEED_CBC_SHA
+	0x0041, // TLS_RSA_WITH_CAMELLIA_128_CBC_SHA
+	0x0007, // TLS_RSA_WITH_IDEA_CBC_SHA
+	0x0015, // TLS_DHE_RSA_WITH_DES_CBC_SHA
+	0x0012, // TLS_DHE_DSS_WITH_DES_CBC_SHA
+	0x0009, // TLS_RSA_WITH_DES_CBC_SHA
+	0x0014, // TLS_DHE_RSA_EXPORT_WITH_DES40_CBC_SHA
+	0x0011, // TLS_DHE_DSS_EXPORT_WITH_DES40_CBC_SHA
+	0x0008, // TLS_RSA_EXPORT_WITH_DES40_CBC_SHA
+	0x0006, // TLS_RSA_EXPORT_WITH_RC2_CBC_40_MD5
+}
+
+// Check for POODLE (CVE-2014-3566).
+func (p *Poodle) Check(host string, port string) error {
+	return p.CheckContext(context.Background(), host, port)
+}
+
+// CheckContext offers only SSLv3 with CBC suites and reports the server
+// as vulnerable when it accepts. Every phase is bounded by its entry in
+// Timeouts and aborted as soon as ctx is done.
+func (p *Poodle) CheckContext(ctx context.Context, host string, port string) error {
+	timeouts := p.Timeouts.WithDefaults(defaultTimeouts)
+
+	conn, err := netutil.Dial(ctx, p.Dialer, "tcp", net.JoinHostPort(host, port), timeouts.Dial)
+	if err != nil {
+		p.Vulnerable = checker.StatusError
+
+		return err
+	}
+	defer conn.Close()
+
+	stop := netutil.AbortOnDone(ctx, conn)
+	defer stop()
+
+	serverName := p.ServerName
+	if serverName == "" {
+		serverName = checker.DefaultServerName(host)
+	}
+
+	p.StartTLS = starttls.Resolve(p.Protocol, port)
+
+	err = starttls.Start(ctx, conn, p.StartTLS, host, serverName, timeouts.StartTLS)
+	if err != nil {
+		p.Vulnerable = checker.StatusError
+
+		return err
+	}
+
+	clientHello, err := makeClientHello()
+	if err != nil {
+		p.Vulnerable = checker.StatusError
+
+		return err
+	}
+
+	err = netutil.Write(ctx, conn, clientHello, timeouts.Write)
+	if err != nil {
+		p.Vulnerable = checker.StatusError
+
+		return err
+	}
+
+	err = netutil.SetReadDeadline(ctx, conn, timeouts.Handshake)
+	if err != nil {
+		p.Vulnerable = checker.StatusError
+
+		return err
+	}
+
+	// only the ServerHello matters, so a flight that breaks off after
+	// it still gives a verdict
+	flight, err := handshake.ReadServerFlight(tlsrecord.NewReader(conn))
+	if flight.ServerHello == nil {
+		if err != nil && !tlsrecord.Refused(err) {
+			p.Vulnerable = checker.StatusError
+
+			return err
+		}
+
+		p.Vulnerable = checker.StatusNotVulnerable
+
+		return nil
+	}
+
+	hello := flight.ServerHello
+	if hello.Version != tlsrecord.VersionSSL30 || !slices.Contains(cbcCipherSuites, hello.CipherSuite) {
+		p.Vulnerable = checker.StatusNotVulnerable
+
+		return nil
+	}
+
+	p.Vulnerable = checker.StatusVulnerable
+	p.CipherSuite = handshake.CipherSuiteName(hello.CipherSuite)
+
+	return nil
+}
+
+// makeClientHello returns an SSLv3 ClientHello offering only CBC suites.
+// SSLv3 predates extensions and some of its stacks reject a hello that
+// has any, so not even server_name is sent.
+func makeClientHello() ([]byte, error) {
+	hello := &handshake.ClientHello{
+		Version:      tlsrecord.VersionSSL30,
+		CipherSuites: cbcCipherSuites,
+	}
+
+	return hello.Record()
+}
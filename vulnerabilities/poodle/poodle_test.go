@@ -0,0 +1,184 @@
+package poodle
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsandas/tls-vuln-checker/checker"
+	"github.com/jsandas/tls-vuln-checker/internal/handshake"
+	"github.com/jsandas/tls-vuln-checker/internal/starttls/starttlstest"
+	"github.com/jsandas/tls-vuln-checker/internal/tlsrecord"
+)
+
+// sslv3Server negotiates proto and then answers the ClientHello with a
+// ServerHello for suite at version, or with a handshake_failure alert
+// when suite is zero or the hello carries extensions, as strict SSLv3
+// stacks do. The version of each ClientHello is sent on the channel.
+func sslv3Server(t *testing.T, proto checker.Protocol, version, suite uint16) (string, string, <-chan uint16) {
+	t.Helper()
+
+	lc := net.ListenConfig{}
+
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	t.Cleanup(func() { ln.Close() })
+
+	versions := make(chan uint16, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+		err = starttlstest.Serve(conn, proto)
+		if err != nil {
+			return
+		}
+
+		records := tlsrecord.NewReader(conn)
+
+		_, body, err := records.ReadHandshake()
+		if err != nil {
+			return
+		}
+
+		info, err := handshake.ParseClientHello(body)
+		if err != nil {
+			return
+		}
+
+		versions <- info.Version
+
+		if suite == 0 || len(info.Extensions) > 0 {
+			conn.Write(tlsrecord.Marshal(tlsrecord.TypeAlert, tlsrecord.VersionSSL30,
+				[]byte{tlsrecord.AlertLevelFatal, tlsrecord.AlertHandshakeFailure}))
+
+			return
+		}
+
+		hello := []byte{byte(version >> 8), byte(version)}
+		hello = append(hello, make([]byte, 32)...)
+		hello = append(hello, 0x00, byte(suite>>8), byte(suite), 0x00)
+
+		var flight []byte
+		flight = append(flight, tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHello, hello)...)
+		flight = append(flight, tlsrecord.HandshakeMessage(tlsrecord.HandshakeServerHelloDone, nil)...)
+		conn.Write(tlsrecord.Marshal(tlsrecord.TypeHandshake, version, flight))
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	return host, port, versions
+}
+
+func TestPoodleVulnerable(t *testing.T) {
+	host, port, versions := sslv3Server(t, checker.ProtocolNone, tlsrecord.VersionSSL30, 0x000a)
+
+	// a server name must not add an extension the server would refuse
+	p := Poodle{ServerName: "sslv3.example.com"}
+
+	err := p.Check(host, port)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	if p.Vulnerable != checker.StatusVulnerable || p.CipherSuite != "TLS_RSA_WITH_3DES_EDE_CBC_SHA" {
+		t.Errorf("Wrong return, got: %s/%s, want: %s", p.Vulnerable, p.CipherSuite, checker.StatusVulnerable)
+	}
+
+	if version := <-versions; version != tlsrecord.VersionSSL30 {
+		t.Errorf("ClientHello offered version %x, want SSLv3", version)
+	}
+}
+
+func TestPoodleNotVulnerable(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint16
+		suite   uint16
+	}{
+		{name: "Refused", suite: 0},
+		{name: "NewerVersion", version: tlsrecord.VersionTLS10, suite: 0x000a},
+		{name: "NotCBC", version: tlsrecord.VersionSSL30, suite: 0x0005},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port, _ := sslv3Server(t, checker.ProtocolNone, tt.version, tt.suite)
+
+			var p Poodle
+
+			err := p.Check(host, port)
+			if err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+
+			if p.Vulnerable != checker.StatusNotVulnerable {
+				t.Errorf("Wrong return, got: %s, want: %s", p.Vulnerable, checker.StatusNotVulnerable)
+			}
+		})
+	}
+
+	// crypto/tls dropped SSLv3 entirely
+	t.Run("GoServer", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(http.NotFoundHandler())
+		server.TLS = &tls.Config{MinVersion: tls.VersionTLS10}
+		server.StartTLS()
+		defer server.Close()
+
+		host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "https://"))
+
+		var p Poodle
+
+		err := p.Check(host, port)
+		if err != nil || p.Vulnerable != checker.StatusNotVulnerable {
+			t.Errorf("Wrong return, got: %s/%v, want: %s", p.Vulnerable, err, checker.StatusNotVulnerable)
+		}
+	})
+}
+
+func TestPoodleChecker(t *testing.T) {
+	host, port, _ := sslv3Server(t, checker.ProtocolNone, tlsrecord.VersionSSL30, 0x002f)
+
+	res := (&Checker{}).Check(context.Background(), checker.Target{Host: host, Port: port})
+	if res.Status != checker.StatusVulnerable || res.Evidence["cipher"] != "TLS_RSA_WITH_AES_128_CBC_SHA" {
+		t.Errorf("Wrong result, got: %s %v", res.Status, res.Evidence)
+	}
+
+	res = (&Checker{}).Check(context.Background(), checker.Target{Host: "127.0.0.1", Port: "1"})
+	if res.Status != checker.StatusError || res.Err == nil {
+		t.Errorf("Expected error result, got: %s/%v", res.Status, res.Err)
+	}
+}
+
+func TestPoodleStartTLS(t *testing.T) {
+	for _, proto := range checker.Protocols {
+		t.Run(string(proto), func(t *testing.T) {
+			host, port, _ := sslv3Server(t, proto, tlsrecord.VersionSSL30, 0x000a)
+
+			p := Poodle{Protocol: proto}
+
+			err := p.Check(host, port)
+			if err != nil || p.Vulnerable != checker.StatusVulnerable {
+				t.Errorf("Wrong return, got: %s/%v, want: %s", p.Vulnerable, err, checker.StatusVulnerable)
+			}
+
+			if p.StartTLS != proto {
+				t.Errorf("Wrong STARTTLS protocol, got: %q, want: %q", p.StartTLS, proto)
+			}
+		})
+	}
+}